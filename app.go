@@ -5,50 +5,150 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"runtime"
+	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/exapsy/peekprof/internal/budget"
 	"github.com/exapsy/peekprof/internal/extractors"
 	httphandler "github.com/exapsy/peekprof/internal/handlers/http"
 	"github.com/exapsy/peekprof/internal/process"
+	"github.com/exapsy/peekprof/internal/tui"
 )
 
 type App struct {
 	process           process.Process
+	pid               int32
 	runsExecutable    bool
 	executable        *exec.Cmd
+	executableDone    chan struct{}
+	samplingDone      chan struct{}
+	exitedAt          time.Time
+	exitStatus        *process.ExitStatus
+	budgetChecker     *budget.Checker
+	budgetViolations  []budget.Violation
+	killedByBudget    bool
 	ctx               context.Context
 	cancel            context.CancelFunc
 	peakMem           int64
+	peakMetric        process.MemoryMetric
 	htmlFilename      string
 	csvFilename       string
 	refreshInterval   time.Duration
 	extractor         extractors.Extractors
-	chartLiveUpdates  bool
+	summary           *extractors.SummaryCollector
+	metrics           *extractors.MetricsCollector
+	markers           chan string
+	markerCount       int
+	exitSignals       chan os.Signal
+	refreshIntervals  chan time.Duration
+	tui               *tui.Dashboard
+	showTui           bool
 	host              string
 	eventSourceBroker *httphandler.EventSourceServer
+	history           *extractors.TimeSeries
+	openBrowser       bool
 	server            *http.Server
+	serves            bool
 	noProfilerOutput  bool
+	noSummary         bool
 	pretty            bool
 	showConsole       bool
 }
 
 type AppOptions struct {
-	PID              int32
-	Host             string
-	RunsExecutable   bool
-	Cmd              *exec.Cmd
-	HtmlFilename     string
-	CsvFilename      string
-	RefreshInterval  time.Duration
-	ChartLiveUpdates bool
-	NoProfilerOutput bool
-	Pretty           bool
-	ShowConsole      bool
+	PID            int32
+	Host           string
+	RunsExecutable bool
+	Cmd            *exec.Cmd
+	HtmlFilename   string
+	// TimeSeries is the retention of the samples of the html chart and of the live dashboard's history
+	TimeSeries extractors.TimeSeriesOptions
+	// HtmlAssets are where the scripts of the html chart come from
+	HtmlAssets  extractors.HtmlAssets
+	CsvFilename string
+	// CsvExitStatus appends the exit status of the command to CsvFilename as # comment lines
+	CsvExitStatus bool
+	// CsvProcessesFilename is the csv to which the stats of each process of the tree are extracted
+	CsvProcessesFilename string
+	RefreshInterval      time.Duration
+	ChartLiveUpdates     bool
+	NoProfilerOutput     bool
+	Pretty               bool
+	ShowConsole          bool
+	// PeakMetric is the memory metric that drives the peak memory
+	PeakMetric process.MemoryMetric
+	// Budget are the limits that the process should stay within
+	Budget budget.Budget
+	// SummaryJsonFilename is the json file to which the summary of the run is extracted
+	SummaryJsonFilename string
+	// LeakAnalysis analyses the memory trend of the run, if set
+	LeakAnalysis *extractors.LeakAnalysisOptions
+	// JsonFilename is the json file to which the run metadata and every sample are extracted
+	JsonFilename string
+	// NdjsonFilename is the newline delimited json file to which every sample is extracted as it is taken
+	NdjsonFilename string
+	// PrometheusFilename is the file to which the metrics are periodically written
+	// for node_exporter's textfile collector
+	PrometheusFilename string
+	// PrometheusFormat is the format of PrometheusFilename
+	PrometheusFormat extractors.MetricsFormat
+	// PrometheusInterval is how often PrometheusFilename is rewritten
+	PrometheusInterval time.Duration
+	// Labels are added to every series of PrometheusFilename
+	Labels [][2]string
+	// Influx is the file, http or udp endpoint to which samples are streamed in the InfluxDB line protocol
+	Influx string
+	// InfluxToken authenticates to the http endpoint of InfluxDB 2
+	InfluxToken string
+	// Graphite is the host:port to which samples are streamed in the Graphite plaintext protocol
+	Graphite string
+	// GraphitePrefix is prepended to every Graphite metric path
+	GraphitePrefix string
+	// Statsd is the host:port of the StatsD agent to which every sample is sent as gauges
+	Statsd string
+	// StatsdPrefix is prepended to every StatsD metric name
+	StatsdPrefix string
+	// StatsdTags are added to every StatsD metric in the DogStatsD format
+	StatsdTags [][2]string
+	// StatsdSampleRate is the fraction of the StatsD metrics that are sent
+	StatsdSampleRate float64
+	// StatsdWindow averages the samples over a time window before they are sent to StatsD
+	StatsdWindow time.Duration
+	// Otlp is the OTLP/HTTP endpoint to which samples are exported as OpenTelemetry metrics
+	Otlp string
+	// OtlpEncoding is how the OTLP payloads are encoded
+	OtlpEncoding extractors.OtlpEncoding
+	// OtlpHeaders are added to every OTLP request
+	OtlpHeaders [][2]string
+	// TraceFilename is the file to which the run is extracted in the Trace Event Format
+	TraceFilename string
+	// SessionFilename is the file to which the run is recorded, for the report subcommand
+	SessionFilename string
+	// PprofFilename is the file to which the run is extracted as a pprof profile
+	PprofFilename string
+	// Serve runs the http server even without an html chart, to serve the metrics
+	Serve bool
+	// LiveQueueSize is how many live updates are queued for each client of the dashboard
+	LiveQueueSize int
+	// SlowClientPolicy is what happens to a client of the dashboard that does not keep up
+	SlowClientPolicy httphandler.SlowClientPolicy
+	// OpenBrowser opens the live dashboard in the browser, with ChartLiveUpdates and an html chart
+	OpenBrowser bool
+	// BenchmarkRun collects the run, when it is one of the runs of a benchmark
+	BenchmarkRun *extractors.BenchmarkRun
+	// NoSummary stops printing the summary of the run at exit, e.g. when the runs of a benchmark are summarised together
+	NoSummary bool
+	// Tui shows a dashboard that takes over the terminal instead of the console output
+	Tui bool
 }
 
 func NewApp(opts *AppOptions) *App {
@@ -67,47 +167,158 @@ func NewApp(opts *AppOptions) *App {
 	if opts.Host == "" {
 		opts.Host = "localhost:8089"
 	}
+	if opts.PeakMetric == "" {
+		opts.PeakMetric = process.MemoryMetricRss
+	}
+
+	cmdline, err := p.GetCmdline()
+	if err != nil {
+		panic(fmt.Errorf("could not get process cmdline: %w", err))
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(fmt.Errorf("could not get hostname: %w", err))
+	}
+	run := extractors.RunMetadata{
+		Pid:             opts.PID,
+		Name:            pname,
+		Cmdline:         cmdline,
+		Hostname:        hostname,
+		StartTime:       time.Now(),
+		RefreshInterval: opts.RefreshInterval,
+	}
 
 	var exts []interface{}
 	if opts.CsvFilename != "" {
 		csvExtractorOpts := extractors.NewCsvExtractorOptions(opts.CsvFilename)
+		csvExtractorOpts.WithExitStatus(opts.CsvExitStatus)
 		exts = append(exts, csvExtractorOpts)
 	}
+	if opts.SummaryJsonFilename != "" {
+		exts = append(exts, extractors.NewSummaryJsonExtractorOptions(opts.SummaryJsonFilename))
+	}
+	if opts.JsonFilename != "" {
+		exts = append(exts, extractors.NewJsonExtractorOptions(opts.JsonFilename, run))
+	}
+	if opts.NdjsonFilename != "" {
+		exts = append(exts, extractors.NewNdjsonExtractorOptions(opts.NdjsonFilename, run))
+	}
+	if opts.PrometheusFilename != "" {
+		exts = append(exts, extractors.NewPrometheusFileExtractorOptions(
+			opts.PrometheusFilename,
+			opts.PrometheusFormat,
+			opts.PrometheusInterval,
+			run,
+			opts.Labels,
+		))
+	}
+	if opts.Influx != "" {
+		exts = append(exts, extractors.NewInfluxExtractorOptions(opts.Influx, opts.InfluxToken, run))
+	}
+	if opts.Graphite != "" {
+		exts = append(exts, extractors.NewGraphiteExtractorOptions(opts.Graphite, opts.GraphitePrefix, run))
+	}
+	if opts.Statsd != "" {
+		exts = append(exts, extractors.NewStatsdExtractorOptions(
+			opts.Statsd,
+			opts.StatsdPrefix,
+			opts.StatsdTags,
+			opts.StatsdSampleRate,
+			opts.StatsdWindow,
+			run,
+		))
+	}
+	if opts.Otlp != "" {
+		exts = append(exts, extractors.NewOtlpExtractorOptions(opts.Otlp, opts.OtlpEncoding, opts.OtlpHeaders, run))
+	}
+	if opts.TraceFilename != "" {
+		exts = append(exts, extractors.NewTraceExtractorOptions(opts.TraceFilename, run))
+	}
+	if opts.SessionFilename != "" {
+		exts = append(exts, extractors.NewSessionExtractorOptions(opts.SessionFilename, run))
+	}
+	if opts.PprofFilename != "" {
+		exts = append(exts, extractors.NewPprofExtractorOptions(opts.PprofFilename, run))
+	}
+	if opts.CsvProcessesFilename != "" {
+		exts = append(exts, extractors.NewCsvProcessesExtractorOptions(opts.CsvProcessesFilename))
+	}
+	if opts.BenchmarkRun != nil {
+		exts = append(exts, extractors.NewBenchmarkRunExtractorOptions(opts.BenchmarkRun))
+	}
 	if opts.HtmlFilename != "" {
 		chartExtractorOpts := extractors.NewChartExtractorOptions(pname, opts.HtmlFilename)
-		if opts.ChartLiveUpdates {
-			chartExtractorOpts.UpdateLive(opts.Host)
-		}
+		chartExtractorOpts.WithAssets(opts.HtmlAssets)
+		chartExtractorOpts.WithTimeSeries(opts.TimeSeries)
 		exts = append(exts, chartExtractorOpts)
 	}
 
 	extractor := extractors.NewExtractors(exts...)
 
+	metrics := extractors.NewMetricsCollector(run, nil)
+	markers := make(chan string, 16)
+
 	var esb *httphandler.EventSourceServer
+	var history *extractors.TimeSeries
 	var server *http.Server
-	if opts.ChartLiveUpdates {
-		esb = httphandler.NewEventSourceServer()
+	serves := opts.Serve || (opts.ChartLiveUpdates && opts.HtmlFilename != "")
+	if opts.ChartLiveUpdates || opts.Serve {
+		esb = httphandler.NewEventSourceServer(opts.LiveQueueSize, opts.SlowClientPolicy, httphandler.DefaultClientWriteTimeout)
+		history = extractors.NewTimeSeries(opts.TimeSeries)
 		h := http.NewServeMux()
+		h.Handle("/", httphandler.NewDashboardHandler(extractors.NewDashboard(pname, opts.HtmlAssets, opts.TimeSeries)))
+		if opts.HtmlAssets.Mode == extractors.HtmlAssetsDir {
+			h.Handle(extractors.HtmlAssetsPath, http.StripPrefix(extractors.HtmlAssetsPath, http.FileServer(http.Dir(opts.HtmlAssets.Dir))))
+		}
 		h.Handle("/process/updates", esb)
-		server = &http.Server{Addr: opts.Host, Handler: h}
+		h.Handle("/process/history", httphandler.NewHistoryHandler(history))
+		h.Handle("/metrics", httphandler.NewMetricsHandler(metrics, esb))
+		h.Handle("/markers", httphandler.NewMarkersHandler(func(name string) {
+			addMarker(markers, name)
+		}))
+		server = &http.Server{Addr: opts.Host, Handler: h, ConnContext: httphandler.ConnContext}
+	}
+
+	summary := extractors.NewSummaryCollector(opts.RefreshInterval)
+	if opts.LeakAnalysis != nil {
+		summary.AnalyzeLeaks(*opts.LeakAnalysis)
+	}
+
+	var budgetChecker *budget.Checker
+	if !opts.Budget.IsEmpty() {
+		budgetChecker = budget.NewChecker(opts.Budget, time.Now())
 	}
 
 	return &App{
+		budgetChecker:     budgetChecker,
 		runsExecutable:    opts.RunsExecutable,
 		process:           p,
+		pid:               opts.PID,
 		ctx:               ctx,
 		cancel:            cancel,
 		executable:        opts.Cmd,
+		executableDone:    make(chan struct{}),
+		samplingDone:      make(chan struct{}),
 		peakMem:           0,
+		peakMetric:        opts.PeakMetric,
 		htmlFilename:      opts.HtmlFilename,
 		csvFilename:       opts.CsvFilename,
 		refreshInterval:   opts.RefreshInterval,
 		extractor:         extractor,
+		summary:           summary,
+		metrics:           metrics,
+		markers:           markers,
+		exitSignals:       make(chan os.Signal, 1),
+		refreshIntervals:  make(chan time.Duration, 1),
+		showTui:           opts.Tui,
 		host:              opts.Host,
-		chartLiveUpdates:  opts.ChartLiveUpdates,
 		eventSourceBroker: esb,
+		history:           history,
+		openBrowser:       opts.OpenBrowser && opts.ChartLiveUpdates && opts.HtmlFilename != "",
 		server:            server,
+		serves:            serves,
 		noProfilerOutput:  opts.NoProfilerOutput,
+		noSummary:         opts.NoSummary,
 		pretty:            opts.Pretty,
 		showConsole:       opts.ShowConsole,
 	}
@@ -117,21 +328,31 @@ func (a *App) Start() {
 	wg := &sync.WaitGroup{}
 
 	a.startHttpServer(wg)
+	a.watchMarkerSignals()
 	a.handleExit(wg)
+	a.startTui()
 	a.watchMemoryUsage(wg)
 	a.watchExecutable(wg)
 	wg.Wait()
 }
 
 func (a *App) startHttpServer(wg *sync.WaitGroup) {
-	if !a.chartLiveUpdates || a.htmlFilename == "" {
+	if !a.serves {
 		return
 	}
+	if a.pretty {
+		fmt.Printf("serving metrics at http://%s/metrics\n", a.host)
+	}
+	// The server listens before the browser is opened, so that the dashboard is there when it loads
+	ln, err := net.Listen("tcp", a.server.Addr)
+	if err != nil {
+		panic(err)
+	}
 	wg.Add(1)
 	// add wg.done
 	go func() {
 		defer wg.Done()
-		err := a.server.ListenAndServe()
+		err := a.server.Serve(ln)
 		if errors.Is(err, http.ErrServerClosed) {
 			return
 		}
@@ -139,6 +360,40 @@ func (a *App) startHttpServer(wg *sync.WaitGroup) {
 			panic(err)
 		}
 	}()
+
+	if a.openBrowser {
+		url := dashboardURL(a.host)
+		fmt.Printf("\nLive monitoring is hosted on %s\n\n", url)
+		if err := openBrowser(url); err != nil {
+			fmt.Printf("could not open the browser, open %s instead: %s\n", url, err)
+		}
+	}
+}
+
+// dashboardURL returns the url of the dashboard served at addr,
+// at localhost when the server listens on every interface
+func dashboardURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Sprintf("http://%s/", addr)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(host, port))
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "linux":
+		return exec.Command("xdg-open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		return exec.Command("open", url).Start()
+	default:
+		return fmt.Errorf("unsupported platform")
+	}
 }
 
 func (a *App) watchExecutable(wg *sync.WaitGroup) {
@@ -149,6 +404,12 @@ func (a *App) watchExecutable(wg *sync.WaitGroup) {
 	go func() {
 		defer wg.Done()
 		a.executable.Wait()
+		a.exitedAt = time.Now()
+		if a.executable.ProcessState != nil {
+			status := process.NewExitStatus(a.executable.ProcessState)
+			a.exitStatus = &status
+		}
+		close(a.executableDone)
 		a.cancel()
 	}()
 }
@@ -157,72 +418,105 @@ func (a *App) watchMemoryUsage(wg *sync.WaitGroup) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer close(a.samplingDone)
 		defer a.cancel()
 		if a.showConsole && !a.pretty {
-			fmt.Printf("timestamp, rss kb, virtual kb, %%cpu\n")
+			fmt.Printf("timestamp, rss kb, virtual kb, %%cpu, pss kb, uss kb\n")
 		}
-		ch := a.process.WatchStats(a.ctx, a.refreshInterval)
+		// The sampling is restarted when the refresh interval changes
+		var stopWatching context.CancelFunc
+		watch := func(interval time.Duration) <-chan process.ProcessStats {
+			var watchCtx context.Context
+			watchCtx, stopWatching = context.WithCancel(a.ctx)
+			return a.process.WatchStats(watchCtx, interval)
+		}
+		ch := watch(a.refreshInterval)
+		defer func() { stopWatching() }()
 	LOOP:
 		for {
 			select {
+			case interval := <-a.refreshIntervals:
+				// The sampling restarts at the new interval once the previous one
+				// has stopped, the samples that it is still sending are dropped
+				stopWatching()
+				for range ch {
+				}
+				a.refreshInterval = interval
+				a.summary.SetRefreshInterval(interval)
+				ch = watch(interval)
 			case pstats, ok := <-ch:
 				if !ok {
 					break LOOP
 				}
 
 				// TODO make console an extractor instead to skip this goto ~~logic~~ atrocity
-				if !a.showConsole {
+				if !a.showConsole || a.tui != nil {
 					goto skipConsole
 				}
 
 				if !a.noProfilerOutput {
 					if !a.pretty {
 						fmt.Printf(
-							"%s,%d,%d,%.1f\n",
+							"%s,%d,%d,%.1f,%d,%d\n",
 							pstats.Timestamp,
 							pstats.MemoryUsage.Rss,
 							pstats.MemoryUsage.Virtual,
 							pstats.CpuUsage.Percentage,
+							pstats.MemoryUsage.Pss,
+							pstats.MemoryUsage.Uss,
 						)
 					} else {
 						fmt.Printf(
-							"%02d:%02d:%02d\tmemory usage: %d mb\tvirtual: %d mb\tcpu usage: %.1f%%\n",
+							"%02d:%02d:%02d\tmemory usage: %d mb\tpss: %d mb\tuss: %d mb\tvirtual: %d mb\tcpu usage: %.1f%%\n",
 							pstats.Timestamp.Hour(),
 							pstats.Timestamp.Minute(),
 							pstats.Timestamp.Second(),
 							pstats.MemoryUsage.Rss/1024,
+							pstats.MemoryUsage.Pss/1024,
+							pstats.MemoryUsage.Uss/1024,
 							pstats.MemoryUsage.Virtual/1024,
 							pstats.CpuUsage.Percentage,
 						)
+						for _, e := range pstats.Events {
+							fmt.Printf("%02d:%02d:%02d\t%s %s (pid %d, ppid %d)\n",
+								e.Timestamp.Hour(),
+								e.Timestamp.Minute(),
+								e.Timestamp.Second(),
+								e.Type,
+								e.Name,
+								e.Pid,
+								e.PPid,
+							)
+						}
 					}
 				}
 
 			skipConsole:
 
-				err := a.extractor.Add(extractors.ProcessStatsData{
-					MemoryUsage: extractors.MemoryUsageData{
-						Rss:     pstats.MemoryUsage.Rss,
-						RssSwap: pstats.MemoryUsage.RssSwap,
-						Virtual: pstats.MemoryUsage.Virtual,
-					},
-					CpuUsage: extractors.CpuUsageData{
-						Percentage: pstats.CpuUsage.Percentage,
-					},
-					Timestamp: time.Now(),
-				})
+				data := toProcessStatsData(pstats)
+				a.summary.Add(data)
+				a.metrics.Add(data)
+				err := a.extractor.Add(data)
 				if err != nil {
 					fmt.Printf("error while extracting: %s", err)
 				}
-				if pstats.MemoryUsage.Rss > a.peakMem {
-					a.peakMem = pstats.MemoryUsage.Rss
+				if mem := pstats.MemoryUsage.Get(a.peakMetric); mem > a.peakMem {
+					a.peakMem = mem
 				}
-				if a.chartLiveUpdates {
+				a.checkBudget(pstats)
+				if a.tui != nil {
+					a.tui.Add(data)
+				}
+				if a.serves {
+					a.history.Add(data)
 					pstatsJson, err := json.Marshal(pstats)
 					if err != nil {
 						panic(fmt.Errorf("[error] could not marshal pstats: %w", err))
 					}
-					a.eventSourceBroker.Notifier <- pstatsJson
+					a.eventSourceBroker.Publish(pstatsJson)
 				}
+			case name := <-a.markers:
+				a.addMarker(name)
 			case <-a.ctx.Done():
 				break LOOP
 			}
@@ -230,32 +524,213 @@ func (a *App) watchMemoryUsage(wg *sync.WaitGroup) {
 	}()
 }
 
-func (a *App) writeFiles() {
+// AddMarker marks the current point in time of the run, e.g. the start of a phase.
+// Markers without a name are numbered.
+func (a *App) AddMarker(name string) {
+	addMarker(a.markers, name)
+}
+
+func addMarker(markers chan<- string, name string) {
+	select {
+	case markers <- name:
+	default:
+		fmt.Printf("too many markers at once, dropping marker %q\n", name)
+	}
+}
+
+// addMarker passes the marker to the extractors, from the same goroutine that adds the samples
+func (a *App) addMarker(name string) {
+	a.markerCount++
+	if name == "" {
+		name = fmt.Sprintf("marker %d", a.markerCount)
+	}
+	marker := extractors.MarkerData{Name: name, Timestamp: time.Now()}
+	a.extractor.AddMarker(marker)
+	if a.tui != nil {
+		a.tui.AddMarker(marker.Name)
+		return
+	}
+	if a.showConsole && a.pretty && !a.noProfilerOutput {
+		fmt.Printf("%02d:%02d:%02d\tmarker %s\n",
+			marker.Timestamp.Hour(),
+			marker.Timestamp.Minute(),
+			marker.Timestamp.Second(),
+			marker.Name,
+		)
+	}
+}
+
+// startTui takes over the terminal with the dashboard, or keeps the console output if it cannot
+func (a *App) startTui() {
+	if !a.showTui {
+		return
+	}
+	name, _ := a.process.GetName()
+	d, err := tui.Start(tui.Options{
+		Name:            name,
+		Pid:             a.pid,
+		RefreshInterval: a.refreshInterval,
+		OnMarker:        func() { a.AddMarker("") },
+		OnRefreshInterval: func(interval time.Duration) {
+			// Only the latest interval matters, an interval that was not switched to yet is replaced
+			select {
+			case <-a.refreshIntervals:
+			default:
+			}
+			a.refreshIntervals <- interval
+		},
+		// Quitting is the same as Ctrl-C without the terminal ui,
+		// which in raw mode the terminal does not send to the command itself
+		OnQuit: func() {
+			if a.runsExecutable {
+				a.sendSignal(os.Interrupt)
+				return
+			}
+			select {
+			case a.exitSignals <- os.Interrupt:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		fmt.Printf("could not start the terminal ui: %s\n", err)
+		return
+	}
+	a.tui = d
+}
+
+// watchMarkerSignals adds a marker every time one of the marker signals is received
+func (a *App) watchMarkerSignals() {
+	if len(markerSignals) == 0 {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, markerSignals...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-c:
+				a.AddMarker("")
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func toMemoryUsageData(mu process.MemoryUsage) extractors.MemoryUsageData {
+	return extractors.MemoryUsageData{
+		Rss:         mu.Rss,
+		RssSwap:     mu.RssSwap,
+		Virtual:     mu.Virtual,
+		Pss:         mu.Pss,
+		Uss:         mu.Uss,
+		SharedClean: mu.SharedClean,
+		SharedDirty: mu.SharedDirty,
+		SwapPss:     mu.SwapPss,
+	}
+}
+
+func toCpuUsageData(cu process.CpuUsage) extractors.CpuUsageData {
+	return extractors.CpuUsageData{
+		Percentage:         cu.Percentage,
+		UserPercentage:     cu.UserPercentage,
+		SystemPercentage:   cu.SystemPercentage,
+		LifetimePercentage: cu.LifetimePercentage,
+	}
+}
+
+func toProcessStatsData(pstats process.ProcessStats) extractors.ProcessStatsData {
+	data := extractors.ProcessStatsData{
+		MemoryUsage: toMemoryUsageData(pstats.MemoryUsage),
+		CpuUsage:    toCpuUsageData(pstats.CpuUsage),
+		Timestamp:   time.Now(),
+	}
+	for _, p := range pstats.Processes {
+		data.Processes = append(data.Processes, extractors.ProcessData{
+			Pid:         p.Pid,
+			PPid:        p.PPid,
+			Name:        p.Name,
+			Cmdline:     p.Cmdline,
+			Threads:     p.Threads,
+			MemoryUsage: toMemoryUsageData(p.MemoryUsage),
+			CpuUsage:    toCpuUsageData(p.CpuUsage),
+		})
+	}
+	for _, e := range pstats.Events {
+		data.Events = append(data.Events, extractors.ProcessEventData{
+			Type:      string(e.Type),
+			Pid:       e.Pid,
+			PPid:      e.PPid,
+			Name:      e.Name,
+			Cmdline:   e.Cmdline,
+			Timestamp: e.Timestamp,
+		})
+	}
+	return data
+}
+
+func toExitStatusData(s process.ExitStatus) extractors.ExitStatusData {
+	return extractors.ExitStatusData{
+		ExitCode:                   s.ExitCode,
+		Signal:                     s.Signal,
+		MaxRss:                     s.MaxRss,
+		UserTime:                   s.UserTime,
+		SystemTime:                 s.SystemTime,
+		MinorPageFaults:            s.MinorPageFaults,
+		MajorPageFaults:            s.MajorPageFaults,
+		VoluntaryContextSwitches:   s.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: s.InvoluntaryContextSwitches,
+	}
+}
+
+func (a *App) writeFiles() error {
 	err := a.extractor.StopAndExtract()
 	if err != nil {
-		panic(fmt.Errorf("failed writing files: %w", err))
+		return fmt.Errorf("failed writing files: %w", err)
 	}
+	return nil
 }
 
 func (a *App) handleExit(wg *sync.WaitGroup) {
 	wg.Add(1)
 	startTime := time.Now()
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	c := a.exitSignals
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	// A running command is profiled until it exits, signals are forwarded to it
+	done := a.ctx.Done()
+	if a.runsExecutable {
+		done = a.executableDone
+	}
 	go func() {
 		defer wg.Done()
 	LOOP:
 		for {
 			select {
-			case <-c:
+			case sig := <-c:
+				if a.runsExecutable {
+					a.forwardSignal(sig)
+					continue
+				}
 				a.cancel()
 				break LOOP
-			case <-a.ctx.Done():
+			case <-done:
 				break LOOP
 			}
 		}
+		signal.Stop(c)
+		// The samples are summarised and extracted once the last of them has been added
+		a.cancel()
+		<-a.samplingDone
+		// The terminal is restored before anything is printed
+		if a.tui != nil {
+			a.tui.Stop()
+		}
 
-		if a.chartLiveUpdates {
+		if a.serves {
+			// The live updates never end on their own, so they are ended before the server waits for them
+			a.eventSourceBroker.Close()
 			// Shut down server
 			ctx, cancel := context.WithTimeout(a.ctx, 15*time.Second)
 			defer cancel()
@@ -267,13 +742,141 @@ func (a *App) handleExit(wg *sync.WaitGroup) {
 			}
 		}
 
-		a.writeFiles()
+		summary := a.summary.Summary()
+		a.extractor.SetSummary(summary)
+		if a.exitStatus != nil {
+			a.extractor.SetExitStatus(toExitStatusData(*a.exitStatus))
+		}
+		// The summary and the exit code of the command are still reported when a file could not be written
+		if err := a.writeFiles(); err != nil {
+			fmt.Println(err)
+		}
+		if a.noSummary {
+			a.finishBudget()
+			return
+		}
 		a.printPeakMemory()
+		printSummary(summary)
+		a.printExitStatus()
+		a.printBudgetReport()
 		totalTime := time.Since(startTime)
 		fmt.Println(totalTime)
 	}()
 }
 
+// checkBudget reports the limits that the sample exceeds for the first time,
+// and kills the command if the budget says so
+func (a *App) checkBudget(pstats process.ProcessStats) {
+	if a.budgetChecker == nil {
+		return
+	}
+	for _, v := range a.budgetChecker.Check(pstats) {
+		fmt.Printf("budget exceeded: %s\n", v)
+		if a.budgetChecker.Budget().Kill && a.runsExecutable && !a.killedByBudget {
+			a.killedByBudget = true
+			if err := a.executable.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				fmt.Printf("failed killing command: %s\n", err)
+			}
+		}
+	}
+}
+
+// finishBudget checks the limits that are checked at exit
+func (a *App) finishBudget() {
+	if a.budgetChecker == nil {
+		return
+	}
+	a.budgetViolations = a.budgetChecker.Finish(time.Now())
+}
+
+func (a *App) printBudgetReport() {
+	if a.budgetChecker == nil {
+		return
+	}
+	a.finishBudget()
+	if len(a.budgetViolations) == 0 {
+		fmt.Println("budget: ok")
+		return
+	}
+	fmt.Println("budget violations:")
+	for _, v := range a.budgetViolations {
+		fmt.Printf("\t%s\n", v)
+	}
+}
+
+// forwardSignal passes a signal that the profiler received on to the command,
+// unless the terminal already sent it to the command too
+func (a *App) forwardSignal(sig os.Signal) {
+	if signalFromTerminal(sig, a.executable.Process.Pid) {
+		return
+	}
+	a.sendSignal(sig)
+}
+
+func (a *App) sendSignal(sig os.Signal) {
+	err := a.executable.Process.Signal(sig)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		fmt.Printf("failed forwarding %s to command: %s\n", sig, err)
+	}
+}
+
+func printSummary(summary extractors.Summary) {
+	if summary.Samples == 0 {
+		return
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header, rows := summary.Rows()
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t")+"\t")
+	}
+	w.Flush()
+	for _, f := range summary.Fields() {
+		fmt.Printf("%s: %s\n", f[0], f[1])
+	}
+	if summary.Leak != nil {
+		fmt.Printf("leak analysis (after %s warm-up):\n", time.Duration(summary.Leak.WarmupSeconds*float64(time.Second)))
+		for _, f := range summary.Leak.Fields() {
+			fmt.Printf("\t%s: %s\n", f[0], f[1])
+		}
+	}
+}
+
+func (a *App) printExitStatus() {
+	if a.exitStatus == nil {
+		return
+	}
+	printExitStatusFields(toExitStatusData(*a.exitStatus))
+}
+
+func printExitStatusFields(status extractors.ExitStatusData) {
+	for _, f := range status.Fields() {
+		fmt.Printf("%s: %s\n", f[0], f[1])
+	}
+}
+
+// ExitCodeBudgetViolation is the exit code when the budget was exceeded,
+// unless the command failed on its own, in which case its exit code is kept
+const ExitCodeBudgetViolation = 3
+
+// ExitCode is the exit code of the profiled command,
+// or 0 if a running process was profiled
+func (a *App) ExitCode() int {
+	commandFailed := a.exitStatus != nil && a.exitStatus.ExitCode != 0 && !a.killedByBudget
+	if len(a.budgetViolations) > 0 && !commandFailed {
+		return ExitCodeBudgetViolation
+	}
+	if a.exitStatus == nil {
+		return 0
+	}
+	return a.exitStatus.ExitCode
+}
+
 func (a *App) printPeakMemory() {
+	if a.peakMetric != process.MemoryMetricRss {
+		fmt.Printf("\npeak memory (%s): %d mb\n", a.peakMetric, a.peakMem/1024)
+		return
+	}
 	fmt.Printf("\npeak memory: %d mb\n", a.peakMem/1024)
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+type benchmarkOptions struct {
+	// Repeat is how many runs are measured
+	Repeat int
+	// Warmup is how many runs are run before the measured runs, and left out of the statistics
+	Warmup int
+	// HtmlFilename is the html file to which the runs are extracted overlaid
+	HtmlFilename string
+	Command      commandOptions
+	// App are the options of every run, but for the process
+	App AppOptions
+}
+
+// runBenchmark runs the command repeatedly, profiling each run separately,
+// and reports the statistics of the peak rss, cpu seconds and wall time over the runs
+func runBenchmark(o benchmarkOptions) int {
+	if o.Command.Cmd == "" && len(o.Command.Args) == 0 {
+		fmt.Println("-repeat needs a command, given with -cmd or after --")
+		return 1
+	}
+	if o.Warmup < 0 {
+		fmt.Println("-warmup should not be negative")
+		return 1
+	}
+	if o.App.Serve {
+		fmt.Println("-repeat cannot be combined with -serve")
+		return 1
+	}
+	if files := benchmarkFileOutputs(o.App); len(files) > 0 {
+		fmt.Printf("-repeat extracts only -html, every run would overwrite %s\n", strings.Join(files, ", "))
+		return 1
+	}
+
+	bench, code := benchmarkRuns(o)
+	if code != 0 {
+		return code
+	}
+
+	fmt.Println()
+	printBenchmarkTable(bench.Rows())
+	fmt.Println()
+	printBenchmarkTable(bench.AggregateRows())
+
+	if o.HtmlFilename != "" {
+		if err := extractors.WriteBenchmarkChart(o.HtmlFilename, bench, o.App.HtmlAssets); err != nil {
+			fmt.Printf("failed writing files: %s\n", err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// benchmarkRuns runs the warm-up runs and then the measured runs, which it returns.
+// A run that fails ends the benchmark, its exit code is returned.
+func benchmarkRuns(o benchmarkOptions) (extractors.Benchmark, int) {
+	bench := extractors.Benchmark{}
+	for i := 0; i < o.Warmup+o.Repeat; i++ {
+		name := fmt.Sprintf("run %d", i-o.Warmup+1)
+		progress := fmt.Sprintf("%s/%d", name, o.Repeat)
+		if i < o.Warmup {
+			name = fmt.Sprintf("warm-up %d", i+1)
+			progress = fmt.Sprintf("%s/%d", name, o.Warmup)
+		}
+
+		cmd, err := newCommand(o.Command)
+		if err != nil {
+			fmt.Printf("invalid command: %s\n", err)
+			return bench, 1
+		}
+		start := time.Now()
+		if err := cmd.Start(); err != nil {
+			fmt.Printf("failed to start command: %s\n", err)
+			return bench, 1
+		}
+
+		run := extractors.NewBenchmarkRun(name, o.App.TimeSeries)
+		opts := o.App
+		opts.PID = int32(cmd.Process.Pid)
+		opts.RunsExecutable = true
+		opts.Cmd = cmd
+		opts.HtmlFilename = ""
+		opts.ChartLiveUpdates = false
+		opts.ShowConsole = false
+		opts.Tui = false
+		opts.NoSummary = true
+		opts.BenchmarkRun = run
+		a := NewApp(&opts)
+		a.Start()
+		run.Wall = a.exitedAt.Sub(start)
+
+		fmt.Printf("%s: peak rss %.1f mb, cpu %.3fs, wall %.3fs\n", progress, run.PeakRssKb()/1024, run.CpuSeconds(), run.Wall.Seconds())
+
+		// A failed run ends the benchmark, as the statistics of the rest would not be comparable
+		if code := a.ExitCode(); code != 0 {
+			if len(a.budgetViolations) > 0 {
+				fmt.Printf("%s exceeded the budget:\n", name)
+				for _, v := range a.budgetViolations {
+					fmt.Printf("\t%s\n", v)
+				}
+			} else {
+				fmt.Printf("%s failed with exit code %d\n", name, code)
+			}
+			return bench, code
+		}
+
+		if i >= o.Warmup {
+			bench.Runs = append(bench.Runs, run)
+		}
+	}
+
+	return bench, 0
+}
+
+// printBenchmarkTable prints the rows under the header, with the columns aligned to the right
+func printBenchmarkTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t")+"\t")
+	}
+	w.Flush()
+}
+
+// benchmarkFileOutputs returns the flags of the file outputs, which a benchmark does not extract
+func benchmarkFileOutputs(o AppOptions) []string {
+	var flags []string
+	for _, f := range []struct {
+		flag     string
+		filename string
+	}{
+		{"-csv", o.CsvFilename},
+		{"-csv-processes", o.CsvProcessesFilename},
+		{"-summary-json", o.SummaryJsonFilename},
+		{"-json", o.JsonFilename},
+		{"-ndjson", o.NdjsonFilename},
+		{"-prom-file", o.PrometheusFilename},
+		{"-trace", o.TraceFilename},
+		{"-pprof", o.PprofFilename},
+		{"-record", o.SessionFilename},
+	} {
+		if f.filename != "" {
+			flags = append(flags, f.flag)
+		}
+	}
+	return flags
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+func TestBenchmarkRunsLeaveOutWarmup(t *testing.T) {
+	bench, code := benchmarkRuns(benchmarkOptions{
+		Repeat:  2,
+		Warmup:  2,
+		Command: commandOptions{Args: []string{"sleep", "0.05"}},
+		App: AppOptions{
+			RefreshInterval:  10 * time.Millisecond,
+			TimeSeries:       extractors.DefaultTimeSeriesOptions(),
+			NoProfilerOutput: true,
+		},
+	})
+	if code != 0 {
+		t.Fatalf("benchmark exited with %d", code)
+	}
+
+	var names []string
+	for _, r := range bench.Runs {
+		names = append(names, r.Name)
+		if r.ExitStatus == nil || r.ExitStatus.ExitCode != 0 || r.Wall < 50*time.Millisecond {
+			t.Errorf("%s exited with %+v after %s, want 0 after at least 50ms", r.Name, r.ExitStatus, r.Wall)
+		}
+	}
+	if want := []string{"run 1", "run 2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("runs %q, want %q without the warm-up runs", names, want)
+	}
+}
+
+func TestBenchmarkRunsEndOnFailure(t *testing.T) {
+	bench, code := benchmarkRuns(benchmarkOptions{
+		Repeat:  3,
+		Warmup:  1,
+		Command: commandOptions{Args: []string{"sh", "-c", "exit 3"}},
+		App: AppOptions{
+			RefreshInterval:  10 * time.Millisecond,
+			TimeSeries:       extractors.DefaultTimeSeriesOptions(),
+			NoProfilerOutput: true,
+		},
+	})
+	if code != 3 || len(bench.Runs) != 0 {
+		t.Errorf("benchmark exited with %d after %d runs, want 3 after the warm-up run", code, len(bench.Runs))
+	}
+}
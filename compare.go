@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+// ExitCodeRegression is the exit code of compare when a run regressed,
+// the same as when a budget is exceeded, so that CI treats both alike
+const ExitCodeRegression = 3
+
+// runCompare compares runs with the first of them, the baseline
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s compare [flags] <baseline> <run> [<run>...]
+
+		Compares the summary of every run with the baseline, aligned by the time elapsed since the start of each run.
+		A run is a session recorded with -record, or a csv extracted by -csv.
+		Exits with %d if a statistic of -fail-on of any run is worse than the baseline by more than -threshold.
+
+		The statistics are %s.
+
+Flags
+
+`, os.Args[0], ExitCodeRegression, compareStatKeys())
+		fs.PrintDefaults()
+	}
+	htmlPtr := fs.String("html", "", "Extract the runs overlaid on the same charts into an HTML file")
+	htmlAssetsStr := fs.String("html-assets", string(extractors.HtmlAssetsCdn), "Where the chart loads its scripts from, one of cdn, inline, dir")
+	htmlAssetsDir := fs.String("html-assets-dir", "", "A directory with echarts.min.js and themes/westeros.js, for -html-assets inline or dir")
+	chartPoints := fs.Int("chart-points", extractors.DefaultTimeSeriesOptions().MaxPoints, "How many points each run draws at most")
+	threshold := fs.Float64("threshold", 5, "By how many percent a statistic may be worse than the baseline before it is a regression")
+	failOnStr := fs.String("fail-on", "peak-rss,mean-rss,cpu-seconds", "The comma separated statistics that are checked for regressions, none to never fail")
+	refreshInterval := fs.Duration("refresh", 100*time.Millisecond, "The interval of the samples of a csv whose timestamps do not tell it, which is only compared if it is set")
+	fs.Parse(args)
+	refreshIntervalSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "refresh" {
+			refreshIntervalSet = true
+		}
+	})
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	if *chartPoints < 3 {
+		fmt.Println("-chart-points should be at least 3")
+		return 2
+	}
+	var failOn []extractors.CompareStat
+	if *failOnStr != "none" {
+		var err error
+		failOn, err = extractors.ParseCompareStats(*failOnStr)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+
+	timeSeries := extractors.DefaultTimeSeriesOptions()
+	timeSeries.MaxPoints = *chartPoints
+
+	comparison := extractors.Comparison{ThresholdPercent: *threshold, FailOn: failOn}
+	names := runNames(fs.Args())
+	for i, filename := range fs.Args() {
+		session, guessed, err := readRun(filename, names[i], *refreshInterval)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		// A guessed interval changes the cpu seconds, the duration and the memory-time of the run,
+		// which would be compared as regressions or improvements that never happened
+		if guessed && !refreshIntervalSet {
+			fmt.Printf("the timestamps of %s do not tell the interval of the samples, set it with -refresh to compare it\n", filename)
+			return 1
+		}
+
+		var samples []extractors.ProcessStatsData
+		for _, r := range session.Records {
+			if r.Sample != nil {
+				samples = append(samples, *r.Sample)
+			}
+		}
+		if len(samples) == 0 {
+			fmt.Printf("%s has no samples\n", filename)
+			return 1
+		}
+
+		refresh := session.Run.RefreshInterval
+		if refresh <= 0 {
+			refresh = *refreshInterval
+		}
+		comparison.Runs = append(comparison.Runs, extractors.NewCompareRun(names[i], samples, refresh, timeSeries))
+	}
+
+	if *htmlPtr != "" {
+		htmlAssetsMode, err := extractors.ParseHtmlAssetsMode(*htmlAssetsStr)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		htmlAssets, err := extractors.NewHtmlAssets(htmlAssetsMode, *htmlAssetsDir)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		if err := extractors.WriteCompareChart(*htmlPtr, comparison, htmlAssets); err != nil {
+			fmt.Printf("failed writing files: %s\n", err)
+			return 1
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header, rows := comparison.Rows()
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t")+"\t")
+	}
+	w.Flush()
+
+	regressions := comparison.Regressions()
+	if len(regressions) == 0 {
+		return 0
+	}
+	fmt.Printf("\nregressions, worse than %s by more than %.1f%%:\n", comparison.Runs[0].Name, *threshold)
+	for _, r := range regressions {
+		fmt.Printf("\t%s: %s %+.1f%%\n", r.Run, r.Stat.Name, r.DeltaPercent)
+	}
+	return ExitCodeRegression
+}
+
+// readRun reads a session, or a csv extracted by -csv.
+// guessed is whether the interval of the samples of a csv is the refresh interval, see readCsvSession.
+func readRun(filename string, name string, refreshInterval time.Duration) (session *extractors.Session, guessed bool, err error) {
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		return readCsvSession(filename, name, refreshInterval)
+	}
+	session, err = extractors.ReadSession(filename)
+	return session, false, err
+}
+
+// runNames names the runs after their files without the extension,
+// or after their paths if two files have the same name
+func runNames(filenames []string) []string {
+	names := make([]string, len(filenames))
+	seen := map[string]bool{}
+	unique := true
+	for i, f := range filenames {
+		names[i] = strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if seen[names[i]] {
+			unique = false
+		}
+		seen[names[i]] = true
+	}
+	if !unique {
+		copy(names, filenames)
+	}
+	return names
+}
+
+func compareStatKeys() string {
+	keys := make([]string, len(extractors.CompareStats))
+	for i, s := range extractors.CompareStats {
+		keys[i] = s.Key
+	}
+	return strings.Join(keys, ", ")
+}
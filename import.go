@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+// runImport turns a csv extracted by -csv back into a session, for the report subcommand
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s import [flags] <csv>
+
+		Turns a csv extracted by -csv back into a session, to extract other outputs from it with report.
+
+Flags
+
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	outPtr := fs.String("o", "", "The session file to write (default is the csv with the .peekprof extension)")
+	namePtr := fs.String("name", "", "The name of the profiled process (default is the name of the csv)")
+	refreshInterval := fs.Duration("refresh", 100*time.Millisecond, "The interval of the samples, used if the timestamps of the csv do not tell it")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	filename := fs.Arg(0)
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if *outPtr == "" {
+		*outPtr = base + ".peekprof"
+	}
+	if *namePtr == "" {
+		*namePtr = filepath.Base(base)
+	}
+
+	session, guessed, err := readCsvSession(filename, *namePtr, *refreshInterval)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if guessed {
+		fmt.Printf("the timestamps of %s do not tell the interval of the samples, they are spaced by -refresh %s\n", filename, *refreshInterval)
+	}
+
+	if err := extractors.WriteSession(*outPtr, session); err != nil {
+		fmt.Printf("failed writing session: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// readCsvSession reads a csv extracted by -csv as a session of a process with the given name.
+// guessed is whether the interval of the samples is the refresh interval, as the timestamps did not tell it.
+func readCsvSession(filename string, name string, refreshInterval time.Duration) (session *extractors.Session, guessed bool, err error) {
+	samples, exitStatus, err := extractors.ReadCsvMemoryUsage(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(samples) == 0 {
+		return nil, false, fmt.Errorf("%s has no samples", filename)
+	}
+
+	// The timestamps of a csv are in seconds, so samples taken more often than every second share them.
+	// Those are spaced by the interval inferred from the timestamps, or by the refresh interval
+	// if the timestamps are missing or go back, e.g. in a csv that was edited or written by hand.
+	increasing := true
+	for i := 1; i < len(samples); i++ {
+		if !samples[i].Timestamp.After(samples[i-1].Timestamp) {
+			increasing = false
+			break
+		}
+	}
+	interval := refreshInterval
+	guessed = len(samples) > 1
+	if increasing && len(samples) > 1 {
+		guessed = false
+		interval = samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp) / time.Duration(len(samples)-1)
+	}
+	if !increasing {
+		anchor := 0
+		if inferred, from, ok := inferCsvInterval(samples); ok {
+			interval = inferred
+			anchor = from
+			guessed = false
+		}
+		start := samples[anchor].Timestamp
+		if start.IsZero() {
+			start = time.Now()
+		}
+		for i := range samples {
+			samples[i].Timestamp = start.Add(time.Duration(i-anchor) * interval)
+		}
+	}
+
+	session = &extractors.Session{
+		Run: extractors.RunMetadata{
+			Name:            name,
+			Cmdline:         name,
+			StartTime:       samples[0].Timestamp,
+			RefreshInterval: interval,
+		},
+		ExitStatus: exitStatus,
+	}
+	for i := range samples {
+		session.Records = append(session.Records, extractors.SessionRecord{Sample: &samples[i]})
+	}
+
+	return session, guessed, nil
+}
+
+// inferCsvInterval infers the interval of samples whose timestamps are truncated to the second.
+// A sample whose second differs from the previous sample's was taken within an interval of the start
+// of that second, so the time between the first and the last of those samples, over the samples
+// in between, is the interval. from is the first of those samples, which is the closest to its timestamp.
+// The interval is not inferred if any timestamp is missing or goes back, or if they are all the same.
+func inferCsvInterval(samples []extractors.ProcessStatsData) (interval time.Duration, from int, ok bool) {
+	first, last := -1, -1
+	for i := 1; i < len(samples); i++ {
+		prev, t := samples[i-1].Timestamp, samples[i].Timestamp
+		if prev.IsZero() || t.IsZero() || t.Before(prev) {
+			return 0, 0, false
+		}
+		if t.After(prev) {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+
+	switch {
+	case first < 0:
+		return 0, 0, false
+	case first == last:
+		// The samples span two seconds only, the interval is as close as the whole span tells it
+		n := len(samples) - 1
+		return samples[n].Timestamp.Sub(samples[0].Timestamp) / time.Duration(n), 0, true
+	default:
+		return samples[last].Timestamp.Sub(samples[first].Timestamp) / time.Duration(last-first), first, true
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+// testRun is a run sampled every interval, starting part way through a second
+func testRun(n int, interval time.Duration) []extractors.ProcessStatsData {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 350*int(time.Millisecond), time.UTC)
+	samples := make([]extractors.ProcessStatsData, n)
+	for i := range samples {
+		samples[i] = extractors.ProcessStatsData{
+			Timestamp:   start.Add(time.Duration(i) * interval),
+			MemoryUsage: extractors.MemoryUsageData{Rss: 100*1024 + int64(i)*10 + int64(i%7)*100, Virtual: 1 << 20},
+			CpuUsage:    extractors.CpuUsageData{Percentage: float32(50 + i%10)},
+		}
+	}
+	return samples
+}
+
+func writeTestCsv(t *testing.T, samples []extractors.ProcessStatsData) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "run.csv")
+	csv, err := extractors.NewCsvMemoryUsageExtractor(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samples {
+		csv.Add(s)
+	}
+	if err := csv.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestReadCsvSessionInfersInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		samples  int
+		interval time.Duration
+	}{
+		{name: "many samples a second", samples: 600, interval: 100 * time.Millisecond},
+		{name: "a few samples a second", samples: 100, interval: 300 * time.Millisecond},
+		{name: "a sample a second", samples: 30, interval: time.Second},
+		{name: "a sample every few seconds", samples: 30, interval: 5 * time.Second},
+		{name: "within two seconds", samples: 12, interval: 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := testRun(tt.samples, tt.interval)
+			// The refresh interval is far off, so that a guess shows
+			session, guessed, err := readCsvSession(writeTestCsv(t, samples), "run", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if guessed {
+				t.Error("the interval was guessed")
+			}
+
+			// The samples span whole seconds, the interval is off by at most a second over them
+			maxError := time.Second / time.Duration(tt.samples-1)
+			if got := session.Run.RefreshInterval; got < tt.interval-maxError || got > tt.interval+maxError {
+				t.Errorf("RefreshInterval = %s, want %s", got, tt.interval)
+			}
+			if len(session.Records) != tt.samples {
+				t.Fatalf("%d records, want %d", len(session.Records), tt.samples)
+			}
+			for i, r := range session.Records {
+				if d := r.Sample.Timestamp.Sub(samples[i].Timestamp); d < -time.Second || d > time.Second {
+					t.Fatalf("sample %d at %s, want about %s", i, r.Sample.Timestamp, samples[i].Timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCsvSessionGuessesInterval(t *testing.T) {
+	// Every sample is taken within the same second
+	session, guessed, err := readCsvSession(writeTestCsv(t, testRun(10, 50*time.Millisecond)), "run", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !guessed || session.Run.RefreshInterval != time.Second {
+		t.Errorf("RefreshInterval = %s, guessed %v, want the refresh interval guessed", session.Run.RefreshInterval, guessed)
+	}
+}
+
+func TestCsvSessionReportRoundTrip(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	samples := testRun(600, interval)
+	dir := t.TempDir()
+	sessionFile := filepath.Join(dir, "run.peekprof")
+	summaryFile := filepath.Join(dir, "summary.json")
+
+	if code := runImport([]string{"-o", sessionFile, writeTestCsv(t, samples)}); code != 0 {
+		t.Fatalf("import exited with %d", code)
+	}
+	if code := runReport([]string{"-summary-json", summaryFile, sessionFile}); code != 0 {
+		t.Fatalf("report exited with %d", code)
+	}
+
+	b, err := ioutil.ReadFile(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Summary struct {
+			Samples         int     `json:"samples"`
+			DroppedSamples  int     `json:"droppedSamples"`
+			DurationSeconds float64 `json:"durationSeconds"`
+			CpuSeconds      float64 `json:"cpuSeconds"`
+			RssKb           struct {
+				Max  float64 `json:"max"`
+				Mean float64 `json:"mean"`
+			} `json:"rssKb"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	collector := extractors.NewSummaryCollector(interval)
+	for _, s := range samples {
+		collector.Add(s)
+	}
+	want := collector.Summary()
+	if got.Summary.Samples != want.Samples || got.Summary.DroppedSamples != 0 {
+		t.Errorf("report has %d samples and %d dropped, want %d and 0", got.Summary.Samples, got.Summary.DroppedSamples, want.Samples)
+	}
+	if got.Summary.RssKb.Max != want.RssKb.Max || math.Abs(got.Summary.RssKb.Mean-want.RssKb.Mean) > 1e-6 {
+		t.Errorf("report rss max, mean = %v, %v, want %v, %v", got.Summary.RssKb.Max, got.Summary.RssKb.Mean, want.RssKb.Max, want.RssKb.Mean)
+	}
+	for _, stat := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"duration", got.Summary.DurationSeconds, want.Duration.Seconds()},
+		{"cpu seconds", got.Summary.CpuSeconds, want.CpuSeconds},
+	} {
+		if math.Abs(stat.got-stat.want)/stat.want > 0.01 {
+			t.Errorf("report %s = %v, want %v within 1%%", stat.name, stat.got, stat.want)
+		}
+	}
+}
+
+func TestCompareCsvWithItsSession(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	samples := testRun(600, interval)
+	session := &extractors.Session{
+		Run: extractors.RunMetadata{Name: "run", StartTime: samples[0].Timestamp, RefreshInterval: interval},
+	}
+	for i := range samples {
+		session.Records = append(session.Records, extractors.SessionRecord{Sample: &samples[i]})
+	}
+	sessionFile := filepath.Join(t.TempDir(), "run.peekprof")
+	if err := extractors.WriteSession(sessionFile, session); err != nil {
+		t.Fatal(err)
+	}
+	csvFile := writeTestCsv(t, samples)
+
+	// The csv is the same run, so no statistic regresses
+	failOn := strings.ReplaceAll(compareStatKeys(), " ", "")
+	if code := runCompare([]string{"-threshold", "2", "-fail-on", failOn, sessionFile, csvFile}); code != 0 {
+		t.Errorf("compare of a session with its csv exited with %d, want 0", code)
+	}
+	if code := runCompare([]string{"-threshold", "2", "-fail-on", failOn, csvFile, sessionFile}); code != 0 {
+		t.Errorf("compare of a csv with its session exited with %d, want 0", code)
+	}
+}
+
+func TestCompareRefusesGuessedInterval(t *testing.T) {
+	// The timestamps of samples taken within the same second do not tell their interval
+	csvFile := writeTestCsv(t, testRun(10, 50*time.Millisecond))
+	other := writeTestCsv(t, testRun(600, 100*time.Millisecond))
+
+	if code := runCompare([]string{other, csvFile}); code != 1 {
+		t.Errorf("compare with a guessed interval exited with %d, want 1", code)
+	}
+	if code := runCompare([]string{"-refresh", "50ms", "-fail-on", "none", other, csvFile}); code != 0 {
+		t.Errorf("compare with -refresh exited with %d, want 0", code)
+	}
+}
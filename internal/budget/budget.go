@@ -0,0 +1,273 @@
+// Package budget checks the stats of a profiled process against memory, cpu and duration limits.
+package budget
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/process"
+)
+
+// Budget are the limits a profiled process should stay within.
+// A zero limit is not checked.
+type Budget struct {
+	// MaxRss, MaxPss, MaxUss and MaxVirtual are in kilobytes
+	MaxRss     int64
+	MaxPss     int64
+	MaxUss     int64
+	MaxVirtual int64
+	// MaxCpuAvg is the maximum average cpu percentage over the whole run
+	MaxCpuAvg   float64
+	MaxDuration time.Duration
+	// Kill kills the profiled command as soon as a hard limit is exceeded.
+	// Every limit except MaxCpuAvg, which is known only at exit, is a hard limit.
+	Kill bool
+}
+
+func (b Budget) IsEmpty() bool {
+	return b.MaxRss == 0 && b.MaxPss == 0 && b.MaxUss == 0 && b.MaxVirtual == 0 &&
+		b.MaxCpuAvg == 0 && b.MaxDuration == 0
+}
+
+// Set sets a limit by the name of its flag, e.g. max-rss.
+func (b *Budget) Set(name, value string) error {
+	var err error
+	switch name {
+	case "max-rss":
+		b.MaxRss, err = ParseSize(value)
+	case "max-pss":
+		b.MaxPss, err = ParseSize(value)
+	case "max-uss":
+		b.MaxUss, err = ParseSize(value)
+	case "max-virtual":
+		b.MaxVirtual, err = ParseSize(value)
+	case "max-cpu-avg":
+		b.MaxCpuAvg, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	case "max-duration":
+		b.MaxDuration, err = time.ParseDuration(value)
+	case "kill":
+		b.Kill, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown budget limit %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return nil
+}
+
+// LoadFile reads a budget file, where every line is a limit named after its flag
+// and its value, separated by = or whitespace. Lines starting with # are comments.
+//
+//	# budget.txt
+//	max-rss = 512MiB
+//	max-cpu-avg = 80
+//	max-duration = 2m
+//	kill = true
+func LoadFile(filename string) (Budget, error) {
+	b := Budget{}
+	f, err := os.Open(filename)
+	if err != nil {
+		return b, fmt.Errorf("failed to open budget file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var name, value string
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		} else if fields := strings.Fields(line); len(fields) == 2 {
+			name, value = fields[0], fields[1]
+		} else {
+			return b, fmt.Errorf("%s:%d: expected <limit> = <value>", filename, lineNo)
+		}
+		if err := b.Set(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+			return b, fmt.Errorf("%s:%d: %w", filename, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return b, fmt.Errorf("failed to read budget file: %w", err)
+	}
+
+	return b, nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000}, {"tb", 1000 * 1000 * 1000 * 1000},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// ParseSize parses a size like 512MiB, 1.5G or 300MB and returns it in kilobytes.
+// KiB, MiB, GiB and the single letter units K, M, G are powers of 1024,
+// KB, MB and GB are powers of 1000, B is bytes, and a number without a unit is in
+// kilobytes (KiB), the unit of every other memory value of peekprof.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1 << 10)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, u.suffix))
+			unit = u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit) / 1024), nil
+}
+
+// Violation is a limit that was exceeded
+type Violation struct {
+	Metric string
+	// Limit and Observed are formatted with their unit
+	Limit    string
+	Observed string
+	// Timestamp is when the limit was first exceeded
+	Timestamp time.Time
+	// Hard is true if the violation was detected while profiling, not only at exit
+	Hard bool
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: limit %s, observed %s at %s", v.Metric, v.Limit, v.Observed, v.Timestamp.Format("15:04:05"))
+}
+
+// Checker evaluates the stats of a process against a budget
+type Checker struct {
+	budget Budget
+	start  time.Time
+
+	mu         sync.Mutex
+	violations map[string]*Violation
+	order      []string
+	worst      map[string]float64
+	cpuSum     float64
+	samples    int
+}
+
+func NewChecker(b Budget, start time.Time) *Checker {
+	return &Checker{
+		budget:     b,
+		start:      start,
+		violations: map[string]*Violation{},
+		worst:      map[string]float64{},
+	}
+}
+
+func (c *Checker) Budget() Budget {
+	return c.budget
+}
+
+// Check evaluates the hard limits against a sample and returns
+// the limits that were exceeded for the first time.
+func (c *Checker) Check(stats process.ProcessStats) []Violation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cpuSum += float64(stats.CpuUsage.Percentage)
+	c.samples++
+
+	var newViolations []Violation
+	memLimits := []struct {
+		metric string
+		limit  int64
+		value  int64
+	}{
+		{"rss", c.budget.MaxRss, stats.MemoryUsage.Rss},
+		{"pss", c.budget.MaxPss, stats.MemoryUsage.Pss},
+		{"uss", c.budget.MaxUss, stats.MemoryUsage.Uss},
+		{"virtual", c.budget.MaxVirtual, stats.MemoryUsage.Virtual},
+	}
+	for _, l := range memLimits {
+		if l.limit <= 0 || l.value <= l.limit {
+			continue
+		}
+		if v := c.violate(l.metric, float64(l.value), formatKb(l.limit), formatKb(l.value), stats.Timestamp, true); v != nil {
+			newViolations = append(newViolations, *v)
+		}
+	}
+
+	if c.budget.MaxDuration > 0 {
+		elapsed := stats.Timestamp.Sub(c.start)
+		if elapsed > c.budget.MaxDuration {
+			v := c.violate("duration", float64(elapsed), c.budget.MaxDuration.String(), elapsed.Round(time.Millisecond).String(), stats.Timestamp, true)
+			if v != nil {
+				newViolations = append(newViolations, *v)
+			}
+		}
+	}
+
+	return newViolations
+}
+
+// Finish evaluates the limits that are known only at the end of the run
+// and returns every violation of the run.
+func (c *Checker) Finish(at time.Time) []Violation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.budget.MaxDuration > 0 {
+		if elapsed := at.Sub(c.start); elapsed > c.budget.MaxDuration {
+			c.violate("duration", float64(elapsed), c.budget.MaxDuration.String(), elapsed.Round(time.Millisecond).String(), at, false)
+		}
+	}
+	if c.budget.MaxCpuAvg > 0 && c.samples > 0 {
+		avg := c.cpuSum / float64(c.samples)
+		if avg > c.budget.MaxCpuAvg {
+			c.violate("cpu-avg", avg, fmt.Sprintf("%.1f%%", c.budget.MaxCpuAvg), fmt.Sprintf("%.1f%%", avg), at, false)
+		}
+	}
+
+	violations := make([]Violation, 0, len(c.order))
+	for _, metric := range c.order {
+		violations = append(violations, *c.violations[metric])
+	}
+	return violations
+}
+
+// violate records a violation of a metric. The first time a metric is violated
+// its violation is returned, afterwards only its worst observed value is updated.
+func (c *Checker) violate(metric string, value float64, limit, observed string, at time.Time, hard bool) *Violation {
+	if v, ok := c.violations[metric]; ok {
+		if value > c.worst[metric] {
+			c.worst[metric] = value
+			v.Observed = observed
+		}
+		return nil
+	}
+	v := &Violation{Metric: metric, Limit: limit, Observed: observed, Timestamp: at, Hard: hard}
+	c.violations[metric] = v
+	c.worst[metric] = value
+	c.order = append(c.order, metric)
+	return v
+}
+
+func formatKb(kb int64) string {
+	switch {
+	case kb >= 1<<20:
+		return fmt.Sprintf("%.1f GiB", float64(kb)/(1<<20))
+	case kb >= 1<<10:
+		return fmt.Sprintf("%.1f MiB", float64(kb)/(1<<10))
+	default:
+		return fmt.Sprintf("%d KiB", kb)
+	}
+}
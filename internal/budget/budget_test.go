@@ -0,0 +1,74 @@
+package budget
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "512", want: 512},
+		{s: "0", want: 0},
+		{s: "512MiB", want: 512 * 1024},
+		{s: "512mib", want: 512 * 1024},
+		{s: "1.5G", want: 1536 * 1024},
+		{s: "2GiB", want: 2 * 1024 * 1024},
+		{s: "1TiB", want: 1024 * 1024 * 1024},
+		{s: "64K", want: 64},
+		{s: "64KiB", want: 64},
+		{s: "300MB", want: 300 * 1000 * 1000 / 1024},
+		{s: "1GB", want: 1000 * 1000 * 1000 / 1024},
+		{s: "2048B", want: 2},
+		{s: " 10 MiB ", want: 10 * 1024},
+		{s: "", wantErr: true},
+		{s: "MiB", wantErr: true},
+		{s: "-1MiB", wantErr: true},
+		{s: "ten", wantErr: true},
+		{s: "10XB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseSize(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSize(%q) = %d, want an error", tt.s, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSize(%q) error = %v", tt.s, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBudgetSet(t *testing.T) {
+	var b Budget
+	for name, value := range map[string]string{
+		"max-rss":      "512",
+		"max-virtual":  "4GiB",
+		"max-cpu-avg":  "80%",
+		"max-duration": "2m",
+		"kill":         "true",
+	} {
+		if err := b.Set(name, value); err != nil {
+			t.Fatalf("Set(%q, %q) error = %v", name, value, err)
+		}
+	}
+	if b.MaxRss != 512 || b.MaxVirtual != 4*1024*1024 || b.MaxCpuAvg != 80 || b.MaxDuration.Minutes() != 2 || !b.Kill {
+		t.Errorf("Set() = %+v", b)
+	}
+	if err := b.Set("max-rss", "lots"); err == nil {
+		t.Error("Set(max-rss, lots) want an error")
+	}
+	if err := b.Set("max-threads", "4"); err == nil {
+		t.Error("Set(max-threads, 4) want an error")
+	}
+}
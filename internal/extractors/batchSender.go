@@ -0,0 +1,225 @@
+package extractors
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// batchSenderOptions configure how a batchSender batches and retries
+type batchSenderOptions struct {
+	// Name identifies the sender in the messages it prints
+	Name string
+	// BufferSize is how many items are kept while the endpoint is slow or down,
+	// the oldest items are dropped beyond it
+	BufferSize int
+	// BatchSize is the maximum number of items sent at once
+	BatchSize int
+	// FlushInterval is how long items wait for a batch to fill up
+	FlushInterval time.Duration
+	// MaxRetries is how many times a batch is retried before it is dropped
+	MaxRetries int
+	// MinBackoff is the wait before the first retry, doubled on every retry up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// CloseTimeout is how long Close waits for the buffered items to be sent
+	CloseTimeout time.Duration
+}
+
+func defaultBatchSenderOptions(name string) batchSenderOptions {
+	return batchSenderOptions{
+		Name:          name,
+		BufferSize:    10000,
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		MaxRetries:    5,
+		MinBackoff:    200 * time.Millisecond,
+		MaxBackoff:    5 * time.Second,
+		CloseTimeout:  5 * time.Second,
+	}
+}
+
+// batchSender sends items to an endpoint in batches from a background goroutine,
+// so that a slow or down endpoint never blocks sampling.
+// Items are queued in a bounded buffer that drops the oldest items when it is full.
+type batchSender struct {
+	opts batchSenderOptions
+	send func(batch []interface{}) error
+	// close releases what send uses, e.g. its connection. It is called by the sending goroutine
+	// once it is done, which may be after Close gave up waiting for it.
+	close func() error
+	queue chan interface{}
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu      sync.Mutex
+	sent    int
+	dropped int
+	// sending is the size of the batch that is being sent
+	sending  int
+	lastErr  error
+	closeErr error
+}
+
+// newBatchSender starts sending the items that are queued with send, and calls close,
+// if it is set, when there is nothing left to send
+func newBatchSender(opts batchSenderOptions, send func(batch []interface{}) error, close func() error) *batchSender {
+	s := &batchSender{
+		opts:  opts,
+		send:  send,
+		close: close,
+		queue: make(chan interface{}, opts.BufferSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Enqueue queues an item without ever blocking
+func (s *batchSender) Enqueue(item interface{}) {
+	for {
+		select {
+		case s.queue <- item:
+			return
+		default:
+		}
+		// The buffer is full, make room by dropping the oldest item
+		select {
+		case <-s.queue:
+			s.addDropped(1)
+		default:
+		}
+	}
+}
+
+func (s *batchSender) run() {
+	defer close(s.done)
+	defer func() {
+		if s.close == nil {
+			return
+		}
+		err := s.close()
+		s.mu.Lock()
+		s.closeErr = err
+		s.mu.Unlock()
+	}()
+	tick := time.NewTicker(s.opts.FlushInterval)
+	defer tick.Stop()
+
+	var batch []interface{}
+	for {
+		select {
+		case item := <-s.queue:
+			batch = append(batch, item)
+			if len(batch) >= s.opts.BatchSize {
+				s.sendWithRetries(batch)
+				batch = nil
+			}
+		case <-tick.C:
+			if len(batch) > 0 {
+				s.sendWithRetries(batch)
+				batch = nil
+			}
+		case <-s.stop:
+			// Send whatever is left in the buffer
+			for {
+				select {
+				case item := <-s.queue:
+					batch = append(batch, item)
+					if len(batch) >= s.opts.BatchSize {
+						s.sendWithRetries(batch)
+						batch = nil
+					}
+				default:
+					if len(batch) > 0 {
+						s.sendWithRetries(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *batchSender) sendWithRetries(batch []interface{}) {
+	s.mu.Lock()
+	s.sending = len(batch)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.sending = 0
+		s.mu.Unlock()
+	}()
+
+	backoff := s.opts.MinBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = s.send(batch); err == nil {
+			s.mu.Lock()
+			s.sent += len(batch)
+			s.mu.Unlock()
+			return
+		}
+		var permanent permanentError
+		if attempt >= s.opts.MaxRetries || errors.As(err, &permanent) {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+
+	s.mu.Lock()
+	if s.lastErr == nil {
+		fmt.Printf("%s: failed sending, dropping %d items: %s\n", s.opts.Name, len(batch), err)
+	}
+	s.lastErr = err
+	s.mu.Unlock()
+	s.addDropped(len(batch))
+}
+
+// permanentError is an error that sending again would not fix,
+// like a request that the endpoint rejects as malformed
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func (s *batchSender) addDropped(n int) {
+	s.mu.Lock()
+	s.dropped += n
+	s.mu.Unlock()
+}
+
+// Close sends the buffered items and stops the sender.
+// It gives up on the items that could not be sent within the close timeout,
+// in which case the sender keeps trying them in the background until its retries run out.
+func (s *batchSender) Close() (sent, dropped int, err error) {
+	close(s.stop)
+	select {
+	case <-s.done:
+	case <-time.After(s.opts.CloseTimeout):
+		err = fmt.Errorf("timed out sending the buffered items")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && s.closeErr != nil {
+		err = fmt.Errorf("failed to close: %w", s.closeErr)
+	}
+	if err == nil {
+		err = s.lastErr
+	}
+	return s.sent, s.dropped + s.sending + len(s.queue), err
+}
@@ -0,0 +1,162 @@
+package extractors
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testBatchSenderOptions() batchSenderOptions {
+	return batchSenderOptions{
+		Name:          "test",
+		BufferSize:    10,
+		BatchSize:     4,
+		FlushInterval: time.Hour,
+		MaxRetries:    2,
+		MinBackoff:    time.Millisecond,
+		MaxBackoff:    time.Millisecond,
+		CloseTimeout:  time.Second,
+	}
+}
+
+func TestBatchSenderSendsInBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]interface{}
+	closed := false
+	s := newBatchSender(testBatchSenderOptions(), func(batch []interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			t.Error("send after close")
+		}
+		batches = append(batches, batch)
+		return nil
+	}, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		return nil
+	})
+
+	for i := 0; i < 10; i++ {
+		s.Enqueue(i)
+	}
+	sent, dropped, err := s.Close()
+	if err != nil || sent != 10 || dropped != 0 {
+		t.Fatalf("Close() = %d, %d, %v, want 10, 0, nil", sent, dropped, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !closed {
+		t.Error("close was not called")
+	}
+	next := 0
+	for _, b := range batches {
+		if len(b) > 4 {
+			t.Errorf("batch of %d items, want at most 4", len(b))
+		}
+		for _, item := range b {
+			if item.(int) != next {
+				t.Fatalf("sent %v, want item %d next", batches, next)
+			}
+			next++
+		}
+	}
+}
+
+func TestBatchSenderRetries(t *testing.T) {
+	attempts := 0
+	s := newBatchSender(testBatchSenderOptions(), func(batch []interface{}) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, nil)
+	s.Enqueue(1)
+	sent, dropped, err := s.Close()
+	if err != nil || sent != 1 || dropped != 0 || attempts != 3 {
+		t.Fatalf("Close() = %d, %d, %v after %d attempts, want 1, 0, nil after 3", sent, dropped, err, attempts)
+	}
+}
+
+func TestBatchSenderDropsFailedBatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{name: "retries run out", err: errors.New("unavailable"), wantAttempts: 3},
+		{name: "permanent", err: permanentError{errors.New("bad request")}, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			s := newBatchSender(testBatchSenderOptions(), func(batch []interface{}) error {
+				attempts++
+				return tt.err
+			}, nil)
+			s.Enqueue(1)
+			s.Enqueue(2)
+			sent, dropped, err := s.Close()
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Close() error = %v, want %v", err, tt.err)
+			}
+			if sent != 0 || dropped != 2 || attempts != tt.wantAttempts {
+				t.Errorf("Close() = %d, %d after %d attempts, want 0, 2 after %d", sent, dropped, attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestBatchSenderEnqueueNeverBlocks(t *testing.T) {
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	opts := testBatchSenderOptions()
+	opts.BatchSize = 1
+	opts.CloseTimeout = 50 * time.Millisecond
+	s := newBatchSender(opts, func(batch []interface{}) error {
+		<-release
+		return nil
+	}, func() error {
+		close(closed)
+		return nil
+	})
+
+	// The sender is stuck on the first item, the buffer holds the latest 10 of the rest
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.Enqueue(i)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Enqueue blocked")
+	}
+
+	sent, dropped, err := s.Close()
+	if err == nil {
+		t.Error("Close() did not time out")
+	}
+	if sent != 0 || dropped != 1000 {
+		t.Errorf("Close() = %d sent, %d dropped, want 0, 1000", sent, dropped)
+	}
+
+	// What send uses is closed only once the sender is done with it
+	select {
+	case <-closed:
+		t.Fatal("closed while sending")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not closed after sending")
+	}
+}
@@ -0,0 +1,210 @@
+package extractors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/components"
+)
+
+type BenchmarkRunExtractorOptions struct {
+	Run *BenchmarkRun
+}
+
+// NewBenchmarkRunExtractorOptions collects a run of a benchmark into run
+func NewBenchmarkRunExtractorOptions(run *BenchmarkRun) BenchmarkRunExtractorOptions {
+	return BenchmarkRunExtractorOptions{Run: run}
+}
+
+// BenchmarkRun is a single execution of the command of a benchmark.
+// It is the Extractor of its run, and keeps the samples to draw them next to the other runs.
+type BenchmarkRun struct {
+	Name   string
+	Start  time.Time
+	Series *TimeSeries
+	// Summary are the statistics of the run
+	Summary Summary
+	// ExitStatus is how the command exited
+	ExitStatus *ExitStatusData
+	// Wall is the time from the start of the command until it exited
+	Wall time.Duration
+}
+
+func NewBenchmarkRun(name string, opts TimeSeriesOptions) *BenchmarkRun {
+	return &BenchmarkRun{Name: name, Series: NewTimeSeries(opts)}
+}
+
+func (r *BenchmarkRun) Add(data ProcessStatsData) error {
+	if r.Start.IsZero() {
+		r.Start = data.Timestamp
+	}
+	r.Series.Add(data)
+	return nil
+}
+
+func (r *BenchmarkRun) SetSummary(summary Summary) {
+	r.Summary = summary
+}
+
+func (r *BenchmarkRun) SetExitStatus(status ExitStatusData) {
+	r.ExitStatus = &status
+}
+
+// StopAndExtract does nothing, the runs are extracted together by the benchmark
+func (r *BenchmarkRun) StopAndExtract() error {
+	return nil
+}
+
+// PeakRssKb is the sampled peak rss. The max rss that the kernel reports for the command
+// is not used, as it counts the memory of the profiler that forked the command.
+func (r *BenchmarkRun) PeakRssKb() float64 {
+	return r.Summary.RssKb.Max
+}
+
+// CpuSeconds is the user and system time that the kernel reports for the command,
+// or the cpu time of the samples if it does not
+func (r *BenchmarkRun) CpuSeconds() float64 {
+	if r.ExitStatus != nil && r.ExitStatus.UserTime+r.ExitStatus.SystemTime > 0 {
+		return (r.ExitStatus.UserTime + r.ExitStatus.SystemTime).Seconds()
+	}
+	return r.Summary.CpuSeconds
+}
+
+func (r *BenchmarkRun) compareRun() CompareRun {
+	return CompareRun{Name: r.Name, Start: r.Start, Summary: r.Summary, Series: r.Series}
+}
+
+// BenchmarkStat describes the values a statistic took over the runs of a benchmark
+type BenchmarkStat struct {
+	Mean float64
+	// Stddev is the sample standard deviation
+	Stddev float64
+	// CiLow and CiHigh are the 95% confidence interval of the mean
+	CiLow  float64
+	CiHigh float64
+	Min    float64
+	Max    float64
+}
+
+func NewBenchmarkStat(values []float64) BenchmarkStat {
+	if len(values) == 0 {
+		return BenchmarkStat{}
+	}
+	s := BenchmarkStat{Min: values[0], Max: values[0]}
+	for _, v := range values {
+		s.Mean += v
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	n := float64(len(values))
+	s.Mean /= n
+
+	if len(values) > 1 {
+		for _, v := range values {
+			s.Stddev += (v - s.Mean) * (v - s.Mean)
+		}
+		s.Stddev = math.Sqrt(s.Stddev / (n - 1))
+	}
+	margin := tCritical95(len(values)-1) * s.Stddev / math.Sqrt(n)
+	s.CiLow = s.Mean - margin
+	s.CiHigh = s.Mean + margin
+	return s
+}
+
+// tCritical95 is the two-sided 95% critical value of Student's t distribution,
+// with the normal distribution's for more than 30 degrees of freedom
+func tCritical95(df int) float64 {
+	table := []float64{
+		12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+	}
+	if df < 1 {
+		return 0
+	}
+	if df > len(table) {
+		return 1.960
+	}
+	return table[df-1]
+}
+
+// Benchmark are the measured runs of a command, without the warm-up runs
+type Benchmark struct {
+	Runs []*BenchmarkRun
+}
+
+// Rows returns the peak rss, cpu seconds and wall time of every run as table rows
+func (b Benchmark) Rows() (header []string, rows [][]string) {
+	header = []string{"", "peak rss mb", "cpu seconds", "wall seconds", "exit code"}
+	for _, r := range b.Runs {
+		exitCode := "-"
+		if r.ExitStatus != nil {
+			exitCode = fmt.Sprintf("%d", r.ExitStatus.ExitCode)
+		}
+		rows = append(rows, []string{
+			r.Name,
+			fmt.Sprintf("%.1f", r.PeakRssKb()/1024),
+			fmt.Sprintf("%.3f", r.CpuSeconds()),
+			fmt.Sprintf("%.3f", r.Wall.Seconds()),
+			exitCode,
+		})
+	}
+	return header, rows
+}
+
+// AggregateRows returns the statistics of the peak rss, cpu seconds and wall time over the runs as table rows
+func (b Benchmark) AggregateRows() (header []string, rows [][]string) {
+	header = []string{"", "mean", "stddev", "95% ci", "min", "max"}
+	row := func(name string, value func(*BenchmarkRun) float64, format string) []string {
+		values := make([]float64, len(b.Runs))
+		for i, r := range b.Runs {
+			values[i] = value(r)
+		}
+		s := NewBenchmarkStat(values)
+		f := func(v float64) string { return fmt.Sprintf(format, v) }
+		return []string{name, f(s.Mean), f(s.Stddev), f(s.CiLow) + " - " + f(s.CiHigh), f(s.Min), f(s.Max)}
+	}
+	rows = [][]string{
+		row("peak rss mb", func(r *BenchmarkRun) float64 { return r.PeakRssKb() / 1024 }, "%.1f"),
+		row("cpu seconds", (*BenchmarkRun).CpuSeconds, "%.3f"),
+		row("wall seconds", func(r *BenchmarkRun) float64 { return r.Wall.Seconds() }, "%.3f"),
+	}
+	return header, rows
+}
+
+// WriteBenchmarkChart writes the memory and cpu of the runs overlaid in an html file,
+// aligned by the time elapsed since the start of each run, followed by the statistics of the runs
+func WriteBenchmarkChart(filename string, b Benchmark, assets HtmlAssets) error {
+	fs, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer fs.Close()
+
+	runs := make([]CompareRun, len(b.Runs))
+	for i, r := range b.Runs {
+		runs[i] = r.compareRun()
+	}
+
+	page := components.NewPage()
+	page.AssetsHost = assets.host(filename)
+	page.AddCharts(
+		generateOverlayChart(runs, "Memory usage (mb)", "The rss of each run", TimeSeriesRss, 1.0/1024),
+		generateOverlayChart(runs, "CPU usage", "The cpu usage of each run", TimeSeriesCpu, 1),
+	)
+
+	header, rows := b.AggregateRows()
+	sections := htmlTableSection(fmt.Sprintf("Benchmark of %d runs", len(b.Runs)), header, rows)
+	header, rows = b.Rows()
+	sections += htmlTableSection("Runs", header, rows)
+
+	if err := renderPage(fs, page, sections, assets, map[string][]byte{}); err != nil {
+		return fmt.Errorf("failed to write page: %w", err)
+	}
+
+	fmt.Printf("html benchmark has been written at %s\n", filename)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package extractors
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewBenchmarkStat(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   BenchmarkStat
+	}{
+		{name: "no runs", values: nil, want: BenchmarkStat{}},
+		// A single run has no spread, so its interval is the value itself
+		{name: "a single run", values: []float64{3}, want: BenchmarkStat{Mean: 3, CiLow: 3, CiHigh: 3, Min: 3, Max: 3}},
+		{name: "identical runs", values: []float64{2, 2, 2}, want: BenchmarkStat{Mean: 2, CiLow: 2, CiHigh: 2, Min: 2, Max: 2}},
+		// The sample stddev is sqrt(2), and the margin is t(1) * sqrt(2) / sqrt(2)
+		{name: "two runs", values: []float64{1, 3}, want: BenchmarkStat{Mean: 2, Stddev: math.Sqrt2, CiLow: 2 - 12.706, CiHigh: 2 + 12.706, Min: 1, Max: 3}},
+		// The sample stddev is sqrt(32/7) = 2.138, and the margin is t(7) * 2.138 / sqrt(8)
+		{
+			name:   "eight runs",
+			values: []float64{2, 4, 4, 4, 5, 5, 7, 9},
+			want: BenchmarkStat{
+				Mean: 5, Stddev: math.Sqrt(32.0 / 7),
+				CiLow: 5 - 2.365*math.Sqrt(32.0/7)/math.Sqrt(8), CiHigh: 5 + 2.365*math.Sqrt(32.0/7)/math.Sqrt(8),
+				Min: 2, Max: 9,
+			},
+		},
+		// Beyond 30 degrees of freedom the normal distribution is used
+		{
+			name:   "many runs",
+			values: alternating(40, 1, 3),
+			want: BenchmarkStat{
+				Mean: 2, Stddev: math.Sqrt(40.0 / 39),
+				CiLow: 2 - 1.960*math.Sqrt(40.0/39)/math.Sqrt(40), CiHigh: 2 + 1.960*math.Sqrt(40.0/39)/math.Sqrt(40),
+				Min: 1, Max: 3,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewBenchmarkStat(tt.values)
+			x := []float64{got.Mean, got.Stddev, got.CiLow, got.CiHigh, got.Min, got.Max}
+			y := []float64{tt.want.Mean, tt.want.Stddev, tt.want.CiLow, tt.want.CiHigh, tt.want.Min, tt.want.Max}
+			for i := range x {
+				if math.Abs(x[i]-y[i]) > 1e-9 {
+					t.Fatalf("NewBenchmarkStat() = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTCritical95(t *testing.T) {
+	for _, tt := range []struct {
+		df   int
+		want float64
+	}{
+		{0, 0},
+		{1, 12.706},
+		{10, 2.228},
+		{30, 2.042},
+		{31, 1.960},
+	} {
+		if got := tCritical95(tt.df); got != tt.want {
+			t.Errorf("tCritical95(%d) = %v, want %v", tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestBenchmarkRows(t *testing.T) {
+	run := func(name string, rssKb float64, status *ExitStatusData, wall time.Duration) *BenchmarkRun {
+		r := NewBenchmarkRun(name, DefaultTimeSeriesOptions())
+		r.Summary.RssKb.Max = rssKb
+		r.Summary.CpuSeconds = 0.5
+		r.ExitStatus = status
+		r.Wall = wall
+		return r
+	}
+	b := Benchmark{Runs: []*BenchmarkRun{
+		// The cpu time that the kernel reports is preferred to the sampled one
+		run("run 1", 1024, &ExitStatusData{UserTime: 750 * time.Millisecond, SystemTime: 250 * time.Millisecond}, time.Second),
+		run("run 2", 3072, nil, 3*time.Second),
+	}}
+
+	_, rows := b.Rows()
+	want := [][]string{
+		{"run 1", "1.0", "1.000", "1.000", "0"},
+		{"run 2", "3.0", "0.500", "3.000", "-"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Rows() = %q, want %q", rows, want)
+	}
+
+	_, rows = b.AggregateRows()
+	want = [][]string{
+		{"peak rss mb", "2.0", "1.4", "-10.7 - 14.7", "1.0", "3.0"},
+		{"cpu seconds", "0.750", "0.354", "-2.426 - 3.926", "0.500", "1.000"},
+		{"wall seconds", "2.000", "1.414", "-10.706 - 14.706", "1.000", "3.000"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("AggregateRows() = %q, want %q", rows, want)
+	}
+}
+
+// alternating returns n values, alternating between a and b
+func alternating(n int, a, b float64) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = a
+		if i%2 == 1 {
+			values[i] = b
+		}
+	}
+	return values
+}
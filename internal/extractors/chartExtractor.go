@@ -1,13 +1,14 @@
 package extractors
 
 import (
+	"bytes"
 	"fmt"
-	"log"
+	"html"
+	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"runtime"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/components"
@@ -16,20 +17,30 @@ import (
 )
 
 type ChartExtractorOptions struct {
-	ProcessName            string
-	Filename               string
-	UpdateLiveListenWSHost string
+	ProcessName string
+	Filename    string
+	// Assets are where the scripts of the page come from
+	Assets HtmlAssets
+	// TimeSeries is the retention of the samples that are drawn
+	TimeSeries TimeSeriesOptions
 }
 
 func NewChartExtractorOptions(processname string, filename string) ChartExtractorOptions {
 	return ChartExtractorOptions{
 		ProcessName: processname,
 		Filename:    filename,
+		Assets:      HtmlAssets{Mode: HtmlAssetsCdn},
+		TimeSeries:  DefaultTimeSeriesOptions(),
 	}
 }
 
-func (o *ChartExtractorOptions) UpdateLive(host string) *ChartExtractorOptions {
-	o.UpdateLiveListenWSHost = host
+func (o *ChartExtractorOptions) WithAssets(assets HtmlAssets) *ChartExtractorOptions {
+	o.Assets = assets
+	return o
+}
+
+func (o *ChartExtractorOptions) WithTimeSeries(opts TimeSeriesOptions) *ChartExtractorOptions {
+	o.TimeSeries = opts
 	return o
 }
 
@@ -38,32 +49,18 @@ type ChartExtractor struct {
 	ProcessName string
 	// Filename is the file to which it extracts the chart
 	Filename string
-	// Data is the memory usage data
-	Data []ProcessStatsData
-	// From is when the chart was created
-	From time.Time
-	// To is when the chart stopped watching for more data
-	To                     time.Time
-	UpdateLiveListenWSHost string
-}
-
-func openBrowser(url string) {
-	var err error
-
-	switch runtime.GOOS {
-	case "linux":
-		err = exec.Command("xdg-open", url).Start()
-	case "windows":
-		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
-	case "darwin":
-		err = exec.Command("open", url).Start()
-	default:
-		err = fmt.Errorf("unsupported platform")
-	}
-	if err != nil {
-		log.Fatal(err)
-	}
-
+	// Series are the samples of the run, downsampled the longer the run is
+	Series *TimeSeries
+	// ExitStatus is how the profiled command exited, if a command was profiled
+	ExitStatus *ExitStatusData
+	// Summary are the statistics of the whole run
+	Summary *Summary
+	// Assets are where the scripts of the page come from
+	Assets HtmlAssets
+	// inlinedAssets are the scripts already read for inlining, by url
+	inlinedAssets map[string][]byte
+	// served is whether the page is served by the http server rather than written to a file
+	served bool
 }
 
 func NewChartExtractor(opts ChartExtractorOptions) *ChartExtractor {
@@ -73,31 +70,18 @@ func NewChartExtractor(opts ChartExtractorOptions) *ChartExtractor {
 	}
 	defer fs.Close()
 	chartExtractor := &ChartExtractor{
-		ProcessName:            opts.ProcessName,
-		Filename:               opts.Filename,
-		UpdateLiveListenWSHost: opts.UpdateLiveListenWSHost,
-	}
-
-	// Generate html page for live updates
-	if opts.UpdateLiveListenWSHost != "" {
-		page := chartExtractor.generateChartsPage(true)
-		page.Render(fs)
-		fpath, err := filepath.Abs(opts.Filename)
-		if err != nil {
-			panic(fmt.Errorf("could not open file in browser: %w", err))
-		}
-		fmt.Printf("\nLive monitoring is hosted on %s\n\n", fpath)
-		openBrowser(fpath)
+		ProcessName:   opts.ProcessName,
+		Filename:      opts.Filename,
+		Series:        NewTimeSeries(opts.TimeSeries),
+		Assets:        opts.Assets,
+		inlinedAssets: map[string][]byte{},
 	}
 
 	return chartExtractor
 }
 
 func (m *ChartExtractor) Add(data ProcessStatsData) error {
-	if len(m.Data) == 0 {
-		m.From = time.Now()
-	}
-	m.Data = append(m.Data, data)
+	m.Series.Add(data)
 
 	return nil
 }
@@ -110,10 +94,8 @@ func (m *ChartExtractor) StopAndExtract() error {
 	defer fs.Close()
 	defer m.reset()
 
-	m.To = time.Now()
-
 	page := m.generateChartsPage(false)
-	err = page.Render(fs)
+	err = m.renderPage(fs, page)
 	if err != nil {
 		return fmt.Errorf("failed to write page: %w", err)
 	}
@@ -123,16 +105,124 @@ func (m *ChartExtractor) StopAndExtract() error {
 	return nil
 }
 
+func (m *ChartExtractor) SetExitStatus(status ExitStatusData) {
+	m.ExitStatus = &status
+}
+
+// renderPage renders the charts page followed by the html sections
+// that are not charts, like the exit status of the command
+func (m *ChartExtractor) renderPage(w io.Writer, page *components.Page) error {
+	return renderPage(w, page, m.htmlSections(), m.Assets, m.inlinedAssets)
+}
+
+// renderPage renders a charts page with html sections before the end of its body,
+// inlining its scripts if the assets are inlined
+func renderPage(w io.Writer, page *components.Page, sections string, assets HtmlAssets, inlinedAssets map[string][]byte) error {
+	buf := &bytes.Buffer{}
+	if err := page.Render(buf); err != nil {
+		return err
+	}
+
+	out := buf.Bytes()
+	if i := bytes.LastIndex(out, []byte("</body>")); i >= 0 && sections != "" {
+		out = append(out[:i:i], append([]byte(sections), out[i:]...)...)
+	}
+
+	if assets.Mode == HtmlAssetsInline {
+		var err error
+		if out, err = assets.inline(out, inlinedAssets); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(out)
+	return err
+}
+
+func (m *ChartExtractor) SetSummary(summary Summary) {
+	m.Summary = &summary
+}
+
+func (m *ChartExtractor) htmlSections() string {
+	var sections string
+	if m.Summary != nil {
+		header, rows := m.Summary.Rows()
+		sections += htmlTableSection("Summary", header, rows)
+		sections += htmlFieldsSection("", m.Summary.Fields())
+		if m.Summary.Leak != nil {
+			sections += htmlFieldsSection("Leak analysis", m.Summary.Leak.Fields())
+		}
+	}
+	if m.ExitStatus != nil {
+		sections += htmlFieldsSection("Command", m.ExitStatus.Fields())
+	}
+	return sections
+}
+
+// htmlFieldsSection returns an html table with a name-value pair per row
+func htmlFieldsSection(title string, fields [][2]string) string {
+	var b strings.Builder
+	b.WriteString(`<div class="container"><div class="item" style="width:900px;padding:20px 0">`)
+	if title != "" {
+		fmt.Fprintf(&b, `<h3 style="font-family:sans-serif">%s</h3>`, html.EscapeString(title))
+	}
+	b.WriteString(`<table style="font-family:sans-serif;border-collapse:collapse">`)
+	for _, f := range fields {
+		fmt.Fprintf(&b,
+			`<tr><td style="padding:2px 16px 2px 0;color:#666">%s</td><td style="padding:2px 0">%s</td></tr>`,
+			html.EscapeString(f[0]), html.EscapeString(f[1]),
+		)
+	}
+	b.WriteString(`</table></div></div>`)
+	return b.String()
+}
+
+// htmlTableSection returns an html table with a header row
+func htmlTableSection(title string, header []string, rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(`<div class="container"><div class="item" style="width:900px;padding:20px 0">`)
+	fmt.Fprintf(&b, `<h3 style="font-family:sans-serif">%s</h3>`, html.EscapeString(title))
+	b.WriteString(`<table style="font-family:sans-serif;border-collapse:collapse;text-align:right">`)
+	b.WriteString(`<tr>`)
+	for _, h := range header {
+		fmt.Fprintf(&b, `<th style="padding:2px 16px 2px 0;color:#666">%s</th>`, html.EscapeString(h))
+	}
+	b.WriteString(`</tr>`)
+	for _, row := range rows {
+		b.WriteString(`<tr>`)
+		for i, v := range row {
+			style := "padding:2px 16px 2px 0"
+			if i == 0 {
+				style += ";text-align:left;color:#666"
+			}
+			fmt.Fprintf(&b, `<td style="%s">%s</td>`, style, html.EscapeString(v))
+		}
+		b.WriteString(`</tr>`)
+	}
+	b.WriteString(`</table></div></div>`)
+	return b.String()
+}
+
 func (m *ChartExtractor) generateChartsPage(withLiveUpdatesListener bool) *components.Page {
 	memoryUsageChart := m.generateMemoryUsageChart(withLiveUpdatesListener)
 	cpuUsageChart := m.generateCpuUsageChart(withLiveUpdatesListener)
 
 	page := components.NewPage()
+	if m.served && m.Assets.Mode == HtmlAssetsDir {
+		page.AssetsHost = HtmlAssetsPath
+	} else {
+		page.AssetsHost = m.Assets.host(m.Filename)
+	}
 	page.AddCharts(
 		memoryUsageChart,
 		cpuUsageChart,
 	)
 
+	// The process tree is known only after the profiling has finished
+	if !withLiveUpdatesListener && m.Series.HasProcessTree() {
+		page.AddCharts(m.generateProcessesMemoryChart())
+	}
+
 	return page
 }
 
@@ -150,18 +240,17 @@ func (m *ChartExtractor) generateCpuUsageChart(withLiveUpdatesListener bool) *ch
 		charts.WithLegendOpts(opts.Legend{Show: true}),
 	)
 
-	cpuPercentageLine := m.getCpuPercentageLineData()
-	timeParts := len(m.Data)
-	timeXAxis := m.DivideTimeIntoParts(timeParts)
+	points := m.Series.Downsample(TimeSeriesCpu)
+	cpuPercentageLine := getLineData(points, TimeSeriesCpu, avgOf, formatPercentage)
 
 	// Put data into instance
-	line.SetXAxis(timeXAxis).
+	line.SetXAxis(timeXAxis(points)).
 		AddSeries("CPU usage", cpuPercentageLine, charts.WithLabelOpts(opts.Label{Show: true, Position: "top"})).
 		SetSeriesOptions(
 			charts.WithLineChartOpts(opts.LineChart{Smooth: true}),
 		)
 
-	if m.UpdateLiveListenWSHost != "" && withLiveUpdatesListener {
+	if withLiveUpdatesListener {
 		m.AddCpuLineLiveUpdateJSFuncs(line)
 	}
 
@@ -182,37 +271,150 @@ func (m *ChartExtractor) generateMemoryUsageChart(withLiveUpdatesListener bool)
 		charts.WithLegendOpts(opts.Legend{Show: true}),
 	)
 
-	rssLine := m.getRssLineData()
-	timeParts := len(m.Data)
-	timeXAxis := m.DivideTimeIntoParts(timeParts)
+	points := m.Series.Downsample(TimeSeriesRss)
+	rssLine := getLineData(points, TimeSeriesRss, avgOf, formatMb)
 
 	// Put data into instance
-	line.SetXAxis(timeXAxis).
+	line.SetXAxis(timeXAxis(points)).
 		AddSeries("RSS", rssLine, charts.WithLabelOpts(opts.Label{Show: true, Position: "top"})).
 		SetSeriesOptions(
 			charts.WithLineChartOpts(opts.LineChart{Smooth: true}),
 		)
 
 	if runtime.GOOS != "darwin" {
-		rssSwapLine := m.getRssSwapLineData()
+		rssSwapLine := getLineData(points, TimeSeriesRssSwap, avgOf, formatMb)
 		line.AddSeries("RSS+Swap", rssSwapLine, charts.WithLabelOpts(opts.Label{Show: true, Position: "top"}))
+		pssLine := getLineData(points, TimeSeriesPss, avgOf, formatMb)
+		line.AddSeries("PSS", pssLine, charts.WithLabelOpts(opts.Label{Show: true, Position: "top"}))
+		ussLine := getLineData(points, TimeSeriesUss, avgOf, formatMb)
+		line.AddSeries("USS", ussLine, charts.WithLabelOpts(opts.Label{Show: true, Position: "top"}))
 	}
 
-	virtualMemLine := m.getVirtualMemLineData()
+	virtualMemLine := getLineData(points, TimeSeriesVirtual, avgOf, formatMb)
 	line.AddSeries("Virtual", virtualMemLine, charts.WithLabelOpts(opts.Label{Show: true, Position: "top"}))
 
-	if m.UpdateLiveListenWSHost != "" && withLiveUpdatesListener {
+	// Points that are buckets of older samples are drawn at their average,
+	// the extremes of the rss within them are drawn as well
+	if hasBuckets(points) {
+		line.AddSeries("RSS max", getLineData(points, TimeSeriesRss, maxOf, formatMb),
+			charts.WithLineStyleOpts(opts.LineStyle{Type: "dotted"}))
+		line.AddSeries("RSS min", getLineData(points, TimeSeriesRss, minOf, formatMb),
+			charts.WithLineStyleOpts(opts.LineStyle{Type: "dotted"}))
+	}
+
+	if m.Summary != nil && m.Summary.Leak != nil {
+		for _, t := range m.Summary.Leak.Trends {
+			line.AddSeries(
+				fmt.Sprintf("%s trend", strings.ToUpper(t.Metric)),
+				getTrendLineData(points, t),
+				charts.WithLineStyleOpts(opts.LineStyle{Type: "dashed"}),
+			)
+		}
+	}
+
+	if withLiveUpdatesListener {
 		m.AddMemoryLineLiveUpdateJSFuncs(line)
 	}
 
 	return line
 }
 
+// generateProcessesMemoryChart draws the rss of every process in the tracked
+// process tree separately, with a mark where each process spawned and exited.
+func (m *ChartExtractor) generateProcessesMemoryChart() *charts.Line {
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{Theme: types.ThemeWesteros}),
+		charts.WithTitleOpts(opts.Title{
+			Title:    fmt.Sprintf("Memory usage (mb) per process of %s", m.ProcessName),
+			Subtitle: "The rss of each process in the process tree",
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{Type: "slider", Start: 0, End: 80}),
+		charts.WithLegendOpts(opts.Legend{Show: true}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+	)
+
+	points := m.Series.Downsample(TimeSeriesRss)
+	var pids []int32
+	names := map[int32]string{}
+	series := map[int32][]opts.LineData{}
+	for i, d := range points {
+		for pid, p := range d.Processes {
+			if _, ok := series[pid]; !ok {
+				pids = append(pids, pid)
+				series[pid] = make([]opts.LineData, len(points))
+				for j := range series[pid] {
+					// echarts does not draw missing values
+					series[pid][j] = opts.LineData{Value: "-"}
+				}
+			}
+			names[pid] = p.Name
+			series[pid][i] = opts.LineData{Value: formatMb(p.Rss.Sum / float64(p.Count))}
+		}
+	}
+	// The processes of a point are in a map, the series are kept in order of pid
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	// The events of the points that were left out are marked at the point that is drawn next
+	marks := map[int32][]opts.MarkLineNameXAxisItem{}
+	for _, d := range m.Series.Points() {
+		for _, e := range d.Events {
+			marks[e.Pid] = append(marks[e.Pid], opts.MarkLineNameXAxisItem{
+				Name:  fmt.Sprintf("%s %s", e.Type, e.Name),
+				XAxis: pointIndexAt(points, e.Timestamp),
+			})
+		}
+	}
+
+	line.SetXAxis(timeXAxis(points))
+	for _, pid := range pids {
+		seriesOpts := []charts.SeriesOpts{charts.WithLineChartOpts(opts.LineChart{Smooth: true})}
+		if len(marks[pid]) > 0 {
+			seriesOpts = append(seriesOpts, charts.WithMarkLineNameXAxisItemOpts(marks[pid]...))
+		}
+		line.AddSeries(fmt.Sprintf("%s (%d)", names[pid], pid), series[pid], seriesOpts...)
+	}
+
+	return line
+}
+
 func (m *ChartExtractor) AddMemoryLineLiveUpdateJSFuncs(line *charts.Line) {
 	const isOSX = runtime.GOOS == "darwin"
 	js := fmt.Sprintf(`
 	console.log("initializing memory event listener");
-	const sse = new EventSource('http://%s/process/updates');
+	const sse = new EventSource('/process/updates');
+
+	/* The samples so far are loaded from the history before the live updates,
+	   which are held back meanwhile and skipped if the history already has them */
+	const statListeners = [];
+	const onStat = (listener) => statListeners.push(listener);
+	let lastStatTime = 0;
+	let pendingStats = [];
+	const publishStat = (stat) => {
+		const t = Date.parse(stat.timestamp);
+		if (t <= lastStatTime) {
+			return;
+		}
+		lastStatTime = t;
+		statListeners.forEach((listener) => listener(stat));
+	};
+	sse.addEventListener("message", (e) => {
+		const stat = JSON.parse(e.data);
+		if (pendingStats !== null) {
+			pendingStats.push(stat);
+		} else {
+			publishStat(stat);
+		}
+	});
+	fetch('/process/history')
+		.then((res) => res.json())
+		.then((history) => history.forEach(publishStat))
+		.catch((err) => console.log("could not load the history", err))
+		.finally(() => {
+			const pending = pendingStats;
+			pendingStats = null;
+			pending.forEach(publishStat);
+		});
 
 	const initializeMemChart = () => {
 		const option = {
@@ -220,9 +422,11 @@ func (m *ChartExtractor) AddMemoryLineLiveUpdateJSFuncs(line *charts.Line) {
 			series:[{name:"RSS", waveAnimation:true, animation: true, data: []}],
 			xAxis:[{name: "time", data: []}],
 		};
-		/* If it's not mac show rss+swap */
+		/* If it's not mac show rss+swap, pss and uss */
 		if (!%t) {
 				option.series.push({name:"RSS+Swap", waveAnimation:true, animation: true, data: []});
+				option.series.push({name:"PSS", waveAnimation:true, animation: true, data: []});
+				option.series.push({name:"USS", waveAnimation:true, animation: true, data: []});
 		}
 		goecharts_%s.setOption(option);
 	};
@@ -232,30 +436,33 @@ func (m *ChartExtractor) AddMemoryLineLiveUpdateJSFuncs(line *charts.Line) {
 	const xAxisData = [];
 	const showLastNValues = 25;
 
-	sse.addEventListener("message", (e) => {
-		const stat = JSON.parse(e.data);
+	onStat((stat) => {
 		memObjsCounter++;
 		const rssData = [];
 		const rssSwapData = [];
 
 		const rss = Math.trunc(stat.memoryUsage.rss / 1024);
 		const rssSwap = Math.trunc(stat.memoryUsage.rssSwap / 1024);
+		const pss = Math.trunc(stat.memoryUsage.pss / 1024);
+		const uss = Math.trunc(stat.memoryUsage.uss / 1024);
 		const timestamp = new Date(stat.timestamp).toISOString().slice(11, 20);
 
 		xAxisData.push(timestamp);
 
 
 		goecharts_%s.appendData({seriesIndex: 0, data: [rss]});
-		/* If it's not OSX add values to rss+swap */
+		/* If it's not OSX add values to rss+swap, pss and uss */
 		if (!%t) {
 			goecharts_%s.appendData({seriesIndex: 1, data: [rssSwap]});
+			goecharts_%s.appendData({seriesIndex: 2, data: [pss]});
+			goecharts_%s.appendData({seriesIndex: 3, data: [uss]});
 		}
 
 		goecharts_%s.setOption({
 			dataZoom: [{startValue: memObjsCounter - showLastNValues, endValue: memObjsCounter}],
 			xAxis: [{name: "time", data: xAxisData}]
 		});
-	});`, m.UpdateLiveListenWSHost, isOSX, line.ChartID, line.ChartID, isOSX, line.ChartID, line.ChartID)
+	});`, isOSX, line.ChartID, line.ChartID, isOSX, line.ChartID, line.ChartID, line.ChartID, line.ChartID)
 
 	line.AddJSFuncs(js)
 }
@@ -274,8 +481,7 @@ func (m *ChartExtractor) AddCpuLineLiveUpdateJSFuncs(line *charts.Line) {
 
 	let cpuObjsCounter = 0;
 	let cpuXAxisData = [];
-	sse.addEventListener("message", (e) => {
-		const stat = JSON.parse(e.data);
+	onStat((stat) => {
 		cpuObjsCounter++;
 		const cpuUsagePercentage = stat.cpuUsage.percentage.toString();
 		const timestamp = new Date(stat.timestamp).toISOString().slice(11, 20);
@@ -296,58 +502,58 @@ func (m *ChartExtractor) AddCpuLineLiveUpdateJSFuncs(line *charts.Line) {
 }
 
 func (m *ChartExtractor) reset() {
-	m.From = time.Now()
-	m.To = time.Time{}
-	m.Data = []ProcessStatsData{}
+	m.Series.Reset()
 }
 
-func (m *ChartExtractor) getCpuPercentageLineData() []opts.LineData {
-	items := make([]opts.LineData, len(m.Data))
-	for i := 0; i < len(m.Data); i++ {
-		items[i] = opts.LineData{Value: fmt.Sprintf("%.1f", m.Data[i].CpuUsage.Percentage)}
+// getLineData returns a statistic of a metric for every point, formatted for the chart
+func getLineData(points []TimeSeriesPoint, metric TimeSeriesMetric, stat func(TimeSeriesPoint, TimeSeriesMetric) float64, format func(float64) interface{}) []opts.LineData {
+	items := make([]opts.LineData, len(points))
+	for i, p := range points {
+		items[i] = opts.LineData{Value: format(stat(p, metric))}
 	}
 	return items
 }
 
-func (m *ChartExtractor) getRssLineData() []opts.LineData {
-	items := make([]opts.LineData, len(m.Data))
-	for i := 0; i < len(m.Data); i++ {
-		items[i] = opts.LineData{Value: m.Data[i].MemoryUsage.Rss / 1024}
-	}
-	return items
+func avgOf(p TimeSeriesPoint, m TimeSeriesMetric) float64 { return p.Avg(m) }
+func maxOf(p TimeSeriesPoint, m TimeSeriesMetric) float64 { return p.Stats[m].Max }
+func minOf(p TimeSeriesPoint, m TimeSeriesMetric) float64 { return p.Stats[m].Min }
+
+// formatMb formats kilobytes as whole megabytes
+func formatMb(kb float64) interface{} {
+	return int64(kb) / 1024
 }
 
-func (m *ChartExtractor) getRssSwapLineData() []opts.LineData {
-	items := make([]opts.LineData, len(m.Data))
-	for i := 0; i < len(m.Data); i++ {
-		items[i] = opts.LineData{Value: m.Data[i].MemoryUsage.RssSwap / 1024}
-	}
-	return items
+func formatPercentage(v float64) interface{} {
+	return fmt.Sprintf("%.1f", v)
 }
 
-func (m *ChartExtractor) getVirtualMemLineData() []opts.LineData {
-	items := make([]opts.LineData, len(m.Data))
-	for i := 0; i < len(m.Data); i++ {
-		items[i] = opts.LineData{Value: m.Data[i].MemoryUsage.Virtual / 1024}
+func hasBuckets(points []TimeSeriesPoint) bool {
+	for _, p := range points {
+		if p.Count > 1 {
+			return true
+		}
 	}
-	return items
+	return false
 }
 
-// DivideTimeIntoParts returns a string formatted time that is divided into parts
-func (m *ChartExtractor) DivideTimeIntoParts(parts int) []string {
-	if parts == 0 {
-		parts = 1
+// getTrendLineData returns the memory the trend predicts for every point after its start
+func getTrendLineData(points []TimeSeriesPoint, t Trend) []opts.LineData {
+	items := make([]opts.LineData, len(points))
+	for i, p := range points {
+		if p.To.Before(t.From) {
+			items[i] = opts.LineData{Value: "-"}
+			continue
+		}
+		items[i] = opts.LineData{Value: fmt.Sprintf("%.1f", t.ValueAt(p.To)/1024)}
 	}
+	return items
+}
 
-	partsResult := []string{}
-
-	totalTime := m.To.Sub(m.From)
-	part := totalTime.Milliseconds() / int64(parts) // How much time each part has
-	var t time.Time = m.From
-	for i := 0; i < parts; i++ {
-		t = t.Add(time.Millisecond * time.Duration(part))
-		tStr := fmt.Sprintf(t.Format(time.RFC3339)[11:19])
-		partsResult = append(partsResult, tStr)
+// timeXAxis returns the time of the last sample of every point
+func timeXAxis(points []TimeSeriesPoint) []string {
+	times := make([]string, len(points))
+	for i, p := range points {
+		times[i] = p.To.Local().Format("15:04:05")
 	}
-	return partsResult
+	return times
 }
@@ -0,0 +1,227 @@
+package extractors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/components"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/go-echarts/go-echarts/v2/types"
+)
+
+// CompareRun is a run that is compared with other runs
+type CompareRun struct {
+	// Name tells the run apart in the charts and the table, e.g. the name of its file
+	Name    string
+	Start   time.Time
+	Summary Summary
+	// Series are the samples of the run
+	Series *TimeSeries
+}
+
+// NewCompareRun summarises the samples of a run for the comparison
+func NewCompareRun(name string, samples []ProcessStatsData, refreshInterval time.Duration, opts TimeSeriesOptions) CompareRun {
+	summary := NewSummaryCollector(refreshInterval)
+	series := NewTimeSeries(opts)
+	for _, s := range samples {
+		summary.Add(s)
+		series.Add(s)
+	}
+
+	run := CompareRun{Name: name, Summary: summary.Summary(), Series: series}
+	if len(samples) > 0 {
+		run.Start = samples[0].Timestamp
+	}
+	return run
+}
+
+// CompareStat is a statistic of the summary that runs are compared by, the lower the better
+type CompareStat struct {
+	// Key is how the statistic is named in flags
+	Key   string
+	Name  string
+	Value func(Summary) float64
+}
+
+// CompareStats are the statistics that runs are compared by, in the order of the table
+var CompareStats = []CompareStat{
+	{"peak-rss", "peak rss mb", func(s Summary) float64 { return s.RssKb.Max / 1024 }},
+	{"mean-rss", "mean rss mb", func(s Summary) float64 { return s.RssKb.Mean / 1024 }},
+	{"p95-rss", "p95 rss mb", func(s Summary) float64 { return s.RssKb.P95 / 1024 }},
+	{"peak-pss", "peak pss mb", func(s Summary) float64 { return s.PssKb.Max / 1024 }},
+	{"mean-cpu", "mean cpu %", func(s Summary) float64 { return s.CpuPercent.Mean }},
+	{"p95-cpu", "p95 cpu %", func(s Summary) float64 { return s.CpuPercent.P95 }},
+	{"cpu-seconds", "cpu seconds", func(s Summary) float64 { return s.CpuSeconds }},
+	{"duration", "duration s", func(s Summary) float64 { return s.Duration.Seconds() }},
+	{"memory-time", "memory-time MB·s", func(s Summary) float64 { return s.MemoryTimeArea }},
+}
+
+// ParseCompareStats parses a comma separated list of the keys of CompareStats
+func ParseCompareStats(s string) ([]CompareStat, error) {
+	var stats []CompareStat
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		found := false
+		for _, stat := range CompareStats {
+			if stat.Key == key {
+				stats = append(stats, stat)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown statistic %q", key)
+		}
+	}
+	return stats, nil
+}
+
+// Comparison compares the statistics of runs with those of the first run, the baseline
+type Comparison struct {
+	Runs []CompareRun
+	// ThresholdPercent is by how much a statistic may be worse than the baseline before it is a regression
+	ThresholdPercent float64
+	// FailOn are the statistics that are checked for regressions
+	FailOn []CompareStat
+}
+
+// Regression is a statistic of a run that is worse than the baseline by more than the threshold
+type Regression struct {
+	Run          string
+	Stat         CompareStat
+	DeltaPercent float64
+}
+
+// Delta returns by how much a statistic of a run differs from the baseline, in percent.
+// It is NaN if the baseline is zero, as there is nothing to compare with.
+func (c Comparison) Delta(stat CompareStat, run int) float64 {
+	base := stat.Value(c.Runs[0].Summary)
+	if base == 0 {
+		return math.NaN()
+	}
+	return (stat.Value(c.Runs[run].Summary) - base) / base * 100
+}
+
+func (c Comparison) isRegression(stat CompareStat, run int) bool {
+	for _, s := range c.FailOn {
+		if s.Key == stat.Key {
+			// NaN is never greater than the threshold
+			return c.Delta(stat, run) > c.ThresholdPercent
+		}
+	}
+	return false
+}
+
+// Regressions returns the statistics of every run after the baseline that regressed
+func (c Comparison) Regressions() []Regression {
+	var regressions []Regression
+	for i := 1; i < len(c.Runs); i++ {
+		for _, stat := range CompareStats {
+			if c.isRegression(stat, i) {
+				regressions = append(regressions, Regression{
+					Run:          c.Runs[i].Name,
+					Stat:         stat,
+					DeltaPercent: c.Delta(stat, i),
+				})
+			}
+		}
+	}
+	return regressions
+}
+
+// Rows returns the statistics of every run as table rows, each run after the baseline
+// followed by its delta from the baseline, marked with a ! if it is a regression
+func (c Comparison) Rows() (header []string, rows [][]string) {
+	header = []string{"", c.Runs[0].Name}
+	for _, r := range c.Runs[1:] {
+		header = append(header, r.Name, "delta")
+	}
+	for _, stat := range CompareStats {
+		row := []string{stat.Name, fmt.Sprintf("%.2f", stat.Value(c.Runs[0].Summary))}
+		for i := 1; i < len(c.Runs); i++ {
+			delta := "n/a"
+			if d := c.Delta(stat, i); !math.IsNaN(d) {
+				delta = fmt.Sprintf("%+.1f%%", d)
+			}
+			if c.isRegression(stat, i) {
+				delta += " !"
+			}
+			row = append(row, fmt.Sprintf("%.2f", stat.Value(c.Runs[i].Summary)), delta)
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+// WriteCompareChart writes the memory and cpu of the runs overlaid in an html file,
+// aligned by the time elapsed since the start of each run, followed by the comparison table
+func WriteCompareChart(filename string, c Comparison, assets HtmlAssets) error {
+	fs, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer fs.Close()
+
+	page := components.NewPage()
+	page.AssetsHost = assets.host(filename)
+	page.AddCharts(
+		generateOverlayChart(c.Runs, "Memory usage (mb)", "The rss of each run", TimeSeriesRss, 1.0/1024),
+		generateOverlayChart(c.Runs, "CPU usage", "The cpu usage of each run", TimeSeriesCpu, 1),
+	)
+
+	header, rows := c.Rows()
+	sections := htmlTableSection("Comparison", header, rows)
+	if len(c.FailOn) > 0 {
+		var fields [][2]string
+		for _, r := range c.Regressions() {
+			fields = append(fields, [2]string{r.Run, fmt.Sprintf("%s %+.1f%%", r.Stat.Name, r.DeltaPercent)})
+		}
+		if len(fields) == 0 {
+			fields = append(fields, [2]string{"none", fmt.Sprintf("within %.1f%% of %s", c.ThresholdPercent, c.Runs[0].Name)})
+		}
+		sections += htmlFieldsSection("Regressions", fields)
+	}
+
+	if err := renderPage(fs, page, sections, assets, map[string][]byte{}); err != nil {
+		return fmt.Errorf("failed to write page: %w", err)
+	}
+
+	fmt.Printf("html comparison has been written at %s\n", filename)
+
+	return nil
+}
+
+// generateOverlayChart draws a metric of every run as a series against the seconds since the start of the run
+func generateOverlayChart(runs []CompareRun, title, subtitle string, metric TimeSeriesMetric, scale float64) *charts.Line {
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{Theme: types.ThemeWesteros}),
+		charts.WithTitleOpts(opts.Title{Title: title, Subtitle: subtitle}),
+		charts.WithXAxisOpts(opts.XAxis{Type: "value", Name: "seconds"}),
+		charts.WithDataZoomOpts(opts.DataZoom{Type: "slider", Start: 0, End: 100}),
+		charts.WithLegendOpts(opts.Legend{Show: true}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+	)
+
+	for _, r := range runs {
+		points := r.Series.Downsample(metric)
+		data := make([]opts.LineData, len(points))
+		for i, p := range points {
+			elapsed := p.To.Sub(r.Start).Seconds()
+			data[i] = opts.LineData{Value: []interface{}{
+				fmt.Sprintf("%.2f", elapsed),
+				fmt.Sprintf("%.1f", p.Avg(metric)*scale),
+			}}
+		}
+		line.AddSeries(r.Name, data, charts.WithLineChartOpts(opts.LineChart{Smooth: true}))
+	}
+
+	return line
+}
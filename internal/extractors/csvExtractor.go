@@ -1,26 +1,41 @@
 package extractors
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type CsvMemoryUsageExtractorOptions struct {
 	Filename string
+	// ExitStatus appends the exit status of the command to the csv as comment lines
+	ExitStatus bool
 }
 
 func NewCsvExtractorOptions(filename string) CsvMemoryUsageExtractorOptions {
 	return CsvMemoryUsageExtractorOptions{Filename: filename}
 }
 
+func (o *CsvMemoryUsageExtractorOptions) WithExitStatus(exitStatus bool) *CsvMemoryUsageExtractorOptions {
+	o.ExitStatus = exitStatus
+	return o
+}
+
 type CsvMemoryUsage struct {
 	Filename  string
-	Data      []ProcessStatsData
 	file      *os.File
 	csvWriter *csv.Writer
+	// writeExitStatus appends exitStatus as comments at the end of the csv,
+	// which not every csv reader skips, so it is off unless asked for
+	writeExitStatus bool
+	exitStatus      *ExitStatusData
 }
 
 func NewCsvMemoryUsageExtractor(filename string) (*CsvMemoryUsage, error) {
@@ -38,7 +53,6 @@ func NewCsvMemoryUsageExtractor(filename string) (*CsvMemoryUsage, error) {
 }
 
 func (c *CsvMemoryUsage) Add(data ProcessStatsData) error {
-	c.Data = append(c.Data, data)
 	c.csvWriter.Write(c.dataToCsvRecord(data))
 	return nil
 }
@@ -51,29 +65,63 @@ func (c *CsvMemoryUsage) dataToCsvRecord(data ProcessStatsData) []string {
 	rssSwap := fmt.Sprintf("%d", data.MemoryUsage.RssSwap)
 	virt := fmt.Sprintf("%d", data.MemoryUsage.Virtual)
 	cpuPercent := fmt.Sprintf("%.1f", data.CpuUsage.Percentage)
+	userCpuPercent := fmt.Sprintf("%.1f", data.CpuUsage.UserPercentage)
+	systemCpuPercent := fmt.Sprintf("%.1f", data.CpuUsage.SystemPercentage)
+	lifetimeCpuPercent := fmt.Sprintf("%.1f", data.CpuUsage.LifetimePercentage)
+	pss := fmt.Sprintf("%d", data.MemoryUsage.Pss)
+	uss := fmt.Sprintf("%d", data.MemoryUsage.Uss)
+	sharedClean := fmt.Sprintf("%d", data.MemoryUsage.SharedClean)
+	sharedDirty := fmt.Sprintf("%d", data.MemoryUsage.SharedDirty)
+	swapPss := fmt.Sprintf("%d", data.MemoryUsage.SwapPss)
 
 	if runtime.GOOS != "darwin" {
-		r = []string{timestamp, rss, rssSwap, virt, cpuPercent}
+		r = []string{
+			timestamp, rss, rssSwap, virt, cpuPercent, userCpuPercent, systemCpuPercent, lifetimeCpuPercent,
+			pss, uss, sharedClean, sharedDirty, swapPss,
+		}
 	} else {
-		r = []string{timestamp, rss, virt, cpuPercent}
+		r = []string{timestamp, rss, rssSwap, virt, cpuPercent}
 	}
 
 	return r
 }
 
+// writeCsvExitStatus appends the exit status to a csv as comment lines,
+// which csv readers that support comments, like encoding/csv with Comment = '#', skip.
+func writeCsvExitStatus(w io.Writer, status ExitStatusData) error {
+	for _, f := range status.Fields() {
+		if _, err := fmt.Fprintf(w, "# %s: %s\n", f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *CsvMemoryUsage) headers() []string {
 	var headers []string
 	if runtime.GOOS != "darwin" {
-		headers = []string{"timestamp", "rss kb", "rss+swap kb", "virtual kb", "cpu%"}
+		headers = []string{
+			"timestamp", "rss kb", "rss+swap kb", "virtual kb", "cpu%", "user cpu%", "system cpu%", "lifetime cpu%",
+			"pss kb", "uss kb", "shared clean kb", "shared dirty kb", "swap pss kb",
+		}
 	} else {
-		headers = []string{"timestamp", "rss kb", "virtual kb", "cpu%"}
+		headers = []string{"timestamp", "rss kb", "rss+swap kb", "virtual kb", "cpu%"}
 	}
 	return headers
 }
 
+func (c *CsvMemoryUsage) SetExitStatus(status ExitStatusData) {
+	c.exitStatus = &status
+}
+
 func (c *CsvMemoryUsage) StopAndExtract() error {
 	fmt.Printf("csv has been written at %s\n", c.Filename)
 	c.csvWriter.Flush()
+	if c.writeExitStatus && c.exitStatus != nil {
+		if err := writeCsvExitStatus(c.file, *c.exitStatus); err != nil {
+			return fmt.Errorf("failed to write exit status: %w", err)
+		}
+	}
 	err := c.file.Close()
 	if err != nil {
 		return fmt.Errorf("failed to close csv file")
@@ -81,3 +129,162 @@ func (c *CsvMemoryUsage) StopAndExtract() error {
 
 	return nil
 }
+
+// ReadCsvMemoryUsage reads back the samples and the exit status of a csv written by CsvMemoryUsage.
+// The columns are found by their headers, so csvs of every platform can be read.
+// Timestamps in the csv are in seconds, so samples taken within the same second have the same timestamp.
+func ReadCsvMemoryUsage(filename string) ([]ProcessStatsData, *ExitStatusData, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read csv: %w", err)
+	}
+
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("csv has no header")
+	}
+
+	columns := map[string]int{}
+	for i, h := range records[0] {
+		columns[strings.TrimSpace(h)] = i
+	}
+	if _, ok := columns["timestamp"]; !ok {
+		return nil, nil, fmt.Errorf("csv has no timestamp column")
+	}
+	if _, ok := columns["rss kb"]; !ok {
+		return nil, nil, fmt.Errorf("csv has no rss kb column")
+	}
+
+	var samples []ProcessStatsData
+	for i, record := range records[1:] {
+		line := i + 2
+		field := func(name string) (string, bool) {
+			c, ok := columns[name]
+			if !ok || c >= len(record) {
+				return "", false
+			}
+			return record[c], true
+		}
+		kb := func(name string) (int64, error) {
+			v, ok := field(name)
+			if !ok {
+				return 0, nil
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %s: %w", line, name, err)
+			}
+			return n, nil
+		}
+		percent := func(name string) (float32, error) {
+			v, ok := field(name)
+			if !ok {
+				return 0, nil
+			}
+			f, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %s: %w", line, name, err)
+			}
+			return float32(f), nil
+		}
+
+		ts, _ := field("timestamp")
+		timestamp, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: timestamp: %w", line, err)
+		}
+		d := ProcessStatsData{Timestamp: timestamp}
+		for _, f := range []struct {
+			name string
+			v    *int64
+		}{
+			{"rss kb", &d.MemoryUsage.Rss},
+			{"rss+swap kb", &d.MemoryUsage.RssSwap},
+			{"virtual kb", &d.MemoryUsage.Virtual},
+			{"pss kb", &d.MemoryUsage.Pss},
+			{"uss kb", &d.MemoryUsage.Uss},
+			{"shared clean kb", &d.MemoryUsage.SharedClean},
+			{"shared dirty kb", &d.MemoryUsage.SharedDirty},
+			{"swap pss kb", &d.MemoryUsage.SwapPss},
+		} {
+			if *f.v, err = kb(f.name); err != nil {
+				return nil, nil, err
+			}
+		}
+		for _, f := range []struct {
+			name string
+			v    *float32
+		}{
+			{"cpu%", &d.CpuUsage.Percentage},
+			{"user cpu%", &d.CpuUsage.UserPercentage},
+			{"system cpu%", &d.CpuUsage.SystemPercentage},
+			{"lifetime cpu%", &d.CpuUsage.LifetimePercentage},
+		} {
+			if *f.v, err = percent(f.name); err != nil {
+				return nil, nil, err
+			}
+		}
+		samples = append(samples, d)
+	}
+
+	exitStatus, err := readCsvExitStatus(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return samples, exitStatus, nil
+}
+
+// readCsvExitStatus reads the exit status from the comment lines of a csv, if there are any
+func readCsvExitStatus(b []byte) (*ExitStatusData, error) {
+	var status ExitStatusData
+	found := false
+	for _, line := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			continue
+		}
+		name, value := line[2:i], strings.TrimSpace(line[i+2:])
+
+		var err error
+		switch name {
+		case "exit code":
+			found = true
+			if j := strings.Index(value, " ("); j >= 0 {
+				status.Signal = strings.TrimSuffix(value[j+2:], ")")
+				value = value[:j]
+			}
+			status.ExitCode, err = strconv.Atoi(value)
+		case "max rss":
+			status.MaxRss, err = strconv.ParseInt(strings.TrimSuffix(value, " kb"), 10, 64)
+		case "user time":
+			status.UserTime, err = time.ParseDuration(value)
+		case "system time":
+			status.SystemTime, err = time.ParseDuration(value)
+		case "minor page faults":
+			status.MinorPageFaults, err = strconv.ParseInt(value, 10, 64)
+		case "major page faults":
+			status.MajorPageFaults, err = strconv.ParseInt(value, 10, 64)
+		case "voluntary context switches":
+			status.VoluntaryContextSwitches, err = strconv.ParseInt(value, 10, 64)
+		case "involuntary context switches":
+			status.InvoluntaryContextSwitches, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read exit status %s: %w", name, err)
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return &status, nil
+}
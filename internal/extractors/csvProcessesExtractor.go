@@ -0,0 +1,104 @@
+package extractors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+)
+
+type CsvProcessesExtractorOptions struct {
+	Filename string
+}
+
+func NewCsvProcessesExtractorOptions(filename string) CsvProcessesExtractorOptions {
+	return CsvProcessesExtractorOptions{Filename: filename}
+}
+
+// CsvProcesses extracts the stats of every process of the tracked process tree,
+// one row per process per sample, as well as a row whenever a process spawns or exits.
+type CsvProcesses struct {
+	Filename  string
+	file      *os.File
+	csvWriter *csv.Writer
+	// exitStatus is written as comments at the end of the csv
+	exitStatus *ExitStatusData
+}
+
+func NewCsvProcessesExtractor(filename string) (*CsvProcesses, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create csv file: %w", err)
+	}
+
+	csvWriter := csv.NewWriter(f)
+	csvExtractor := &CsvProcesses{Filename: filename, file: f, csvWriter: csvWriter}
+
+	csvWriter.Write(csvExtractor.headers())
+
+	return csvExtractor, nil
+}
+
+func (c *CsvProcesses) Add(data ProcessStatsData) error {
+	for _, e := range data.Events {
+		c.csvWriter.Write(c.eventToCsvRecord(e))
+	}
+	timestamp := data.Timestamp.Local().Format(time.RFC3339)
+	for _, p := range data.Processes {
+		c.csvWriter.Write(c.processToCsvRecord(timestamp, p))
+	}
+	return nil
+}
+
+func (c *CsvProcesses) processToCsvRecord(timestamp string, p ProcessData) []string {
+	return []string{
+		timestamp,
+		"sample",
+		fmt.Sprintf("%d", p.Pid),
+		fmt.Sprintf("%d", p.PPid),
+		p.Name,
+		fmt.Sprintf("%d", p.MemoryUsage.Rss),
+		fmt.Sprintf("%d", p.MemoryUsage.Pss),
+		fmt.Sprintf("%d", p.MemoryUsage.Uss),
+		fmt.Sprintf("%d", p.MemoryUsage.Virtual),
+		fmt.Sprintf("%.1f", p.CpuUsage.Percentage),
+		fmt.Sprintf("%d", p.Threads),
+		p.Cmdline,
+	}
+}
+
+func (c *CsvProcesses) eventToCsvRecord(e ProcessEventData) []string {
+	return []string{
+		e.Timestamp.Local().Format(time.RFC3339),
+		e.Type,
+		fmt.Sprintf("%d", e.Pid),
+		fmt.Sprintf("%d", e.PPid),
+		e.Name,
+		"", "", "", "", "", "",
+		e.Cmdline,
+	}
+}
+
+func (c *CsvProcesses) headers() []string {
+	return []string{"timestamp", "event", "pid", "ppid", "name", "rss kb", "pss kb", "uss kb", "virtual kb", "cpu%", "threads", "cmdline"}
+}
+
+func (c *CsvProcesses) SetExitStatus(status ExitStatusData) {
+	c.exitStatus = &status
+}
+
+func (c *CsvProcesses) StopAndExtract() error {
+	fmt.Printf("processes csv has been written at %s\n", c.Filename)
+	c.csvWriter.Flush()
+	if c.exitStatus != nil {
+		if err := writeCsvExitStatus(c.file, *c.exitStatus); err != nil {
+			return fmt.Errorf("failed to write exit status: %w", err)
+		}
+	}
+	err := c.file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close csv file")
+	}
+
+	return nil
+}
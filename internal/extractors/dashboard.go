@@ -0,0 +1,34 @@
+package extractors
+
+import (
+	"io"
+	"sync"
+)
+
+// Dashboard is the live charts page that the http server serves.
+// The page loads the samples so far from /process/history and then follows /process/updates,
+// both relative to the page, so that it works through a tunnel or a proxy.
+type Dashboard struct {
+	mu    sync.Mutex
+	chart *ChartExtractor
+}
+
+func NewDashboard(processName string, assets HtmlAssets, timeSeries TimeSeriesOptions) *Dashboard {
+	return &Dashboard{
+		chart: &ChartExtractor{
+			ProcessName:   processName,
+			Series:        NewTimeSeries(timeSeries),
+			Assets:        assets,
+			inlinedAssets: map[string][]byte{},
+			served:        true,
+		},
+	}
+}
+
+func (d *Dashboard) RenderDashboard(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	page := d.chart.generateChartsPage(true)
+	return d.chart.renderPage(w, page)
+}
@@ -6,19 +6,102 @@ import (
 )
 
 type MemoryUsageData struct {
-	Rss     int64
-	RssSwap int64
-	Virtual int64
+	Rss         int64
+	RssSwap     int64
+	Virtual     int64
+	Pss         int64
+	Uss         int64
+	SharedClean int64
+	SharedDirty int64
+	SwapPss     int64
 }
 
 type CpuUsageData struct {
-	Percentage float32
+	Percentage         float32
+	UserPercentage     float32
+	SystemPercentage   float32
+	LifetimePercentage float32
 }
 
 type ProcessStatsData struct {
 	MemoryUsage MemoryUsageData
 	CpuUsage    CpuUsageData
 	Timestamp   time.Time
+	// Processes are the stats of each process in the tracked process tree
+	Processes []ProcessData
+	// Events are the processes that were spawned or exited since the previous sample
+	Events []ProcessEventData
+}
+
+// ProcessData are the stats of a single process of the tracked process tree
+type ProcessData struct {
+	Pid         int32
+	PPid        int32
+	Name        string
+	Cmdline     string
+	Threads     int64
+	MemoryUsage MemoryUsageData
+	CpuUsage    CpuUsageData
+}
+
+type ProcessEventData struct {
+	// Type is either "spawn" or "exit"
+	Type      string
+	Pid       int32
+	PPid      int32
+	Name      string
+	Cmdline   string
+	Timestamp time.Time
+}
+
+// MarkerData is a point in time of the run that the user marked, e.g. the start of a phase
+type MarkerData struct {
+	Name      string
+	Timestamp time.Time
+}
+
+// RunMetadata describes the profiled process and how it is profiled
+type RunMetadata struct {
+	Pid     int32
+	Name    string
+	Cmdline string
+	// Hostname is the name of the machine the process runs on
+	Hostname        string
+	StartTime       time.Time
+	RefreshInterval time.Duration
+}
+
+// ExitStatusData is how the profiled command exited and the resources it used
+type ExitStatusData struct {
+	ExitCode int
+	// Signal is the name of the signal that killed the command, if any
+	Signal string
+	// MaxRss is the peak resident set size in kilobytes
+	MaxRss                     int64
+	UserTime                   time.Duration
+	SystemTime                 time.Duration
+	MinorPageFaults            int64
+	MajorPageFaults            int64
+	VoluntaryContextSwitches   int64
+	InvoluntaryContextSwitches int64
+}
+
+// Fields returns the exit status as human readable name-value pairs
+func (s ExitStatusData) Fields() [][2]string {
+	exitCode := fmt.Sprintf("%d", s.ExitCode)
+	if s.Signal != "" {
+		exitCode = fmt.Sprintf("%d (%s)", s.ExitCode, s.Signal)
+	}
+	return [][2]string{
+		{"exit code", exitCode},
+		{"max rss", fmt.Sprintf("%d kb", s.MaxRss)},
+		{"user time", s.UserTime.String()},
+		{"system time", s.SystemTime.String()},
+		{"minor page faults", fmt.Sprintf("%d", s.MinorPageFaults)},
+		{"major page faults", fmt.Sprintf("%d", s.MajorPageFaults)},
+		{"voluntary context switches", fmt.Sprintf("%d", s.VoluntaryContextSwitches)},
+		{"involuntary context switches", fmt.Sprintf("%d", s.InvoluntaryContextSwitches)},
+	}
 }
 
 type Extractor interface {
@@ -26,6 +109,19 @@ type Extractor interface {
 	StopAndExtract() error
 }
 
+// ExitStatusExtractor is an Extractor that also extracts
+// the exit status of the profiled command, when there is one.
+// SetExitStatus is called before StopAndExtract.
+type ExitStatusExtractor interface {
+	SetExitStatus(status ExitStatusData)
+}
+
+// MarkerExtractor is an Extractor that also extracts the markers of the run.
+// AddMarker is called in between the calls to Add.
+type MarkerExtractor interface {
+	AddMarker(marker MarkerData)
+}
+
 type Extractors struct {
 	extractors []Extractor
 }
@@ -42,7 +138,62 @@ func NewExtractors(opts ...interface{}) Extractors {
 			if err != nil {
 				panic(fmt.Errorf("failed to create csv extractor: %w", err))
 			}
+			csvExtractor.writeExitStatus = opt.ExitStatus
 			extractors.extractors = append(extractors.extractors, csvExtractor)
+		case CsvProcessesExtractorOptions:
+			csvProcessesExtractor, err := NewCsvProcessesExtractor(opt.Filename)
+			if err != nil {
+				panic(fmt.Errorf("failed to create csv processes extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, csvProcessesExtractor)
+		case JsonExtractorOptions:
+			extractors.extractors = append(extractors.extractors, NewJsonExtractor(opt))
+		case NdjsonExtractorOptions:
+			ndjsonExtractor, err := NewNdjsonExtractor(opt)
+			if err != nil {
+				panic(fmt.Errorf("failed to create ndjson extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, ndjsonExtractor)
+		case PrometheusFileExtractorOptions:
+			extractors.extractors = append(extractors.extractors, NewPrometheusFileExtractor(opt))
+		case InfluxExtractorOptions:
+			influxExtractor, err := NewInfluxExtractor(opt)
+			if err != nil {
+				panic(fmt.Errorf("failed to create influx extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, influxExtractor)
+		case GraphiteExtractorOptions:
+			extractors.extractors = append(extractors.extractors, NewGraphiteExtractor(opt))
+		case StatsdExtractorOptions:
+			statsdExtractor, err := NewStatsdExtractor(opt)
+			if err != nil {
+				panic(fmt.Errorf("failed to create statsd extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, statsdExtractor)
+		case OtlpExtractorOptions:
+			otlpExtractor, err := NewOtlpExtractor(opt)
+			if err != nil {
+				panic(fmt.Errorf("failed to create otlp extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, otlpExtractor)
+		case TraceExtractorOptions:
+			traceExtractor, err := NewTraceExtractor(opt)
+			if err != nil {
+				panic(fmt.Errorf("failed to create trace extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, traceExtractor)
+		case SessionExtractorOptions:
+			sessionExtractor, err := NewSessionExtractor(opt)
+			if err != nil {
+				panic(fmt.Errorf("failed to create session extractor: %w", err))
+			}
+			extractors.extractors = append(extractors.extractors, sessionExtractor)
+		case PprofExtractorOptions:
+			extractors.extractors = append(extractors.extractors, NewPprofExtractor(opt))
+		case BenchmarkRunExtractorOptions:
+			extractors.extractors = append(extractors.extractors, opt.Run)
+		case SummaryJsonExtractorOptions:
+			extractors.extractors = append(extractors.extractors, NewSummaryJsonExtractor(opt.Filename))
 		}
 	}
 
@@ -58,6 +209,30 @@ func (m *Extractors) Add(d ProcessStatsData) error {
 	return nil
 }
 
+func (m *Extractors) SetExitStatus(status ExitStatusData) {
+	for _, e := range m.extractors {
+		if se, ok := e.(ExitStatusExtractor); ok {
+			se.SetExitStatus(status)
+		}
+	}
+}
+
+func (m *Extractors) AddMarker(marker MarkerData) {
+	for _, e := range m.extractors {
+		if me, ok := e.(MarkerExtractor); ok {
+			me.AddMarker(marker)
+		}
+	}
+}
+
+func (m *Extractors) SetSummary(summary Summary) {
+	for _, e := range m.extractors {
+		if se, ok := e.(SummaryExtractor); ok {
+			se.SetSummary(summary)
+		}
+	}
+}
+
 func (m *Extractors) StopAndExtract() error {
 	for _, e := range m.extractors {
 		if err := e.StopAndExtract(); err != nil {
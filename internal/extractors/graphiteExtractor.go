@@ -0,0 +1,137 @@
+package extractors
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type GraphiteExtractorOptions struct {
+	// Address is the host:port of the plaintext listener of carbon
+	Address string
+	// Prefix is prepended to every metric path, it defaults to peekprof.<process name>
+	Prefix string
+	Run    RunMetadata
+}
+
+func NewGraphiteExtractorOptions(address, prefix string, run RunMetadata) GraphiteExtractorOptions {
+	return GraphiteExtractorOptions{Address: address, Prefix: prefix, Run: run}
+}
+
+// Graphite streams every sample over tcp in the Graphite plaintext protocol.
+// The metrics of each process of the tree are sent under <prefix>.processes.<name>_<pid>.
+type Graphite struct {
+	Address string
+	prefix  string
+	sender  *batchSender
+	conn    net.Conn
+	// unsent are the lines of a batch that a failed write did not get through,
+	// the tail of the batch that the sender retries
+	unsent []interface{}
+}
+
+func NewGraphiteExtractor(opts GraphiteExtractorOptions) *Graphite {
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "peekprof." + graphiteNode(opts.Run.Name)
+	}
+	e := &Graphite{Address: opts.Address, prefix: prefix}
+	e.sender = newBatchSender(defaultBatchSenderOptions("graphite"), e.send, e.close)
+
+	return e
+}
+
+// send writes the batch on the connection, which is dialed again after it fails.
+// A retry of a batch that was written in part writes only the lines that did not get through,
+// carbon drops the line that was cut off when the connection closed.
+func (e *Graphite) send(batch []interface{}) error {
+	lines := batch
+	if n := len(e.unsent); n > 0 && len(batch) >= n && &batch[len(batch)-1] == &e.unsent[n-1] {
+		lines = e.unsent
+	}
+	e.unsent = nil
+
+	if e.conn == nil {
+		conn, err := net.DialTimeout("tcp", e.Address, 5*time.Second)
+		if err != nil {
+			e.unsent = lines
+			return err
+		}
+		e.conn = conn
+	}
+
+	var b bytes.Buffer
+	ends := make([]int, len(lines))
+	for i, line := range lines {
+		b.Write(line.([]byte))
+		b.WriteByte('\n')
+		ends[i] = b.Len()
+	}
+	e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	n, err := e.conn.Write(b.Bytes())
+	if err != nil {
+		e.conn.Close()
+		e.conn = nil
+		written := sort.SearchInts(ends, n+1)
+		if written == len(lines) {
+			return nil
+		}
+		e.unsent = lines[written:]
+		return err
+	}
+	return nil
+}
+
+// close closes the connection, from the goroutine of the sender that uses it
+func (e *Graphite) close() error {
+	if e.conn == nil {
+		return nil
+	}
+	return e.conn.Close()
+}
+
+func (e *Graphite) Add(data ProcessStatsData) error {
+	ts := strconv.FormatInt(data.Timestamp.Unix(), 10)
+	metric := func(path string, value string) {
+		e.sender.Enqueue([]byte(path + " " + value + " " + ts))
+	}
+	mu := data.MemoryUsage
+	metric(e.prefix+".rss_kb", strconv.FormatInt(mu.Rss, 10))
+	metric(e.prefix+".swap_kb", strconv.FormatInt(mu.RssSwap-mu.Rss, 10))
+	metric(e.prefix+".virtual_kb", strconv.FormatInt(mu.Virtual, 10))
+	metric(e.prefix+".pss_kb", strconv.FormatInt(mu.Pss, 10))
+	metric(e.prefix+".uss_kb", strconv.FormatInt(mu.Uss, 10))
+	metric(e.prefix+".cpu_percent", formatFloat32(data.CpuUsage.Percentage))
+
+	for _, p := range data.Processes {
+		path := fmt.Sprintf("%s.processes.%s_%d", e.prefix, graphiteNode(p.Name), p.Pid)
+		metric(path+".rss_kb", strconv.FormatInt(p.MemoryUsage.Rss, 10))
+		metric(path+".pss_kb", strconv.FormatInt(p.MemoryUsage.Pss, 10))
+		metric(path+".virtual_kb", strconv.FormatInt(p.MemoryUsage.Virtual, 10))
+		metric(path+".cpu_percent", formatFloat32(p.CpuUsage.Percentage))
+		metric(path+".threads", strconv.FormatInt(p.Threads, 10))
+	}
+
+	return nil
+}
+
+func (e *Graphite) StopAndExtract() error {
+	sent, dropped, err := e.sender.Close()
+	fmt.Printf("graphite: sent %d metrics to %s, dropped %d\n", sent, e.Address, dropped)
+	if err != nil {
+		fmt.Printf("graphite: %s\n", err)
+	}
+
+	return nil
+}
+
+var graphiteNodeInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// graphiteNode makes a name usable as a single node of a metric path
+func graphiteNode(name string) string {
+	return graphiteNodeInvalidChars.ReplaceAllString(name, "_")
+}
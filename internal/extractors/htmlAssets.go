@@ -0,0 +1,138 @@
+package extractors
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// HtmlAssetsMode is how the html chart loads the echarts scripts
+type HtmlAssetsMode string
+
+const (
+	// HtmlAssetsCdn loads the scripts from the go-echarts assets host
+	HtmlAssetsCdn HtmlAssetsMode = "cdn"
+	// HtmlAssetsInline writes the scripts into the page, so that the page is self-contained
+	HtmlAssetsInline HtmlAssetsMode = "inline"
+	// HtmlAssetsDir loads the scripts from a local directory, relative to the page
+	HtmlAssetsDir HtmlAssetsMode = "dir"
+)
+
+// HtmlAssetsPath is where the http server serves the assets directory,
+// for the dashboard with HtmlAssetsDir
+const HtmlAssetsPath = "/assets/"
+
+// htmlAssetsCdnHost is where go-echarts loads its scripts from by default
+const htmlAssetsCdnHost = "https://go-echarts.github.io/go-echarts-assets/assets/"
+
+// htmlAssetNames are the scripts that the charts need, relative to the assets host
+var htmlAssetNames = []string{"echarts.min.js", "themes/westeros.js"}
+
+// embeddedHtmlAssets are the scripts that HtmlAssetsInline writes into the page without an assets directory.
+// They are copies of the scripts on the assets host, kept in the assets directory of this package.
+//
+//go:embed assets
+var embeddedHtmlAssets embed.FS
+
+func ParseHtmlAssetsMode(s string) (HtmlAssetsMode, error) {
+	switch m := HtmlAssetsMode(s); m {
+	case HtmlAssetsCdn, HtmlAssetsInline, HtmlAssetsDir:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown html assets mode %q", s)
+	}
+}
+
+// HtmlAssets are where the scripts of the html chart come from
+type HtmlAssets struct {
+	Mode HtmlAssetsMode
+	// Dir is a directory with the layout of the go-echarts assets host,
+	// echarts.min.js and themes/westeros.js.
+	// With HtmlAssetsInline the scripts embedded in peekprof are used if it is empty.
+	Dir string
+}
+
+func NewHtmlAssets(mode HtmlAssetsMode, dir string) (HtmlAssets, error) {
+	if mode == HtmlAssetsDir && dir == "" {
+		return HtmlAssets{}, fmt.Errorf("the assets directory is required to load the html assets from a directory")
+	}
+	for _, name := range htmlAssetNames {
+		if dir != "" {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+				return HtmlAssets{}, fmt.Errorf("html asset %s is missing: %w", name, err)
+			}
+		} else if mode == HtmlAssetsInline {
+			if _, err := fs.Stat(embeddedHtmlAssets, "assets/"+name); err != nil {
+				return HtmlAssets{}, fmt.Errorf("html asset %s is not embedded in this build, set an assets directory: %w", name, err)
+			}
+		}
+	}
+
+	return HtmlAssets{Mode: mode, Dir: dir}, nil
+}
+
+// host returns the host prefix of the scripts of a page written at filename
+func (a HtmlAssets) host(filename string) string {
+	if a.Mode != HtmlAssetsDir {
+		return htmlAssetsCdnHost
+	}
+
+	dir := a.Dir
+	if rel, err := filepath.Rel(filepath.Dir(filename), a.Dir); err == nil {
+		dir = rel
+	}
+	return filepath.ToSlash(dir) + "/"
+}
+
+var htmlScriptSrcRegexp = regexp.MustCompile(`<script src="([^"]+)"></script>`)
+
+// inline replaces the scripts that the page loads from the assets host with their contents
+func (a HtmlAssets) inline(page []byte, cache map[string][]byte) ([]byte, error) {
+	var err error
+	out := htmlScriptSrcRegexp.ReplaceAllFunc(page, func(tag []byte) []byte {
+		src := string(htmlScriptSrcRegexp.FindSubmatch(tag)[1])
+		if err != nil || !strings.HasPrefix(src, htmlAssetsCdnHost) {
+			return tag
+		}
+
+		script, ok := cache[src]
+		if !ok {
+			script, err = a.load(strings.TrimPrefix(src, htmlAssetsCdnHost))
+			if err != nil {
+				return tag
+			}
+			cache[src] = script
+		}
+
+		// A closing tag within the script would end the script element early
+		script = []byte(strings.ReplaceAll(string(script), "</script", `<\/script`))
+		return append(append([]byte("<script>\n"), script...), "\n</script>"...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// load reads an asset from the assets directory, or from the scripts embedded in peekprof
+func (a HtmlAssets) load(name string) ([]byte, error) {
+	if a.Dir != "" {
+		b, err := ioutil.ReadFile(filepath.Join(a.Dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read html asset: %w", err)
+		}
+		return b, nil
+	}
+
+	b, err := embeddedHtmlAssets.ReadFile("assets/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded html asset: %w", err)
+	}
+	return b, nil
+}
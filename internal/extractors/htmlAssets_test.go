@@ -0,0 +1,75 @@
+package extractors
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHtmlAssetsInline(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "themes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"echarts.min.js":     `var echarts = "</script>";`,
+		"themes/westeros.js": `var westeros = 1;`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assets, err := NewHtmlAssets(HtmlAssetsInline, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := []byte(`<head><script src="` + htmlAssetsCdnHost + `echarts.min.js"></script>` +
+		`<script src="` + htmlAssetsCdnHost + `themes/westeros.js"></script>` +
+		`<script src="https://example.com/other.js"></script></head>`)
+	got, err := assets.inline(page, map[string][]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<head><script>\nvar echarts = \"<\\/script>\";\n</script>" +
+		"<script>\nvar westeros = 1;\n</script>" +
+		`<script src="https://example.com/other.js"></script></head>`
+	if string(got) != want {
+		t.Errorf("inline() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHtmlAssetsInlineEmbedded(t *testing.T) {
+	// Every build ships the scripts, so that an inline page works offline
+	for _, name := range htmlAssetNames {
+		if info, err := fs.Stat(embeddedHtmlAssets, "assets/"+name); err != nil || info.Size() == 0 {
+			t.Errorf("html asset %s is not embedded: %v", name, err)
+		}
+	}
+	assets, err := NewHtmlAssets(HtmlAssetsInline, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := []byte(`<script src="` + htmlAssetsCdnHost + `echarts.min.js"></script>` +
+		`<script src="` + htmlAssetsCdnHost + `themes/westeros.js"></script>`)
+	got, err := assets.inline(page, map[string][]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(got), htmlAssetsCdnHost) {
+		t.Errorf("inline() still loads a script from the assets host")
+	}
+}
+
+func TestNewHtmlAssetsMissingDir(t *testing.T) {
+	if _, err := NewHtmlAssets(HtmlAssetsDir, ""); err == nil {
+		t.Error("NewHtmlAssets(dir, \"\") want an error")
+	}
+	if _, err := NewHtmlAssets(HtmlAssetsInline, t.TempDir()); err == nil {
+		t.Error("NewHtmlAssets() with an empty directory want an error")
+	}
+}
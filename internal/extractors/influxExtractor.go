@@ -0,0 +1,228 @@
+package extractors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type InfluxExtractorOptions struct {
+	// Destination is a file, an http(s):// write endpoint like
+	// http://localhost:8086/api/v2/write?org=org&bucket=bucket, or udp://host:port
+	Destination string
+	// Token authenticates to the http endpoint of InfluxDB 2
+	Token string
+	Run   RunMetadata
+}
+
+func NewInfluxExtractorOptions(destination, token string, run RunMetadata) InfluxExtractorOptions {
+	return InfluxExtractorOptions{Destination: destination, Token: token, Run: run}
+}
+
+// Influx streams every sample in the InfluxDB line protocol to a file, an http endpoint
+// or a udp endpoint. The sample of the whole tree is written to the "peekprof" measurement
+// and the sample of each process of the tree to the "peekprof_process" measurement.
+type Influx struct {
+	Destination string
+	run         RunMetadata
+	sender      *batchSender
+	file        *os.File
+}
+
+// influxUdpPayloadSize keeps each udp datagram within the usual MTU
+const influxUdpPayloadSize = 1400
+
+func NewInfluxExtractor(opts InfluxExtractorOptions) (*Influx, error) {
+	e := &Influx{Destination: opts.Destination, run: opts.Run}
+
+	var send func(batch []interface{}) error
+	var closeDestination func() error
+	switch {
+	case strings.HasPrefix(opts.Destination, "http://"), strings.HasPrefix(opts.Destination, "https://"):
+		client := &http.Client{Timeout: 10 * time.Second}
+		send = func(batch []interface{}) error {
+			return postInfluxLines(client, opts.Destination, opts.Token, batch)
+		}
+	case strings.HasPrefix(opts.Destination, "udp://"):
+		conn, err := net.Dial("udp", strings.TrimPrefix(opts.Destination, "udp://"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial influx udp endpoint: %w", err)
+		}
+		send = func(batch []interface{}) error {
+			return writeDatagrams(conn, batch, influxUdpPayloadSize)
+		}
+		closeDestination = conn.Close
+	default:
+		f, err := os.Create(strings.TrimPrefix(opts.Destination, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create influx file: %w", err)
+		}
+		e.file = f
+		send = func(batch []interface{}) error {
+			return writeLines(f, batch)
+		}
+		closeDestination = f.Close
+	}
+	e.sender = newBatchSender(defaultBatchSenderOptions("influx"), send, closeDestination)
+
+	return e, nil
+}
+
+func (e *Influx) Add(data ProcessStatsData) error {
+	for _, line := range influxLines(e.run, data) {
+		e.sender.Enqueue(line)
+	}
+	return nil
+}
+
+func (e *Influx) StopAndExtract() error {
+	// The file or the connection is closed by the sender, once it is done with it
+	sent, dropped, err := e.sender.Close()
+	if e.file != nil {
+		if err != nil {
+			return fmt.Errorf("failed to write influx file: %w", err)
+		}
+		fmt.Printf("influx line protocol has been written at %s\n", e.file.Name())
+		return nil
+	}
+	fmt.Printf("influx: sent %d lines to %s, dropped %d\n", sent, e.Destination, dropped)
+	if err != nil {
+		fmt.Printf("influx: %s\n", err)
+	}
+
+	return nil
+}
+
+// influxLines returns a line for the whole process tree and a line for each of its processes
+func influxLines(run RunMetadata, data ProcessStatsData) [][]byte {
+	ts := strconv.FormatInt(data.Timestamp.UnixNano(), 10)
+	mu := data.MemoryUsage
+	cu := data.CpuUsage
+
+	var b bytes.Buffer
+	b.WriteString("peekprof")
+	writeInfluxTags(&b, [][2]string{
+		{"host", run.Hostname},
+		{"name", run.Name},
+		{"pid", strconv.Itoa(int(run.Pid))},
+	})
+	fmt.Fprintf(&b, " rss_kb=%di,rss_swap_kb=%di,swap_kb=%di,virtual_kb=%di,pss_kb=%di,uss_kb=%di,cpu_percent=%s,user_cpu_percent=%s,system_cpu_percent=%s",
+		mu.Rss, mu.RssSwap, mu.RssSwap-mu.Rss, mu.Virtual, mu.Pss, mu.Uss,
+		formatFloat32(cu.Percentage), formatFloat32(cu.UserPercentage), formatFloat32(cu.SystemPercentage))
+	if len(data.Processes) > 0 {
+		var threads int64
+		for _, p := range data.Processes {
+			threads += p.Threads
+		}
+		fmt.Fprintf(&b, ",threads=%di,processes=%di", threads, len(data.Processes))
+	}
+	b.WriteString(" " + ts)
+	lines := [][]byte{b.Bytes()}
+
+	for _, p := range data.Processes {
+		var pb bytes.Buffer
+		pb.WriteString("peekprof_process")
+		writeInfluxTags(&pb, [][2]string{
+			{"host", run.Hostname},
+			{"name", p.Name},
+			{"pid", strconv.Itoa(int(p.Pid))},
+			{"run_pid", strconv.Itoa(int(run.Pid))},
+		})
+		fmt.Fprintf(&pb, " rss_kb=%di,virtual_kb=%di,pss_kb=%di,uss_kb=%di,cpu_percent=%s,threads=%di %s",
+			p.MemoryUsage.Rss, p.MemoryUsage.Virtual, p.MemoryUsage.Pss, p.MemoryUsage.Uss,
+			formatFloat32(p.CpuUsage.Percentage), p.Threads, ts)
+		lines = append(lines, pb.Bytes())
+	}
+
+	return lines
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// writeInfluxTags writes the tags with a non empty value
+func writeInfluxTags(b *bytes.Buffer, tags [][2]string) {
+	for _, t := range tags {
+		if t[1] == "" {
+			continue
+		}
+		fmt.Fprintf(b, ",%s=%s", influxTagEscaper.Replace(t[0]), influxTagEscaper.Replace(t[1]))
+	}
+}
+
+func formatFloat32(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 32)
+}
+
+func postInfluxLines(client *http.Client, url, token string, batch []interface{}) error {
+	var body bytes.Buffer
+	if err := writeLines(&body, batch); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return unexpectedStatusError(resp)
+	}
+	return nil
+}
+
+// unexpectedStatusError returns the status of the response with the start of its body
+func unexpectedStatusError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	msg = bytes.TrimSpace(msg)
+	if len(msg) == 0 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+}
+
+// writeLines writes each line of the batch followed by a newline
+func writeLines(w io.Writer, batch []interface{}) error {
+	var b bytes.Buffer
+	for _, line := range batch {
+		b.Write(line.([]byte))
+		b.WriteByte('\n')
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeDatagrams packs as many newline terminated lines as fit in a datagram of payloadSize,
+// a line longer than that is sent in a datagram of its own
+func writeDatagrams(conn net.Conn, batch []interface{}, payloadSize int) error {
+	var b bytes.Buffer
+	for _, item := range batch {
+		line := item.([]byte)
+		if b.Len() > 0 && b.Len()+len(line)+1 > payloadSize {
+			if _, err := conn.Write(b.Bytes()); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	_, err := conn.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,81 @@
+package extractors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type JsonExtractorOptions struct {
+	Filename string
+	Run      RunMetadata
+}
+
+func NewJsonExtractorOptions(filename string, run RunMetadata) JsonExtractorOptions {
+	return JsonExtractorOptions{Filename: filename, Run: run}
+}
+
+// Json extracts the whole run into a single json document,
+// with the run metadata, every sample and marker, the summary and the exit status
+type Json struct {
+	Filename   string
+	run        RunMetadata
+	samples    []jsonSample
+	markers    []jsonMarker
+	summary    *Summary
+	exitStatus *ExitStatusData
+}
+
+type jsonDocument struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	Run           jsonRun             `json:"run"`
+	Samples       []jsonSample        `json:"samples"`
+	Markers       []jsonMarker        `json:"markers,omitempty"`
+	Summary       *Summary            `json:"summary,omitempty"`
+	ExitStatus    *exitStatusJsonData `json:"exitStatus,omitempty"`
+}
+
+func NewJsonExtractor(opts JsonExtractorOptions) *Json {
+	return &Json{Filename: opts.Filename, run: opts.Run}
+}
+
+func (e *Json) Add(data ProcessStatsData) error {
+	e.samples = append(e.samples, newJsonSample(data))
+	return nil
+}
+
+func (e *Json) AddMarker(marker MarkerData) {
+	e.markers = append(e.markers, jsonMarker{Name: marker.Name, Timestamp: marker.Timestamp})
+}
+
+func (e *Json) SetSummary(summary Summary) {
+	e.summary = &summary
+}
+
+func (e *Json) SetExitStatus(status ExitStatusData) {
+	e.exitStatus = &status
+}
+
+func (e *Json) StopAndExtract() error {
+	samples := e.samples
+	if samples == nil {
+		samples = []jsonSample{}
+	}
+	b, err := json.MarshalIndent(jsonDocument{
+		SchemaVersion: JsonSchemaVersion,
+		Run:           newJsonRun(e.run),
+		Samples:       samples,
+		Markers:       e.markers,
+		Summary:       e.summary,
+		ExitStatus:    newExitStatusJsonData(e.exitStatus),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal json: %w", err)
+	}
+	if err := os.WriteFile(e.Filename, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write json: %w", err)
+	}
+	fmt.Printf("json has been written at %s\n", e.Filename)
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package extractors
+
+import (
+	"runtime"
+	"time"
+)
+
+// JsonSchemaVersion is the version of the json and ndjson formats.
+// Adding a field keeps the version, while renaming or removing one,
+// or changing what it means, increases it.
+const JsonSchemaVersion = 1
+
+type jsonRun struct {
+	Pid                    int32     `json:"pid"`
+	Name                   string    `json:"name"`
+	Cmdline                string    `json:"cmdline"`
+	Hostname               string    `json:"hostname"`
+	Os                     string    `json:"os"`
+	StartTime              time.Time `json:"startTime"`
+	RefreshIntervalSeconds float64   `json:"refreshIntervalSeconds"`
+}
+
+// jsonMemory is the memory usage in kilobytes
+type jsonMemory struct {
+	RssKb         int64 `json:"rssKb"`
+	RssSwapKb     int64 `json:"rssSwapKb"`
+	VirtualKb     int64 `json:"virtualKb"`
+	PssKb         int64 `json:"pssKb"`
+	UssKb         int64 `json:"ussKb"`
+	SharedCleanKb int64 `json:"sharedCleanKb"`
+	SharedDirtyKb int64 `json:"sharedDirtyKb"`
+	SwapPssKb     int64 `json:"swapPssKb"`
+}
+
+type jsonCpu struct {
+	Percent         float32 `json:"percent"`
+	UserPercent     float32 `json:"userPercent"`
+	SystemPercent   float32 `json:"systemPercent"`
+	LifetimePercent float32 `json:"lifetimePercent"`
+}
+
+type jsonProcess struct {
+	Pid     int32      `json:"pid"`
+	PPid    int32      `json:"ppid"`
+	Name    string     `json:"name"`
+	Cmdline string     `json:"cmdline"`
+	Threads int64      `json:"threads"`
+	Memory  jsonMemory `json:"memory"`
+	Cpu     jsonCpu    `json:"cpu"`
+}
+
+type jsonEvent struct {
+	Type      string    `json:"type"`
+	Pid       int32     `json:"pid"`
+	PPid      int32     `json:"ppid"`
+	Name      string    `json:"name"`
+	Cmdline   string    `json:"cmdline"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type jsonMarker struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type jsonSample struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Memory    jsonMemory    `json:"memory"`
+	Cpu       jsonCpu       `json:"cpu"`
+	Processes []jsonProcess `json:"processes,omitempty"`
+	Events    []jsonEvent   `json:"events,omitempty"`
+}
+
+func newJsonRun(m RunMetadata) jsonRun {
+	return jsonRun{
+		Pid:                    m.Pid,
+		Name:                   m.Name,
+		Cmdline:                m.Cmdline,
+		Hostname:               m.Hostname,
+		Os:                     runtime.GOOS,
+		StartTime:              m.StartTime,
+		RefreshIntervalSeconds: m.RefreshInterval.Seconds(),
+	}
+}
+
+func newJsonMemory(mu MemoryUsageData) jsonMemory {
+	return jsonMemory{
+		RssKb:         mu.Rss,
+		RssSwapKb:     mu.RssSwap,
+		VirtualKb:     mu.Virtual,
+		PssKb:         mu.Pss,
+		UssKb:         mu.Uss,
+		SharedCleanKb: mu.SharedClean,
+		SharedDirtyKb: mu.SharedDirty,
+		SwapPssKb:     mu.SwapPss,
+	}
+}
+
+func newJsonCpu(cu CpuUsageData) jsonCpu {
+	return jsonCpu{
+		Percent:         cu.Percentage,
+		UserPercent:     cu.UserPercentage,
+		SystemPercent:   cu.SystemPercentage,
+		LifetimePercent: cu.LifetimePercentage,
+	}
+}
+
+func newJsonSample(d ProcessStatsData) jsonSample {
+	s := jsonSample{
+		Timestamp: d.Timestamp,
+		Memory:    newJsonMemory(d.MemoryUsage),
+		Cpu:       newJsonCpu(d.CpuUsage),
+	}
+	for _, p := range d.Processes {
+		s.Processes = append(s.Processes, jsonProcess{
+			Pid:     p.Pid,
+			PPid:    p.PPid,
+			Name:    p.Name,
+			Cmdline: p.Cmdline,
+			Threads: p.Threads,
+			Memory:  newJsonMemory(p.MemoryUsage),
+			Cpu:     newJsonCpu(p.CpuUsage),
+		})
+	}
+	for _, e := range d.Events {
+		s.Events = append(s.Events, jsonEvent{
+			Type:      e.Type,
+			Pid:       e.Pid,
+			PPid:      e.PPid,
+			Name:      e.Name,
+			Cmdline:   e.Cmdline,
+			Timestamp: e.Timestamp,
+		})
+	}
+	return s
+}
+
+func (r jsonRun) toRunMetadata() RunMetadata {
+	return RunMetadata{
+		Pid:             r.Pid,
+		Name:            r.Name,
+		Cmdline:         r.Cmdline,
+		Hostname:        r.Hostname,
+		StartTime:       r.StartTime,
+		RefreshInterval: time.Duration(r.RefreshIntervalSeconds * float64(time.Second)),
+	}
+}
+
+func (m jsonMemory) toMemoryUsageData() MemoryUsageData {
+	return MemoryUsageData{
+		Rss:         m.RssKb,
+		RssSwap:     m.RssSwapKb,
+		Virtual:     m.VirtualKb,
+		Pss:         m.PssKb,
+		Uss:         m.UssKb,
+		SharedClean: m.SharedCleanKb,
+		SharedDirty: m.SharedDirtyKb,
+		SwapPss:     m.SwapPssKb,
+	}
+}
+
+func (c jsonCpu) toCpuUsageData() CpuUsageData {
+	return CpuUsageData{
+		Percentage:         c.Percent,
+		UserPercentage:     c.UserPercent,
+		SystemPercentage:   c.SystemPercent,
+		LifetimePercentage: c.LifetimePercent,
+	}
+}
+
+func (s jsonSample) toProcessStatsData() ProcessStatsData {
+	d := ProcessStatsData{
+		Timestamp:   s.Timestamp,
+		MemoryUsage: s.Memory.toMemoryUsageData(),
+		CpuUsage:    s.Cpu.toCpuUsageData(),
+	}
+	for _, p := range s.Processes {
+		d.Processes = append(d.Processes, ProcessData{
+			Pid:         p.Pid,
+			PPid:        p.PPid,
+			Name:        p.Name,
+			Cmdline:     p.Cmdline,
+			Threads:     p.Threads,
+			MemoryUsage: p.Memory.toMemoryUsageData(),
+			CpuUsage:    p.Cpu.toCpuUsageData(),
+		})
+	}
+	for _, e := range s.Events {
+		d.Events = append(d.Events, ProcessEventData{
+			Type:      e.Type,
+			Pid:       e.Pid,
+			PPid:      e.PPid,
+			Name:      e.Name,
+			Cmdline:   e.Cmdline,
+			Timestamp: e.Timestamp,
+		})
+	}
+	return d
+}
@@ -0,0 +1,212 @@
+package extractors
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// leakAnalysisMaxPoints bounds the points the regression runs on,
+// since it compares every pair of points
+const leakAnalysisMaxPoints = 2000
+
+// leakAnalysisMinSamples is the least points after the warm-up that a trend is fit on
+const leakAnalysisMinSamples = 10
+
+// leakMetrics are the series of a TimeSeries that the trends are fit over, by name
+var leakMetrics = map[string]TimeSeriesMetric{
+	"rss": TimeSeriesRss,
+	"pss": TimeSeriesPss,
+}
+
+type LeakAnalysisOptions struct {
+	// Warmup is ignored at the start of the run, while the process is still allocating what it needs
+	Warmup time.Duration
+	// ThresholdKbPerMinute is the growth rate above which memory is likely leaking
+	ThresholdKbPerMinute float64
+	// MinConfidence is the confidence the growth needs to be considered a leak, between 0 and 1
+	MinConfidence float64
+}
+
+func NewLeakAnalysisOptions(warmup time.Duration, thresholdKbPerMinute float64) LeakAnalysisOptions {
+	return LeakAnalysisOptions{
+		Warmup:               warmup,
+		ThresholdKbPerMinute: thresholdKbPerMinute,
+		MinConfidence:        0.95,
+	}
+}
+
+// Trend is a line fit over the memory of a run after the warm-up
+type Trend struct {
+	Metric  string    `json:"metric"`
+	Samples int       `json:"samples"`
+	From    time.Time `json:"from"`
+	// SlopeKbPerMinute is how fast the memory grows
+	SlopeKbPerMinute float64 `json:"slopeKbPerMinute"`
+	// InterceptKb is the memory at From
+	InterceptKb float64 `json:"interceptKb"`
+	// Confidence is how certain the memory is trending upwards, between 0 and 1
+	Confidence float64 `json:"confidence"`
+	LikelyLeak bool    `json:"likelyLeak"`
+}
+
+// ValueAt returns the memory in kilobytes that the trend predicts at t
+func (t Trend) ValueAt(at time.Time) float64 {
+	return t.InterceptKb + t.SlopeKbPerMinute*at.Sub(t.From).Minutes()
+}
+
+type LeakAnalysis struct {
+	WarmupSeconds        float64 `json:"warmupSeconds"`
+	ThresholdKbPerMinute float64 `json:"thresholdKbPerMinute"`
+	// Trends are empty if there were not enough samples after the warm-up
+	Trends     []Trend `json:"trends"`
+	LikelyLeak bool    `json:"likelyLeak"`
+}
+
+// Fields returns the trends as human readable name-value pairs
+func (l LeakAnalysis) Fields() [][2]string {
+	if len(l.Trends) == 0 {
+		return [][2]string{{"trend", fmt.Sprintf("not enough samples after the %s warm-up", time.Duration(l.WarmupSeconds*float64(time.Second)))}}
+	}
+	var fields [][2]string
+	for _, t := range l.Trends {
+		verdict := ""
+		if t.LikelyLeak {
+			verdict = ", likely leak"
+		}
+		fields = append(fields, [2]string{
+			t.Metric,
+			fmt.Sprintf("%+.1f KB/min (confidence %.1f%%%s)", t.SlopeKbPerMinute, t.Confidence*100, verdict),
+		})
+	}
+	return fields
+}
+
+// analyzeLeak fits a trend over each memory series after the warm-up.
+// The slope is the Theil-Sen estimator, the median of the slopes between every pair of points,
+// so that spikes and garbage collection drops do not skew it, and the confidence comes from
+// the Mann-Kendall test of whether the series is trending upwards at all.
+// The points are the samples of the run, or the buckets they were merged into,
+// each at the middle of its samples with their average.
+func analyzeLeak(opts LeakAnalysisOptions, points []TimeSeriesPoint, metrics []string) LeakAnalysis {
+	analysis := LeakAnalysis{
+		WarmupSeconds:        opts.Warmup.Seconds(),
+		ThresholdKbPerMinute: opts.ThresholdKbPerMinute,
+	}
+	if len(points) == 0 {
+		return analysis
+	}
+
+	start := points[0].From.Add(opts.Warmup)
+	first := sort.Search(len(points), func(i int) bool { return !points[i].From.Before(start) })
+	if len(points)-first < leakAnalysisMinSamples {
+		return analysis
+	}
+	samples := 0
+	for _, p := range points[first:] {
+		samples += p.Count
+	}
+	indexes := evenlySpacedIndexes(first, len(points), leakAnalysisMaxPoints)
+	middle := func(p TimeSeriesPoint) time.Time { return p.From.Add(p.To.Sub(p.From) / 2) }
+	from := middle(points[first])
+
+	x := make([]float64, len(indexes))
+	for i, idx := range indexes {
+		x[i] = middle(points[idx]).Sub(from).Minutes()
+	}
+
+	for _, metric := range metrics {
+		y := make([]float64, len(indexes))
+		for i, idx := range indexes {
+			y[i] = points[idx].Avg(leakMetrics[metric])
+		}
+		slope, intercept := theilSen(x, y)
+		t := Trend{
+			Metric:           metric,
+			Samples:          samples,
+			From:             from,
+			SlopeKbPerMinute: slope,
+			InterceptKb:      intercept,
+			Confidence:       mannKendallConfidence(y),
+		}
+		t.LikelyLeak = t.SlopeKbPerMinute > opts.ThresholdKbPerMinute && t.Confidence >= opts.MinConfidence
+		analysis.LikelyLeak = analysis.LikelyLeak || t.LikelyLeak
+		analysis.Trends = append(analysis.Trends, t)
+	}
+
+	return analysis
+}
+
+// evenlySpacedIndexes returns at most limit indexes in [from, to), evenly spaced
+func evenlySpacedIndexes(from, to, limit int) []int {
+	n := to - from
+	if n > limit {
+		n = limit
+	}
+	indexes := make([]int, n)
+	for i := range indexes {
+		if n == 1 {
+			indexes[i] = from
+			continue
+		}
+		indexes[i] = from + int(math.Round(float64(i)*float64(to-from-1)/float64(n-1)))
+	}
+	return indexes
+}
+
+func theilSen(x, y []float64) (slope, intercept float64) {
+	slopes := make([]float64, 0, len(x)*(len(x)-1)/2)
+	for i := 0; i < len(x); i++ {
+		for j := i + 1; j < len(x); j++ {
+			if x[j] == x[i] {
+				continue
+			}
+			slopes = append(slopes, (y[j]-y[i])/(x[j]-x[i]))
+		}
+	}
+	if len(slopes) == 0 {
+		return 0, median(y)
+	}
+	slope = median(slopes)
+
+	residuals := make([]float64, len(x))
+	for i := range x {
+		residuals[i] = y[i] - slope*x[i]
+	}
+	return slope, median(residuals)
+}
+
+// mannKendallConfidence returns one minus the p-value of the one-sided Mann-Kendall test
+// for an upward trend, which is how confident it is that the series is growing
+func mannKendallConfidence(y []float64) float64 {
+	n := float64(len(y))
+	var s float64
+	for i := 0; i < len(y); i++ {
+		for j := i + 1; j < len(y); j++ {
+			switch {
+			case y[j] > y[i]:
+				s++
+			case y[j] < y[i]:
+				s--
+			}
+		}
+	}
+	variance := n * (n - 1) * (2*n + 5) / 18
+	if variance == 0 || s <= 0 {
+		return 0
+	}
+	z := (s - 1) / math.Sqrt(variance)
+	pValue := 0.5 * math.Erfc(z/math.Sqrt2)
+	return 1 - pValue
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return percentile(sorted, 50)
+}
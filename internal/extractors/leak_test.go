@@ -0,0 +1,72 @@
+package extractors
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTheilSen(t *testing.T) {
+	tests := []struct {
+		name          string
+		x, y          []float64
+		wantSlope     float64
+		wantIntercept float64
+	}{
+		{name: "line", x: []float64{0, 1, 2, 3, 4}, y: []float64{10, 12, 14, 16, 18}, wantSlope: 2, wantIntercept: 10},
+		{name: "flat", x: []float64{0, 1, 2, 3}, y: []float64{5, 5, 5, 5}, wantSlope: 0, wantIntercept: 5},
+		{name: "falling", x: []float64{0, 10, 20}, y: []float64{300, 200, 100}, wantSlope: -10, wantIntercept: 300},
+		{
+			name:      "outliers are ignored",
+			x:         []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+			y:         []float64{0, 1, 2, 3, 1000, 5, 6, -1000, 8, 9},
+			wantSlope: 1, wantIntercept: 0,
+		},
+		{name: "same x", x: []float64{1, 1, 1}, y: []float64{3, 1, 2}, wantSlope: 0, wantIntercept: 2},
+		{name: "single point", x: []float64{4}, y: []float64{7}, wantSlope: 0, wantIntercept: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slope, intercept := theilSen(tt.x, tt.y)
+			if math.Abs(slope-tt.wantSlope) > 1e-9 || math.Abs(intercept-tt.wantIntercept) > 1e-9 {
+				t.Errorf("theilSen() = %v, %v, want %v, %v", slope, intercept, tt.wantSlope, tt.wantIntercept)
+			}
+		})
+	}
+}
+
+func TestMannKendallConfidence(t *testing.T) {
+	rising := make([]float64, 50)
+	falling := make([]float64, 50)
+	noisy := make([]float64, 50)
+	for i := range rising {
+		rising[i] = float64(i)
+		falling[i] = float64(-i)
+		// Alternates around a constant, with no trend
+		noisy[i] = float64(i%2) * 10
+	}
+
+	tests := []struct {
+		name     string
+		y        []float64
+		min, max float64
+	}{
+		{name: "rising", y: rising, min: 0.999, max: 1},
+		{name: "falling", y: falling, min: 0, max: 0},
+		{name: "flat", y: []float64{3, 3, 3, 3, 3}, min: 0, max: 0},
+		{name: "no trend", y: noisy, min: 0, max: 0.9},
+		{name: "empty", y: nil, min: 0, max: 0},
+		{name: "single", y: []float64{1}, min: 0, max: 0},
+		// s = 3 of the 3 pairs, variance = 3*2*11/18, z = 2/sqrt(11/3)
+		{name: "three rising", y: []float64{1, 2, 3}, min: 0.8518, max: 0.8519},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mannKendallConfidence(tt.y)
+			if got < tt.min || got > tt.max {
+				t.Errorf("mannKendallConfidence() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package extractors
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsFormat is the text format in which metrics are written
+type MetricsFormat string
+
+const (
+	MetricsFormatPrometheus  MetricsFormat = "prometheus"
+	MetricsFormatOpenMetrics MetricsFormat = "openmetrics"
+)
+
+func ParseMetricsFormat(s string) (MetricsFormat, error) {
+	f := MetricsFormat(strings.ToLower(s))
+	switch f {
+	case MetricsFormatPrometheus, MetricsFormatOpenMetrics:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown metrics format %q", s)
+	}
+}
+
+// MetricsCollector keeps the latest sample of a run, and the counters accumulated over it,
+// to expose them in the Prometheus text exposition format.
+// It is safe to write the metrics while samples are added.
+type MetricsCollector struct {
+	run RunMetadata
+	// labels are added to every series
+	labels [][2]string
+
+	mu         sync.Mutex
+	latest     *ProcessStatsData
+	samples    int64
+	cpuSeconds float64
+}
+
+// metricFamily is a metric and all of its series
+type metricFamily struct {
+	Name string
+	Help string
+	// Type is either "gauge" or "counter"
+	Type    string
+	Samples []metricSample
+}
+
+type metricSample struct {
+	Labels [][2]string
+	Value  float64
+}
+
+// NewMetricsCollector creates a collector whose series all have the given labels,
+// on top of their own
+func NewMetricsCollector(run RunMetadata, labels [][2]string) *MetricsCollector {
+	return &MetricsCollector{run: run, labels: labels}
+}
+
+func (c *MetricsCollector) Add(data ProcessStatsData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The interval cpu usage is the utilisation since the previous sample
+	dt := c.run.RefreshInterval
+	if c.latest != nil {
+		dt = data.Timestamp.Sub(c.latest.Timestamp)
+	}
+	c.cpuSeconds += float64(data.CpuUsage.Percentage) / 100 * dt.Seconds()
+	c.samples++
+	c.latest = &data
+
+	return nil
+}
+
+// WriteMetrics writes the latest sample as gauges and the counters of the run
+// in the Prometheus text exposition format
+func (c *MetricsCollector) WriteMetrics(w io.Writer) error {
+	return writeMetricFamilies(w, MetricsFormatPrometheus, c.labels, c.families(true))
+}
+
+// families returns the metrics of the latest sample and the counters of the run.
+// The series of each process of the tree are included only if perProcess is set.
+func (c *MetricsCollector) families(perProcess bool) []metricFamily {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	families := []metricFamily{
+		{
+			Name: "peekprof_run_info",
+			Help: "The profiled process, always 1.",
+			Type: "gauge",
+			Samples: []metricSample{{
+				Labels: [][2]string{
+					{"pid", strconv.Itoa(int(c.run.Pid))},
+					{"name", c.run.Name},
+					{"cmdline", c.run.Cmdline},
+				},
+				Value: 1,
+			}},
+		},
+		singleMetric("peekprof_samples_total", "The number of samples taken.", "counter", float64(c.samples)),
+		singleMetric("peekprof_cpu_seconds_total", "The cpu time of the process tree.", "counter", c.cpuSeconds),
+	}
+	if c.latest == nil {
+		return families
+	}
+
+	mu := c.latest.MemoryUsage
+	families = append(families,
+		singleMetric("peekprof_rss_bytes", "The resident set size of the process tree.", "gauge", float64(mu.Rss*1024)),
+		singleMetric("peekprof_pss_bytes", "The proportional set size of the process tree.", "gauge", float64(mu.Pss*1024)),
+		singleMetric("peekprof_uss_bytes", "The unique set size of the process tree.", "gauge", float64(mu.Uss*1024)),
+		singleMetric("peekprof_virtual_bytes", "The virtual memory size of the process tree.", "gauge", float64(mu.Virtual*1024)),
+		singleMetric("peekprof_swap_bytes", "The swapped out memory of the process tree.", "gauge", float64((mu.RssSwap-mu.Rss)*1024)),
+		singleMetric("peekprof_cpu_percent", "The cpu utilisation of the process tree since the previous sample.", "gauge", float64(c.latest.CpuUsage.Percentage)),
+		singleMetric("peekprof_last_sample_timestamp_seconds", "The time the latest sample was taken.", "gauge", float64(c.latest.Timestamp.UnixNano())/float64(time.Second)),
+	)
+	if len(c.latest.Processes) == 0 || !perProcess {
+		return families
+	}
+
+	var threads int64
+	processRss := metricFamily{Name: "peekprof_process_rss_bytes", Help: "The resident set size of each process of the tree.", Type: "gauge"}
+	processPss := metricFamily{Name: "peekprof_process_pss_bytes", Help: "The proportional set size of each process of the tree.", Type: "gauge"}
+	processVirtual := metricFamily{Name: "peekprof_process_virtual_bytes", Help: "The virtual memory size of each process of the tree.", Type: "gauge"}
+	processCpu := metricFamily{Name: "peekprof_process_cpu_percent", Help: "The cpu utilisation of each process of the tree since the previous sample.", Type: "gauge"}
+	processThreads := metricFamily{Name: "peekprof_process_threads", Help: "The number of threads of each process of the tree.", Type: "gauge"}
+	for _, p := range c.latest.Processes {
+		threads += p.Threads
+		labels := [][2]string{{"pid", strconv.Itoa(int(p.Pid))}, {"name", p.Name}}
+		processRss.Samples = append(processRss.Samples, metricSample{labels, float64(p.MemoryUsage.Rss * 1024)})
+		processPss.Samples = append(processPss.Samples, metricSample{labels, float64(p.MemoryUsage.Pss * 1024)})
+		processVirtual.Samples = append(processVirtual.Samples, metricSample{labels, float64(p.MemoryUsage.Virtual * 1024)})
+		processCpu.Samples = append(processCpu.Samples, metricSample{labels, float64(p.CpuUsage.Percentage)})
+		processThreads.Samples = append(processThreads.Samples, metricSample{labels, float64(p.Threads)})
+	}
+	families = append(families,
+		singleMetric("peekprof_threads", "The number of threads of the process tree.", "gauge", float64(threads)),
+		singleMetric("peekprof_processes", "The number of processes of the tree.", "gauge", float64(len(c.latest.Processes))),
+		processRss, processPss, processVirtual, processCpu, processThreads,
+	)
+
+	return families
+}
+
+func singleMetric(name, help, metricType string, value float64) metricFamily {
+	return metricFamily{Name: name, Help: help, Type: metricType, Samples: []metricSample{{Value: value}}}
+}
+
+// writeMetricFamilies writes the metric families in the Prometheus text exposition
+// or the OpenMetrics format, adding the constant labels to every series.
+// A label of a series with the same name as a constant label is left out.
+func writeMetricFamilies(w io.Writer, format MetricsFormat, constLabels [][2]string, families []metricFamily) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		// In OpenMetrics the counter family is named without the _total suffix of its samples
+		familyName := f.Name
+		if format == MetricsFormatOpenMetrics && f.Type == "counter" {
+			familyName = strings.TrimSuffix(f.Name, "_total")
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", familyName, f.Help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", familyName, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			writeMetricLabels(bw, constLabels, s.Labels)
+			fmt.Fprintf(bw, " %s\n", formatMetricValue(s.Value))
+		}
+	}
+	if format == MetricsFormatOpenMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func writeMetricLabels(w *bufio.Writer, constLabels, labels [][2]string) {
+	all := append([][2]string{}, constLabels...)
+	for _, l := range labels {
+		if !hasMetricLabel(constLabels, l[0]) {
+			all = append(all, l)
+		}
+	}
+	if len(all) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i, l := range all {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "%s=\"%s\"", l[0], escapeLabelValue(l[1]))
+	}
+	w.WriteByte('}')
+}
+
+func hasMetricLabel(labels [][2]string, name string) bool {
+	for _, l := range labels {
+		if l[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidMetricLabelName reports whether name can be used as a label name in Prometheus
+func IsValidMetricLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
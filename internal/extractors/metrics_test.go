@@ -0,0 +1,128 @@
+package extractors
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricFamilies(t *testing.T) {
+	families := []metricFamily{
+		singleMetric("peekprof_samples_total", "The number of samples taken.", "counter", 3),
+		{
+			Name: "peekprof_process_rss_bytes",
+			Help: "The resident set size of each process of the tree.",
+			Type: "gauge",
+			Samples: []metricSample{
+				{Labels: [][2]string{{"pid", "1"}, {"name", `say "hi"\` + "\n"}}, Value: 1048576},
+				{Labels: [][2]string{{"pid", "2"}, {"job", "ignored"}}, Value: 0.5},
+			},
+		},
+		singleMetric("peekprof_cpu_percent", "The cpu utilisation.", "gauge", math.NaN()),
+	}
+	constLabels := [][2]string{{"job", "ci"}}
+
+	tests := []struct {
+		name   string
+		format MetricsFormat
+		want   string
+	}{
+		{
+			name:   "prometheus",
+			format: MetricsFormatPrometheus,
+			want: `# HELP peekprof_samples_total The number of samples taken.
+# TYPE peekprof_samples_total counter
+peekprof_samples_total{job="ci"} 3
+# HELP peekprof_process_rss_bytes The resident set size of each process of the tree.
+# TYPE peekprof_process_rss_bytes gauge
+peekprof_process_rss_bytes{job="ci",pid="1",name="say \"hi\"\\\n"} 1.048576e+06
+peekprof_process_rss_bytes{job="ci",pid="2"} 0.5
+# HELP peekprof_cpu_percent The cpu utilisation.
+# TYPE peekprof_cpu_percent gauge
+peekprof_cpu_percent{job="ci"} NaN
+`,
+		},
+		{
+			name:   "openmetrics",
+			format: MetricsFormatOpenMetrics,
+			want: `# HELP peekprof_samples The number of samples taken.
+# TYPE peekprof_samples counter
+peekprof_samples_total{job="ci"} 3
+# HELP peekprof_process_rss_bytes The resident set size of each process of the tree.
+# TYPE peekprof_process_rss_bytes gauge
+peekprof_process_rss_bytes{job="ci",pid="1",name="say \"hi\"\\\n"} 1.048576e+06
+peekprof_process_rss_bytes{job="ci",pid="2"} 0.5
+# HELP peekprof_cpu_percent The cpu utilisation.
+# TYPE peekprof_cpu_percent gauge
+peekprof_cpu_percent{job="ci"} NaN
+# EOF
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeMetricFamilies(&b, tt.format, constLabels, families); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("writeMetricFamilies() =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsCollector(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewMetricsCollector(RunMetadata{Pid: 42, Name: "app", Cmdline: "app -v", RefreshInterval: time.Second}, nil)
+
+	var b bytes.Buffer
+	if err := c.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "peekprof_rss_bytes") {
+		t.Errorf("WriteMetrics() without samples has gauges:\n%s", b.String())
+	}
+
+	for i := 0; i < 3; i++ {
+		c.Add(ProcessStatsData{
+			Timestamp:   start.Add(time.Duration(i) * 2 * time.Second),
+			MemoryUsage: MemoryUsageData{Rss: 1024, RssSwap: 1536},
+			CpuUsage:    CpuUsageData{Percentage: 50},
+			Processes:   []ProcessData{{Pid: 42, Name: "app", Threads: 3, MemoryUsage: MemoryUsageData{Rss: 1024}}},
+		})
+	}
+	b.Reset()
+	if err := c.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`peekprof_run_info{pid="42",name="app",cmdline="app -v"} 1`,
+		`peekprof_samples_total 3`,
+		// 1s at 50% for the first sample, then 2s at 50% for each of the others
+		`peekprof_cpu_seconds_total 2.5`,
+		`peekprof_rss_bytes 1.048576e+06`,
+		`peekprof_swap_bytes 524288`,
+		`peekprof_threads 3`,
+		`peekprof_processes 1`,
+		`peekprof_process_rss_bytes{pid="42",name="app"} 1.048576e+06`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("WriteMetrics() has no line %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestIsValidMetricLabelName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"job": true, "_job": true, "job_2": true, "Env": true,
+		"": false, "2job": false, "__name__": false, "job-name": false, "jöb": false,
+	} {
+		if got := IsValidMetricLabelName(name); got != want {
+			t.Errorf("IsValidMetricLabelName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package extractors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+type NdjsonExtractorOptions struct {
+	Filename string
+	Run      RunMetadata
+}
+
+func NewNdjsonExtractorOptions(filename string, run RunMetadata) NdjsonExtractorOptions {
+	return NdjsonExtractorOptions{Filename: filename, Run: run}
+}
+
+// Ndjson extracts the run as newline delimited json, one object per line,
+// so that the file can be tailed while the process is profiled.
+// The first line is the run metadata, followed by a line per sample or marker,
+// and a last line with the summary and the exit status.
+// Every line has a "type" of "run", "sample", "marker" or "end".
+type Ndjson struct {
+	Filename string
+	// format is what the file is called in messages
+	format  string
+	encoder *json.Encoder
+	// flusher, if it is set, is flushed after every line, for a writer that buffers
+	flusher interface{ Flush() error }
+	// closers are closed in order after the last line
+	closers    []io.Closer
+	summary    *Summary
+	exitStatus *ExitStatusData
+}
+
+type ndjsonRunLine struct {
+	Type          string  `json:"type"`
+	SchemaVersion int     `json:"schemaVersion"`
+	Run           jsonRun `json:"run"`
+}
+
+type ndjsonSampleLine struct {
+	Type string `json:"type"`
+	jsonSample
+}
+
+type ndjsonMarkerLine struct {
+	Type string `json:"type"`
+	jsonMarker
+}
+
+type ndjsonEndLine struct {
+	Type       string              `json:"type"`
+	Summary    *Summary            `json:"summary,omitempty"`
+	ExitStatus *exitStatusJsonData `json:"exitStatus,omitempty"`
+}
+
+func NewNdjsonExtractor(opts NdjsonExtractorOptions) (*Ndjson, error) {
+	f, err := os.Create(opts.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ndjson file: %w", err)
+	}
+
+	return newNdjson(opts.Filename, "ndjson", f, nil, []io.Closer{f}, opts.Run)
+}
+
+// newNdjson writes the run metadata to w, and the rest of the lines as they are added
+func newNdjson(filename, format string, w io.Writer, flusher interface{ Flush() error }, closers []io.Closer, run RunMetadata) (*Ndjson, error) {
+	e := &Ndjson{Filename: filename, format: format, encoder: json.NewEncoder(w), flusher: flusher, closers: closers}
+	err := e.writeLine(ndjsonRunLine{
+		Type:          "run",
+		SchemaVersion: JsonSchemaVersion,
+		Run:           newJsonRun(run),
+	})
+	if err != nil {
+		for _, c := range closers {
+			c.Close()
+		}
+		return nil, fmt.Errorf("failed to write run metadata: %w", err)
+	}
+
+	return e, nil
+}
+
+// writeLine writes a line straight to the file, the encoder does not buffer
+// and a writer that does is flushed, so every line is visible to readers as soon as it is added
+func (e *Ndjson) writeLine(line interface{}) error {
+	if err := e.encoder.Encode(line); err != nil {
+		return err
+	}
+	if e.flusher != nil {
+		return e.flusher.Flush()
+	}
+	return nil
+}
+
+func (e *Ndjson) Add(data ProcessStatsData) error {
+	err := e.writeLine(ndjsonSampleLine{Type: "sample", jsonSample: newJsonSample(data)})
+	if err != nil {
+		return fmt.Errorf("failed to write %s sample: %w", e.format, err)
+	}
+	return nil
+}
+
+func (e *Ndjson) AddMarker(marker MarkerData) {
+	err := e.writeLine(ndjsonMarkerLine{
+		Type:       "marker",
+		jsonMarker: jsonMarker{Name: marker.Name, Timestamp: marker.Timestamp},
+	})
+	if err != nil {
+		fmt.Printf("failed to write %s marker: %s\n", e.format, err)
+	}
+}
+
+func (e *Ndjson) SetSummary(summary Summary) {
+	e.summary = &summary
+}
+
+func (e *Ndjson) SetExitStatus(status ExitStatusData) {
+	e.exitStatus = &status
+}
+
+func (e *Ndjson) StopAndExtract() error {
+	err := e.writeLine(ndjsonEndLine{
+		Type:       "end",
+		Summary:    e.summary,
+		ExitStatus: newExitStatusJsonData(e.exitStatus),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s end: %w", e.format, err)
+	}
+	for _, c := range e.closers {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("failed to close %s file: %w", e.format, err)
+		}
+	}
+	fmt.Printf("%s has been written at %s\n", e.format, e.Filename)
+
+	return nil
+}
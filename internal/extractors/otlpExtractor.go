@@ -0,0 +1,494 @@
+package extractors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OtlpEncoding is how OTLP payloads are encoded
+type OtlpEncoding string
+
+const (
+	OtlpEncodingProtobuf OtlpEncoding = "protobuf"
+	OtlpEncodingJson     OtlpEncoding = "json"
+)
+
+func ParseOtlpEncoding(s string) (OtlpEncoding, error) {
+	switch e := OtlpEncoding(s); e {
+	case OtlpEncodingProtobuf, OtlpEncodingJson:
+		return e, nil
+	default:
+		return "", fmt.Errorf("unknown otlp encoding %q", s)
+	}
+}
+
+type OtlpExtractorOptions struct {
+	// Endpoint is the OTLP/HTTP metrics endpoint, /v1/metrics is added if it has no path
+	Endpoint string
+	Encoding OtlpEncoding
+	// Headers are added to every request, e.g. for authentication
+	Headers [][2]string
+	Run     RunMetadata
+}
+
+func NewOtlpExtractorOptions(endpoint string, encoding OtlpEncoding, headers [][2]string, run RunMetadata) OtlpExtractorOptions {
+	return OtlpExtractorOptions{Endpoint: endpoint, Encoding: encoding, Headers: headers, Run: run}
+}
+
+// Otlp exports the samples as OpenTelemetry metrics to an OTLP/HTTP endpoint,
+// named after the process semantic conventions. Each process of the tree is a resource of its own,
+// identified by its process.pid and process.command_line attributes.
+type Otlp struct {
+	Endpoint string
+	encoding OtlpEncoding
+	headers  [][2]string
+	run      RunMetadata
+	client   *http.Client
+	sender   *batchSender
+
+	// cpuTimes are the cumulative cpu times of each process, since it was first seen
+	cpuTimes map[int32]*otlpCpuTime
+}
+
+type otlpCpuTime struct {
+	Start         time.Time
+	Last          time.Time
+	UserSeconds   float64
+	SystemSeconds float64
+}
+
+// otlpSample is a sample of every process of the tree, ready to be encoded
+type otlpSample struct {
+	Processes []otlpProcessSample
+}
+
+type otlpProcessSample struct {
+	Time    time.Time
+	Pid     int32
+	PPid    int32
+	Name    string
+	Cmdline string
+	// Start is when the process was first seen, the start of its cumulative metrics
+	Start             time.Time
+	RssBytes          int64
+	VirtualBytes      int64
+	UserUtilization   float64
+	SystemUtilization float64
+	UserSeconds       float64
+	SystemSeconds     float64
+	Threads           int64
+	HasThreads        bool
+}
+
+func NewOtlpExtractor(opts OtlpExtractorOptions) (*Otlp, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q", opts.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	if opts.Encoding == "" {
+		opts.Encoding = OtlpEncodingProtobuf
+	}
+
+	e := &Otlp{
+		Endpoint: u.String(),
+		encoding: opts.Encoding,
+		headers:  opts.Headers,
+		run:      opts.Run,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		cpuTimes: map[int32]*otlpCpuTime{},
+	}
+	senderOpts := defaultBatchSenderOptions("otlp")
+	senderOpts.BatchSize = 100
+	senderOpts.FlushInterval = 5 * time.Second
+	e.sender = newBatchSender(senderOpts, e.send, nil)
+
+	return e, nil
+}
+
+func (e *Otlp) Add(data ProcessStatsData) error {
+	processes := data.Processes
+	if len(processes) == 0 {
+		// The process tree is not known, the whole tree is reported as the process itself
+		processes = []ProcessData{{
+			Pid:         e.run.Pid,
+			Name:        e.run.Name,
+			Cmdline:     e.run.Cmdline,
+			MemoryUsage: data.MemoryUsage,
+			CpuUsage:    data.CpuUsage,
+		}}
+	}
+
+	// Utilization is relative to all the cpus, while the percentages are relative to a single cpu
+	cpus := float64(runtime.NumCPU())
+	var sample otlpSample
+	seen := map[int32]bool{}
+	for _, p := range processes {
+		seen[p.Pid] = true
+		ct, ok := e.cpuTimes[p.Pid]
+		if !ok {
+			ct = &otlpCpuTime{Start: data.Timestamp, Last: data.Timestamp}
+			e.cpuTimes[p.Pid] = ct
+		}
+		dt := data.Timestamp.Sub(ct.Last).Seconds()
+		ct.UserSeconds += float64(p.CpuUsage.UserPercentage) / 100 * dt
+		ct.SystemSeconds += float64(p.CpuUsage.SystemPercentage) / 100 * dt
+		ct.Last = data.Timestamp
+
+		sample.Processes = append(sample.Processes, otlpProcessSample{
+			Time:              data.Timestamp,
+			Pid:               p.Pid,
+			PPid:              p.PPid,
+			Name:              p.Name,
+			Cmdline:           p.Cmdline,
+			Start:             ct.Start,
+			RssBytes:          p.MemoryUsage.Rss * 1024,
+			VirtualBytes:      p.MemoryUsage.Virtual * 1024,
+			UserUtilization:   float64(p.CpuUsage.UserPercentage) / 100 / cpus,
+			SystemUtilization: float64(p.CpuUsage.SystemPercentage) / 100 / cpus,
+			UserSeconds:       ct.UserSeconds,
+			SystemSeconds:     ct.SystemSeconds,
+			Threads:           p.Threads,
+			HasThreads:        len(data.Processes) > 0,
+		})
+	}
+	for pid := range e.cpuTimes {
+		if !seen[pid] {
+			delete(e.cpuTimes, pid)
+		}
+	}
+
+	e.sender.Enqueue(sample)
+	return nil
+}
+
+func (e *Otlp) StopAndExtract() error {
+	sent, dropped, err := e.sender.Close()
+	fmt.Printf("otlp: sent %d samples to %s, dropped %d\n", sent, e.Endpoint, dropped)
+	if err != nil {
+		fmt.Printf("otlp: %s\n", err)
+	}
+
+	return nil
+}
+
+func (e *Otlp) send(batch []interface{}) error {
+	samples := make([]otlpSample, len(batch))
+	for i, item := range batch {
+		samples[i] = item.(otlpSample)
+	}
+	resources := e.groupByResource(samples)
+
+	var body []byte
+	contentType := "application/x-protobuf"
+	if e.encoding == OtlpEncodingJson {
+		contentType = "application/json"
+		var err error
+		if body, err = json.Marshal(e.jsonRequest(resources)); err != nil {
+			return permanentError{fmt.Errorf("failed to marshal otlp request: %w", err)}
+		}
+	} else {
+		body = e.protobufRequest(resources)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for _, h := range e.headers {
+		req.Header.Set(h[0], h[1])
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	err = unexpectedStatusError(resp)
+	// Only throttling and unavailability are worth retrying, as the OTLP specification says
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return err
+	default:
+		return permanentError{err}
+	}
+}
+
+// otlpResource is a process with all of its samples in a batch
+type otlpResource struct {
+	Attributes []otlpAttribute
+	Samples    []otlpProcessSample
+}
+
+// otlpAttribute is a string or an int attribute
+type otlpAttribute struct {
+	Key         string
+	StringValue string
+	IntValue    int64
+	IsInt       bool
+}
+
+// otlpMetric is a gauge, or a sum with the cumulative temporality
+type otlpMetric struct {
+	Name        string
+	Description string
+	Unit        string
+	Sum         bool
+	Monotonic   bool
+	Points      []otlpPoint
+}
+
+type otlpPoint struct {
+	Attributes []otlpAttribute
+	Start      time.Time
+	Time       time.Time
+	IntValue   int64
+	Double     float64
+	IsDouble   bool
+}
+
+// otlpAggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE
+const otlpAggregationTemporalityCumulative = 2
+
+func (e *Otlp) groupByResource(samples []otlpSample) []otlpResource {
+	byKey := map[string]*otlpResource{}
+	var keys []string
+	for _, s := range samples {
+		for _, p := range s.Processes {
+			// A reused pid is another process, with another command line
+			key := strconv.Itoa(int(p.Pid)) + " " + p.Cmdline
+			r, ok := byKey[key]
+			if !ok {
+				r = &otlpResource{Attributes: e.resourceAttributes(p)}
+				byKey[key] = r
+				keys = append(keys, key)
+			}
+			r.Samples = append(r.Samples, p)
+		}
+	}
+	sort.Strings(keys)
+
+	resources := make([]otlpResource, 0, len(keys))
+	for _, k := range keys {
+		resources = append(resources, *byKey[k])
+	}
+	return resources
+}
+
+func (e *Otlp) resourceAttributes(p otlpProcessSample) []otlpAttribute {
+	attrs := []otlpAttribute{
+		{Key: "service.name", StringValue: "unknown_service:" + p.Name},
+		{Key: "host.name", StringValue: e.run.Hostname},
+		{Key: "process.pid", IntValue: int64(p.Pid), IsInt: true},
+		{Key: "process.executable.name", StringValue: p.Name},
+		{Key: "process.command_line", StringValue: p.Cmdline},
+	}
+	if p.PPid > 0 {
+		attrs = append(attrs, otlpAttribute{Key: "process.parent_pid", IntValue: int64(p.PPid), IsInt: true})
+	}
+	return attrs
+}
+
+// metrics returns the metrics of the resource, following the process semantic conventions
+func (r otlpResource) metrics() []otlpMetric {
+	memory := otlpMetric{Name: "process.memory.usage", Description: "The amount of physical memory in use.", Unit: "By", Sum: true}
+	virtual := otlpMetric{Name: "process.memory.virtual", Description: "The amount of committed virtual memory.", Unit: "By", Sum: true}
+	utilization := otlpMetric{Name: "process.cpu.utilization", Description: "Difference in process.cpu.time since the last measurement, divided by the elapsed time and number of CPUs available to the process.", Unit: "1"}
+	cpuTime := otlpMetric{Name: "process.cpu.time", Description: "Total CPU seconds broken down by different CPU modes.", Unit: "s", Sum: true, Monotonic: true}
+	threads := otlpMetric{Name: "process.threads", Description: "Process threads count.", Unit: "{thread}", Sum: true}
+
+	user := []otlpAttribute{{Key: "cpu.mode", StringValue: "user"}}
+	system := []otlpAttribute{{Key: "cpu.mode", StringValue: "system"}}
+	for _, s := range r.Samples {
+		memory.Points = append(memory.Points, otlpPoint{Start: s.Start, Time: s.Time, IntValue: s.RssBytes})
+		virtual.Points = append(virtual.Points, otlpPoint{Start: s.Start, Time: s.Time, IntValue: s.VirtualBytes})
+		utilization.Points = append(utilization.Points,
+			otlpPoint{Attributes: user, Time: s.Time, Double: s.UserUtilization, IsDouble: true},
+			otlpPoint{Attributes: system, Time: s.Time, Double: s.SystemUtilization, IsDouble: true},
+		)
+		cpuTime.Points = append(cpuTime.Points,
+			otlpPoint{Attributes: user, Start: s.Start, Time: s.Time, Double: s.UserSeconds, IsDouble: true},
+			otlpPoint{Attributes: system, Start: s.Start, Time: s.Time, Double: s.SystemSeconds, IsDouble: true},
+		)
+		if s.HasThreads {
+			threads.Points = append(threads.Points, otlpPoint{Start: s.Start, Time: s.Time, IntValue: s.Threads})
+		}
+	}
+
+	metrics := []otlpMetric{memory, virtual, utilization, cpuTime}
+	if len(threads.Points) > 0 {
+		metrics = append(metrics, threads)
+	}
+	return metrics
+}
+
+// protobufRequest encodes an ExportMetricsServiceRequest,
+// see opentelemetry/proto/collector/metrics/v1/metrics_service.proto
+func (e *Otlp) protobufRequest(resources []otlpResource) []byte {
+	var req protoBuffer
+	for _, r := range resources {
+		r := r
+		// ResourceMetrics
+		req.Message(1, func(rm *protoBuffer) {
+			// Resource
+			rm.Message(1, func(res *protoBuffer) {
+				for _, a := range r.Attributes {
+					res.Message(1, a.encodeProtobuf)
+				}
+			})
+			// ScopeMetrics
+			rm.Message(2, func(sm *protoBuffer) {
+				sm.Message(1, func(scope *protoBuffer) {
+					scope.String(1, "peekprof")
+				})
+				for _, m := range r.metrics() {
+					sm.Message(2, m.encodeProtobuf)
+				}
+			})
+		})
+	}
+	return req.Bytes()
+}
+
+func (a otlpAttribute) encodeProtobuf(kv *protoBuffer) {
+	kv.String(1, a.Key)
+	// AnyValue
+	kv.Message(2, func(v *protoBuffer) {
+		if a.IsInt {
+			v.key(3, protoWireVarint)
+			v.varint(uint64(a.IntValue))
+			return
+		}
+		v.key(1, protoWireBytes)
+		v.varint(uint64(len(a.StringValue)))
+		v.b = append(v.b, a.StringValue...)
+	})
+}
+
+func (m otlpMetric) encodeProtobuf(pm *protoBuffer) {
+	pm.String(1, m.Name)
+	pm.String(2, m.Description)
+	pm.String(3, m.Unit)
+	encodePoints := func(data *protoBuffer) {
+		for _, p := range m.Points {
+			data.Message(1, p.encodeProtobuf)
+		}
+	}
+	if !m.Sum {
+		pm.Message(5, encodePoints)
+		return
+	}
+	pm.Message(7, func(sum *protoBuffer) {
+		encodePoints(sum)
+		sum.Uint64(2, otlpAggregationTemporalityCumulative)
+		sum.Bool(3, m.Monotonic)
+	})
+}
+
+// encodeProtobuf encodes a NumberDataPoint
+func (p otlpPoint) encodeProtobuf(dp *protoBuffer) {
+	if !p.Start.IsZero() {
+		dp.Fixed64(2, uint64(p.Start.UnixNano()))
+	}
+	dp.Fixed64(3, uint64(p.Time.UnixNano()))
+	if p.IsDouble {
+		dp.Double(4, p.Double)
+	} else {
+		// as_int is an sfixed64
+		dp.Fixed64(6, uint64(p.IntValue))
+	}
+	for _, a := range p.Attributes {
+		dp.Message(7, a.encodeProtobuf)
+	}
+}
+
+// jsonRequest builds an ExportMetricsServiceRequest in the OTLP json encoding,
+// where 64 bit integers are strings and enums are numbers
+func (e *Otlp) jsonRequest(resources []otlpResource) map[string]interface{} {
+	var resourceMetrics []interface{}
+	for _, r := range resources {
+		var metrics []interface{}
+		for _, m := range r.metrics() {
+			metrics = append(metrics, m.json())
+		}
+		resourceMetrics = append(resourceMetrics, map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpJsonAttributes(r.Attributes)},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]interface{}{"name": "peekprof"},
+				"metrics": metrics,
+			}},
+		})
+	}
+	return map[string]interface{}{"resourceMetrics": resourceMetrics}
+}
+
+func (m otlpMetric) json() map[string]interface{} {
+	var points []interface{}
+	for _, p := range m.Points {
+		point := map[string]interface{}{
+			"timeUnixNano": strconv.FormatInt(p.Time.UnixNano(), 10),
+		}
+		if !p.Start.IsZero() {
+			point["startTimeUnixNano"] = strconv.FormatInt(p.Start.UnixNano(), 10)
+		}
+		if p.IsDouble {
+			point["asDouble"] = otlpJsonDouble(p.Double)
+		} else {
+			point["asInt"] = strconv.FormatInt(p.IntValue, 10)
+		}
+		if len(p.Attributes) > 0 {
+			point["attributes"] = otlpJsonAttributes(p.Attributes)
+		}
+		points = append(points, point)
+	}
+
+	metric := map[string]interface{}{
+		"name":        m.Name,
+		"description": m.Description,
+		"unit":        m.Unit,
+	}
+	if m.Sum {
+		metric["sum"] = map[string]interface{}{
+			"dataPoints":             points,
+			"aggregationTemporality": otlpAggregationTemporalityCumulative,
+			"isMonotonic":            m.Monotonic,
+		}
+	} else {
+		metric["gauge"] = map[string]interface{}{"dataPoints": points}
+	}
+	return metric
+}
+
+func otlpJsonAttributes(attrs []otlpAttribute) []interface{} {
+	var kvs []interface{}
+	for _, a := range attrs {
+		value := map[string]interface{}{"stringValue": a.StringValue}
+		if a.IsInt {
+			value = map[string]interface{}{"intValue": strconv.FormatInt(a.IntValue, 10)}
+		}
+		kvs = append(kvs, map[string]interface{}{"key": a.Key, "value": value})
+	}
+	return kvs
+}
+
+// otlpJsonDouble keeps NaN and infinities, which json can't represent, out of the payload
+func otlpJsonDouble(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,230 @@
+package extractors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProtoBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(p *protoBuffer)
+		want   []byte
+	}{
+		{name: "varint", encode: func(p *protoBuffer) { p.Uint64(1, 150) }, want: []byte{0x08, 0x96, 0x01}},
+		{name: "zero is left out", encode: func(p *protoBuffer) { p.Uint64(1, 0) }, want: nil},
+		{name: "negative int64", encode: func(p *protoBuffer) { p.Int64(2, -1) }, want: []byte{0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{name: "bool", encode: func(p *protoBuffer) { p.Bool(3, true); p.Bool(4, false) }, want: []byte{0x18, 0x01}},
+		{name: "large field number", encode: func(p *protoBuffer) { p.Uint64(16, 1) }, want: []byte{0x80, 0x01, 0x01}},
+		{name: "fixed64", encode: func(p *protoBuffer) { p.Fixed64(3, 1) }, want: []byte{0x19, 1, 0, 0, 0, 0, 0, 0, 0}},
+		{name: "double", encode: func(p *protoBuffer) { p.Double(4, 1) }, want: []byte{0x21, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{name: "string", encode: func(p *protoBuffer) { p.String(2, "testing") }, want: []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{name: "empty string is left out", encode: func(p *protoBuffer) { p.String(2, "") }, want: nil},
+		{name: "packed", encode: func(p *protoBuffer) { p.PackedInt64s(4, []int64{3, 270, 86942}) }, want: []byte{0x22, 0x06, 0x03, 0x8e, 0x02, 0x9e, 0xa7, 0x05}},
+		{
+			name:   "message",
+			encode: func(p *protoBuffer) { p.Message(3, func(m *protoBuffer) { m.Uint64(1, 150) }) },
+			want:   []byte{0x1a, 0x03, 0x08, 0x96, 0x01},
+		},
+		{name: "empty message", encode: func(p *protoBuffer) { p.Message(3, func(m *protoBuffer) {}) }, want: []byte{0x1a, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p protoBuffer
+			tt.encode(&p)
+			if !bytes.Equal(p.Bytes(), tt.want) {
+				t.Errorf("encoded % x, want % x", p.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+// protoField is a field of a message, decoded without its schema
+type protoField struct {
+	Number int
+	Varint uint64
+	Bytes  []byte
+}
+
+func decodeProto(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("invalid key in % x", b)
+		}
+		b = b[n:]
+		f := protoField{Number: int(key >> 3)}
+		switch key & 7 {
+		case protoWireVarint:
+			f.Varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("invalid varint in % x", b)
+			}
+			b = b[n:]
+		case protoWireFixed64:
+			if len(b) < 8 {
+				t.Fatalf("short fixed64 in % x", b)
+			}
+			f.Varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoWireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatalf("invalid length in % x", b)
+			}
+			f.Bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// protoFields returns the fields with the given number
+func protoFields(fields []protoField, number int) []protoField {
+	var found []protoField
+	for _, f := range fields {
+		if f.Number == number {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func TestOtlpProtobufRequest(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := start.Add(time.Second)
+	e := &Otlp{run: RunMetadata{Hostname: "ci"}}
+	resources := e.groupByResource([]otlpSample{{Processes: []otlpProcessSample{{
+		Time: at, Start: start, Pid: 42, PPid: 1, Name: "app", Cmdline: "app -v",
+		RssBytes: 1 << 20, VirtualBytes: 1 << 30,
+		UserUtilization: 0.25, SystemUtilization: 0.5, UserSeconds: 1.5, SystemSeconds: 0.5,
+		Threads: 3, HasThreads: true,
+	}}}})
+
+	req := decodeProto(t, e.protobufRequest(resources))
+	if len(req) != 1 || req[0].Number != 1 {
+		t.Fatalf("request fields = %+v, want a single ResourceMetrics", req)
+	}
+	resourceMetrics := decodeProto(t, req[0].Bytes)
+
+	// Resource attributes
+	resource := decodeProto(t, protoFields(resourceMetrics, 1)[0].Bytes)
+	attrs := map[string][]protoField{}
+	for _, kv := range protoFields(resource, 1) {
+		fields := decodeProto(t, kv.Bytes)
+		attrs[string(protoFields(fields, 1)[0].Bytes)] = decodeProto(t, protoFields(fields, 2)[0].Bytes)
+	}
+	for key, want := range map[string]string{
+		"service.name":            "unknown_service:app",
+		"host.name":               "ci",
+		"process.executable.name": "app",
+		"process.command_line":    "app -v",
+	} {
+		v := attrs[key]
+		if len(v) != 1 || v[0].Number != 1 || string(v[0].Bytes) != want {
+			t.Errorf("attribute %s = %+v, want string value %q", key, v, want)
+		}
+	}
+	for key, want := range map[string]uint64{"process.pid": 42, "process.parent_pid": 1} {
+		v := attrs[key]
+		if len(v) != 1 || v[0].Number != 3 || v[0].Varint != want {
+			t.Errorf("attribute %s = %+v, want int value %d", key, v, want)
+		}
+	}
+
+	// Scope and metrics
+	scopeMetrics := decodeProto(t, protoFields(resourceMetrics, 2)[0].Bytes)
+	scope := decodeProto(t, protoFields(scopeMetrics, 1)[0].Bytes)
+	if string(protoFields(scope, 1)[0].Bytes) != "peekprof" {
+		t.Errorf("scope = %+v, want peekprof", scope)
+	}
+	metrics := map[string][]protoField{}
+	for _, m := range protoFields(scopeMetrics, 2) {
+		fields := decodeProto(t, m.Bytes)
+		metrics[string(protoFields(fields, 1)[0].Bytes)] = fields
+	}
+
+	tests := []struct {
+		name       string
+		sum        bool
+		monotonic  bool
+		unit       string
+		points     int
+		wantInt    int64
+		wantDouble float64
+	}{
+		{name: "process.memory.usage", sum: true, unit: "By", points: 1, wantInt: 1 << 20},
+		{name: "process.memory.virtual", sum: true, unit: "By", points: 1, wantInt: 1 << 30},
+		{name: "process.cpu.utilization", unit: "1", points: 2, wantDouble: 0.25},
+		{name: "process.cpu.time", sum: true, monotonic: true, unit: "s", points: 2, wantDouble: 1.5},
+		{name: "process.threads", sum: true, unit: "{thread}", points: 1, wantInt: 3},
+	}
+	if len(metrics) != len(tests) {
+		t.Errorf("got %d metrics, want %d", len(metrics), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := metrics[tt.name]
+			if !ok {
+				t.Fatal("missing")
+			}
+			if unit := string(protoFields(m, 3)[0].Bytes); unit != tt.unit {
+				t.Errorf("unit = %q, want %q", unit, tt.unit)
+			}
+
+			// A gauge is field 5, a sum is field 7
+			dataField := 5
+			if tt.sum {
+				dataField = 7
+			}
+			dataFields := protoFields(m, dataField)
+			if len(dataFields) != 1 {
+				t.Fatalf("no data in field %d: %+v", dataField, m)
+			}
+			data := decodeProto(t, dataFields[0].Bytes)
+			if tt.sum {
+				temporality := protoFields(data, 2)
+				if len(temporality) != 1 || temporality[0].Varint != otlpAggregationTemporalityCumulative {
+					t.Errorf("aggregation temporality = %+v, want cumulative", temporality)
+				}
+				monotonic := len(protoFields(data, 3)) == 1 && protoFields(data, 3)[0].Varint == 1
+				if monotonic != tt.monotonic {
+					t.Errorf("monotonic = %v, want %v", monotonic, tt.monotonic)
+				}
+			}
+
+			points := protoFields(data, 1)
+			if len(points) != tt.points {
+				t.Fatalf("got %d points, want %d", len(points), tt.points)
+			}
+			point := decodeProto(t, points[0].Bytes)
+			if ts := protoFields(point, 3); len(ts) != 1 || int64(ts[0].Varint) != at.UnixNano() {
+				t.Errorf("time = %+v, want %d", ts, at.UnixNano())
+			}
+			// Cumulative metrics start when the process was first seen
+			if startTs := protoFields(point, 2); tt.sum && (len(startTs) != 1 || int64(startTs[0].Varint) != start.UnixNano()) {
+				t.Errorf("start time = %+v, want %d", startTs, start.UnixNano())
+			}
+			if tt.wantDouble != 0 {
+				if v := protoFields(point, 4); len(v) != 1 || math.Float64frombits(v[0].Varint) != tt.wantDouble {
+					t.Errorf("as_double = %+v, want %v", v, tt.wantDouble)
+				}
+				mode := decodeProto(t, protoFields(point, 7)[0].Bytes)
+				if string(protoFields(mode, 1)[0].Bytes) != "cpu.mode" {
+					t.Errorf("point attributes = %+v, want cpu.mode", mode)
+				}
+			} else if v := protoFields(point, 6); len(v) != 1 || int64(v[0].Varint) != tt.wantInt {
+				t.Errorf("as_int = %+v, want %d", v, tt.wantInt)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package extractors
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PprofExtractorOptions struct {
+	Filename string
+	Run      RunMetadata
+}
+
+func NewPprofExtractorOptions(filename string, run RunMetadata) PprofExtractorOptions {
+	return PprofExtractorOptions{Filename: filename, Run: run}
+}
+
+// Pprof extracts the run as a gzipped pprof profile, for go tool pprof.
+// The stack of every sample is the phase of the run, named after the marker that started it,
+// followed by the names of the process' ancestors in the tree and the name of the process.
+// Pids are left out of the stacks so that profiles of different runs can be compared with -base.
+//
+// There are two sample types: cpu, the cpu time in nanoseconds, and rss, the average
+// resident memory in bytes over the phase, so that the rss of a phase adds up to the rss of the tree.
+type Pprof struct {
+	Filename string
+	run      RunMetadata
+
+	phase      string
+	phases     map[string]*pprofPhase
+	phaseOrder []string
+	start      time.Time
+	last       time.Time
+}
+
+// pprofPhase accumulates the samples of every stack within a phase
+type pprofPhase struct {
+	Duration time.Duration
+	Stacks   map[string]*pprofStack
+}
+
+type pprofStack struct {
+	// Frames are the names of the stack from the process up to the root of the tree
+	Frames []string
+	// CpuNanos is the cpu time of the process
+	CpuNanos float64
+	// RssByteSeconds is the rss integrated over the phase
+	RssByteSeconds float64
+}
+
+// pprofFirstPhase is the phase before the first marker
+const pprofFirstPhase = "run"
+
+func NewPprofExtractor(opts PprofExtractorOptions) *Pprof {
+	return &Pprof{
+		Filename: opts.Filename,
+		run:      opts.Run,
+		phase:    pprofFirstPhase,
+		phases:   map[string]*pprofPhase{},
+	}
+}
+
+func (e *Pprof) Add(data ProcessStatsData) error {
+	// The interval cpu usage is the utilisation since the previous sample
+	dt := e.run.RefreshInterval
+	if e.start.IsZero() {
+		e.start = data.Timestamp
+	} else {
+		dt = data.Timestamp.Sub(e.last)
+	}
+	e.last = data.Timestamp
+
+	phase := e.currentPhase()
+	phase.Duration += dt
+
+	processes := data.Processes
+	if len(processes) == 0 {
+		// The process tree is not known, the whole tree is reported as the process itself
+		processes = []ProcessData{{
+			Pid:         e.run.Pid,
+			Name:        e.run.Name,
+			MemoryUsage: data.MemoryUsage,
+			CpuUsage:    data.CpuUsage,
+		}}
+	}
+	byPid := make(map[int32]ProcessData, len(processes))
+	for _, p := range processes {
+		byPid[p.Pid] = p
+	}
+
+	for _, p := range processes {
+		frames := []string{p.Name}
+		seen := map[int32]bool{p.Pid: true}
+		for parent, ok := byPid[p.PPid]; ok && !seen[parent.Pid]; parent, ok = byPid[parent.PPid] {
+			seen[parent.Pid] = true
+			frames = append(frames, parent.Name)
+		}
+		key := strings.Join(frames, "\x00")
+		s, ok := phase.Stacks[key]
+		if !ok {
+			s = &pprofStack{Frames: frames}
+			phase.Stacks[key] = s
+		}
+		s.CpuNanos += float64(p.CpuUsage.Percentage) / 100 * float64(dt)
+		s.RssByteSeconds += float64(p.MemoryUsage.Rss*1024) * dt.Seconds()
+	}
+
+	return nil
+}
+
+func (e *Pprof) currentPhase() *pprofPhase {
+	phase, ok := e.phases[e.phase]
+	if !ok {
+		phase = &pprofPhase{Stacks: map[string]*pprofStack{}}
+		e.phases[e.phase] = phase
+		e.phaseOrder = append(e.phaseOrder, e.phase)
+	}
+	return phase
+}
+
+// AddMarker starts a new phase, named after the marker.
+// Markers with the same name continue the same phase.
+func (e *Pprof) AddMarker(marker MarkerData) {
+	e.phase = marker.Name
+}
+
+func (e *Pprof) StopAndExtract() error {
+	f, err := os.Create(e.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to create pprof file: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(e.encode()); err != nil {
+		return fmt.Errorf("failed to write pprof profile: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write pprof profile: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close pprof file: %w", err)
+	}
+	fmt.Printf("pprof profile has been written at %s\n", e.Filename)
+
+	return nil
+}
+
+// encode encodes the profile as a perftools.profiles.Profile,
+// see https://github.com/google/pprof/blob/main/proto/profile.proto
+func (e *Pprof) encode() []byte {
+	strs := &pprofStrings{index: map[string]int64{"": 0}, table: []string{""}}
+	functions := map[string]uint64{}
+	var functionNames []string
+	functionId := func(name string) uint64 {
+		id, ok := functions[name]
+		if !ok {
+			id = uint64(len(functions) + 1)
+			functions[name] = id
+			functionNames = append(functionNames, name)
+		}
+		return id
+	}
+
+	var p protoBuffer
+	valueType := func(field int, typ, unit string) {
+		p.Message(field, func(vt *protoBuffer) {
+			vt.Int64(1, strs.id(typ))
+			vt.Int64(2, strs.id(unit))
+		})
+	}
+	valueType(1, "cpu", "nanoseconds")
+	valueType(1, "rss", "bytes")
+
+	for _, name := range e.phaseOrder {
+		phase := e.phases[name]
+		keys := make([]string, 0, len(phase.Stacks))
+		for k := range phase.Stacks {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := phase.Stacks[k]
+			var rss int64
+			if phase.Duration > 0 {
+				rss = int64(s.RssByteSeconds / phase.Duration.Seconds())
+			}
+			var locations []int64
+			for _, frame := range s.Frames {
+				locations = append(locations, int64(functionId(frame)))
+			}
+			locations = append(locations, int64(functionId("phase "+name)))
+
+			p.Message(2, func(sample *protoBuffer) {
+				sample.PackedInt64s(1, locations)
+				sample.PackedInt64s(2, []int64{int64(s.CpuNanos), rss})
+				sample.Message(3, func(label *protoBuffer) {
+					label.Int64(1, strs.id("phase"))
+					label.Int64(2, strs.id(name))
+				})
+			})
+		}
+	}
+
+	// Every function has a location of its own, with the same id
+	for i := range functionNames {
+		id := uint64(i + 1)
+		p.Message(4, func(location *protoBuffer) {
+			location.Uint64(1, id)
+			location.Message(4, func(line *protoBuffer) {
+				line.Uint64(1, id)
+			})
+		})
+	}
+	for i, name := range functionNames {
+		id := uint64(i + 1)
+		nameId := strs.id(name)
+		p.Message(5, func(function *protoBuffer) {
+			function.Uint64(1, id)
+			function.Int64(2, nameId)
+			function.Int64(3, nameId)
+		})
+	}
+
+	comment := strs.id(fmt.Sprintf("peekprof %s (pid %d) on %s", e.run.Cmdline, e.run.Pid, e.run.Hostname))
+	periodType := [2]int64{strs.id("cpu"), strs.id("nanoseconds")}
+	defaultSampleType := strs.id("rss")
+
+	// The string table is complete once every other field has been encoded
+	for _, s := range strs.table {
+		p.key(6, protoWireBytes)
+		p.varint(uint64(len(s)))
+		p.b = append(p.b, s...)
+	}
+	p.Int64(9, e.start.UnixNano())
+	p.Int64(10, int64(e.last.Sub(e.start)))
+	p.Message(11, func(vt *protoBuffer) {
+		vt.Int64(1, periodType[0])
+		vt.Int64(2, periodType[1])
+	})
+	p.Int64(12, int64(e.run.RefreshInterval))
+	p.PackedInt64s(13, []int64{comment})
+	p.Int64(14, defaultSampleType)
+
+	return p.Bytes()
+}
+
+// pprofStrings is the string table of a profile, that every other message refers to by index
+type pprofStrings struct {
+	index map[string]int64
+	table []string
+}
+
+func (s *pprofStrings) id(str string) int64 {
+	id, ok := s.index[str]
+	if !ok {
+		id = int64(len(s.table))
+		s.index[str] = id
+		s.table = append(s.table, str)
+	}
+	return id
+}
@@ -0,0 +1,144 @@
+package extractors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodePackedVarints decodes a packed repeated field of varints
+func decodePackedVarints(t *testing.T, b []byte) []int64 {
+	t.Helper()
+	var values []int64
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("invalid packed varint in % x", b)
+		}
+		values = append(values, int64(v))
+		b = b[n:]
+	}
+	return values
+}
+
+func TestPprofProfile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "run.pb.gz")
+	start := time.Unix(1700000000, 0)
+	e := NewPprofExtractor(NewPprofExtractorOptions(filename, RunMetadata{Pid: 10, Name: "app", RefreshInterval: time.Second}))
+	sample := func(at time.Duration, appCpu float32, appRss, workerRss int64) {
+		e.Add(ProcessStatsData{
+			Timestamp: start.Add(at),
+			Processes: []ProcessData{
+				{Pid: 10, PPid: 1, Name: "app", MemoryUsage: MemoryUsageData{Rss: appRss}, CpuUsage: CpuUsageData{Percentage: appCpu}},
+				{Pid: 11, PPid: 10, Name: "worker", MemoryUsage: MemoryUsageData{Rss: workerRss}, CpuUsage: CpuUsageData{Percentage: 25}},
+			},
+		})
+	}
+	sample(0, 50, 1024, 512)
+	sample(time.Second, 50, 1024, 512)
+	e.AddMarker(MarkerData{Name: "load", Timestamp: start.Add(1500 * time.Millisecond)})
+	sample(2*time.Second, 100, 2048, 256)
+	sample(3*time.Second, 100, 4096, 256)
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := decodeProto(t, b)
+
+	var strs []string
+	for _, f := range protoFields(profile, 6) {
+		strs = append(strs, string(f.Bytes))
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table %q, want it to start with the empty string", strs)
+	}
+	str := func(id uint64) string {
+		if id >= uint64(len(strs)) {
+			t.Fatalf("string %d is not in the table of %d", id, len(strs))
+		}
+		return strs[id]
+	}
+	valueType := func(f protoField) string {
+		vt := decodeProto(t, f.Bytes)
+		return str(protoFields(vt, 1)[0].Varint) + "/" + str(protoFields(vt, 2)[0].Varint)
+	}
+
+	var sampleTypes []string
+	for _, f := range protoFields(profile, 1) {
+		sampleTypes = append(sampleTypes, valueType(f))
+	}
+	if want := []string{"cpu/nanoseconds", "rss/bytes"}; !reflect.DeepEqual(sampleTypes, want) {
+		t.Errorf("sample types %q, want %q", sampleTypes, want)
+	}
+	if got := valueType(protoFields(profile, 11)[0]); got != "cpu/nanoseconds" {
+		t.Errorf("period type %q, want cpu/nanoseconds", got)
+	}
+	if got := str(protoFields(profile, 14)[0].Varint); got != "rss" {
+		t.Errorf("default sample type %q, want rss", got)
+	}
+	if got := int64(protoFields(profile, 9)[0].Varint); got != start.UnixNano() {
+		t.Errorf("time_nanos %d, want %d", got, start.UnixNano())
+	}
+	if got := time.Duration(protoFields(profile, 10)[0].Varint); got != 3*time.Second {
+		t.Errorf("duration_nanos %s, want 3s", got)
+	}
+
+	functions := map[uint64]string{}
+	for _, f := range protoFields(profile, 5) {
+		function := decodeProto(t, f.Bytes)
+		functions[protoFields(function, 1)[0].Varint] = str(protoFields(function, 2)[0].Varint)
+	}
+	locations := map[uint64]string{}
+	for _, f := range protoFields(profile, 4) {
+		location := decodeProto(t, f.Bytes)
+		line := decodeProto(t, protoFields(location, 4)[0].Bytes)
+		locations[protoFields(location, 1)[0].Varint] = functions[protoFields(line, 1)[0].Varint]
+	}
+
+	// Each sample as its stack from the leaf up, its cpu and rss, and its labels
+	var samples []string
+	for _, f := range protoFields(profile, 2) {
+		s := decodeProto(t, f.Bytes)
+		var frames []string
+		for _, id := range decodePackedVarints(t, protoFields(s, 1)[0].Bytes) {
+			frames = append(frames, locations[uint64(id)])
+		}
+		values := decodePackedVarints(t, protoFields(s, 2)[0].Bytes)
+		var labels []string
+		for _, l := range protoFields(s, 3) {
+			label := decodeProto(t, l.Bytes)
+			labels = append(labels, str(protoFields(label, 1)[0].Varint)+"="+str(protoFields(label, 2)[0].Varint))
+		}
+		samples = append(samples, fmt.Sprintf("%s %v %s", strings.Join(frames, ";"), values, strings.Join(labels, ",")))
+	}
+	want := []string{
+		// 50% of 2s, and 1 MB over the whole phase
+		"app;phase run [1000000000 1048576] phase=run",
+		"worker;app;phase run [500000000 524288] phase=run",
+		// 100% of 2s, and the average of 2 and 4 MB over the phase
+		"app;phase load [2000000000 3145728] phase=load",
+		"worker;app;phase load [500000000 262144] phase=load",
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("samples\n%s\nwant\n%s", strings.Join(samples, "\n"), strings.Join(want, "\n"))
+	}
+}
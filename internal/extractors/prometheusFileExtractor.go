@@ -0,0 +1,173 @@
+package extractors
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type PrometheusFileExtractorOptions struct {
+	Filename string
+	Format   MetricsFormat
+	// Interval is how often the file is rewritten while the process is profiled
+	Interval time.Duration
+	Run      RunMetadata
+	// Labels are added to every series, along with the command and the pid of the run
+	Labels [][2]string
+}
+
+func NewPrometheusFileExtractorOptions(filename string, format MetricsFormat, interval time.Duration, run RunMetadata, labels [][2]string) PrometheusFileExtractorOptions {
+	return PrometheusFileExtractorOptions{
+		Filename: filename,
+		Format:   format,
+		Interval: interval,
+		Run:      run,
+		Labels:   labels,
+	}
+}
+
+// PrometheusFile periodically writes the metrics of the latest sample to a file
+// in the Prometheus text exposition or the OpenMetrics format, for node_exporter's
+// textfile collector to pick up. At the end the summary of the run is added.
+//
+// The series of each process of the tree are left out,
+// since their pids would create new series on every run.
+type PrometheusFile struct {
+	Filename   string
+	format     MetricsFormat
+	interval   time.Duration
+	metrics    *MetricsCollector
+	lastWrite  time.Time
+	summary    *Summary
+	exitStatus *ExitStatusData
+}
+
+func NewPrometheusFileExtractor(opts PrometheusFileExtractorOptions) *PrometheusFile {
+	if opts.Format == "" {
+		opts.Format = MetricsFormatPrometheus
+	}
+	labels := append([][2]string{
+		{"command", opts.Run.Cmdline},
+		{"pid", strconv.Itoa(int(opts.Run.Pid))},
+	}, opts.Labels...)
+
+	return &PrometheusFile{
+		Filename: opts.Filename,
+		format:   opts.Format,
+		interval: opts.Interval,
+		metrics:  NewMetricsCollector(opts.Run, labels),
+	}
+}
+
+func (e *PrometheusFile) Add(data ProcessStatsData) error {
+	e.metrics.Add(data)
+	if !e.lastWrite.IsZero() && data.Timestamp.Sub(e.lastWrite) < e.interval {
+		return nil
+	}
+	e.lastWrite = data.Timestamp
+	if err := e.write(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+func (e *PrometheusFile) SetSummary(summary Summary) {
+	e.summary = &summary
+}
+
+func (e *PrometheusFile) SetExitStatus(status ExitStatusData) {
+	e.exitStatus = &status
+}
+
+func (e *PrometheusFile) StopAndExtract() error {
+	if err := e.write(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	fmt.Printf("metrics have been written at %s\n", e.Filename)
+
+	return nil
+}
+
+// write replaces the file atomically, so that the textfile collector
+// never reads a partially written file
+func (e *PrometheusFile) write() error {
+	families := append(e.metrics.families(false), e.summaryFamilies()...)
+	var buf bytes.Buffer
+	if err := writeMetricFamilies(&buf, e.format, e.metrics.labels, families); err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(e.Filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// TempFile creates the file readable only by its owner
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), e.Filename)
+}
+
+// summaryFamilies returns the summary of the run and the exit status of the command,
+// once they are set at the end of the run
+func (e *PrometheusFile) summaryFamilies() []metricFamily {
+	var families []metricFamily
+	if e.summary != nil {
+		s := e.summary
+		families = append(families,
+			distributionFamily("peekprof_summary_rss_bytes", "The distribution of the resident set size over the run.", s.RssKb, 1024),
+			distributionFamily("peekprof_summary_pss_bytes", "The distribution of the proportional set size over the run.", s.PssKb, 1024),
+			distributionFamily("peekprof_summary_virtual_bytes", "The distribution of the virtual memory size over the run.", s.VirtualKb, 1024),
+			distributionFamily("peekprof_summary_cpu_percent", "The distribution of the cpu utilisation over the run.", s.CpuPercent, 1),
+			singleMetric("peekprof_summary_duration_seconds", "The duration of the run.", "gauge", s.Duration.Seconds()),
+			singleMetric("peekprof_summary_time_to_peak_seconds", "The time from the start of the run to the peak rss.", "gauge", s.TimeToPeak.Seconds()),
+			singleMetric("peekprof_summary_cpu_seconds", "The cpu time of the run.", "gauge", s.CpuSeconds),
+			singleMetric("peekprof_summary_dropped_samples", "The samples that were missed during the run.", "gauge", float64(s.DroppedSamples)),
+		)
+		if s.Leak != nil && len(s.Leak.Trends) > 0 {
+			slope := metricFamily{Name: "peekprof_summary_memory_growth_kb_per_minute", Help: "The memory trend of the run after the warm-up.", Type: "gauge"}
+			for _, t := range s.Leak.Trends {
+				slope.Samples = append(slope.Samples, metricSample{[][2]string{{"metric", t.Metric}}, t.SlopeKbPerMinute})
+			}
+			families = append(families, slope)
+		}
+	}
+	if e.exitStatus != nil {
+		families = append(families,
+			singleMetric("peekprof_exit_code", "The exit code of the command.", "gauge", float64(e.exitStatus.ExitCode)),
+			singleMetric("peekprof_max_rss_bytes", "The peak resident set size of the command, as reported by the kernel.", "gauge", float64(e.exitStatus.MaxRss*1024)),
+		)
+	}
+	return families
+}
+
+func distributionFamily(name, help string, d Distribution, scale float64) metricFamily {
+	f := metricFamily{Name: name, Help: help, Type: "gauge"}
+	stats := []struct {
+		Name  string
+		Value float64
+	}{
+		{"min", d.Min}, {"max", d.Max}, {"mean", d.Mean}, {"median", d.Median},
+		{"p90", d.P90}, {"p95", d.P95}, {"p99", d.P99},
+	}
+	for _, s := range stats {
+		f.Samples = append(f.Samples, metricSample{[][2]string{{"stat", s.Name}}, s.Value * scale})
+	}
+	return f
+}
@@ -0,0 +1,96 @@
+package extractors
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// protoBuffer encodes protocol buffers messages by hand, field by field,
+// for the few messages peekprof writes without depending on a protobuf library.
+// See https://protobuf.dev/programming-guides/encoding/ for the wire format.
+type protoBuffer struct {
+	b []byte
+}
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+func (p *protoBuffer) Bytes() []byte {
+	return p.b
+}
+
+func (p *protoBuffer) key(field int, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	p.b = append(p.b, buf[:n]...)
+}
+
+// Uint64 writes a varint field, which is also how int32, int64, bool and enum fields are encoded.
+// Zero values are left out like proto3 does.
+func (p *protoBuffer) Uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.key(field, protoWireVarint)
+	p.varint(v)
+}
+
+func (p *protoBuffer) Int64(field int, v int64) {
+	p.Uint64(field, uint64(v))
+}
+
+func (p *protoBuffer) Bool(field int, v bool) {
+	if v {
+		p.Uint64(field, 1)
+	}
+}
+
+func (p *protoBuffer) Fixed64(field int, v uint64) {
+	p.key(field, protoWireFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	p.b = append(p.b, buf[:]...)
+}
+
+func (p *protoBuffer) Double(field int, v float64) {
+	p.Fixed64(field, math.Float64bits(v))
+}
+
+func (p *protoBuffer) String(field int, s string) {
+	if s == "" {
+		return
+	}
+	p.key(field, protoWireBytes)
+	p.varint(uint64(len(s)))
+	p.b = append(p.b, s...)
+}
+
+// PackedInt64s writes a repeated int64 field in the packed encoding
+func (p *protoBuffer) PackedInt64s(field int, vs []int64) {
+	if len(vs) == 0 {
+		return
+	}
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(uint64(v))
+	}
+	p.key(field, protoWireBytes)
+	p.varint(uint64(len(packed.b)))
+	p.b = append(p.b, packed.b...)
+}
+
+// Message writes an embedded message, encoded by the given function
+func (p *protoBuffer) Message(field int, encode func(m *protoBuffer)) {
+	var m protoBuffer
+	encode(&m)
+	p.key(field, protoWireBytes)
+	p.varint(uint64(len(m.b)))
+	p.b = append(p.b, m.b...)
+}
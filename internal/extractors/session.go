@@ -0,0 +1,143 @@
+package extractors
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+type SessionExtractorOptions struct {
+	Filename string
+	Run      RunMetadata
+}
+
+func NewSessionExtractorOptions(filename string, run RunMetadata) SessionExtractorOptions {
+	return SessionExtractorOptions{Filename: filename, Run: run}
+}
+
+// NewSessionExtractor records the run to a session file, that the report subcommand
+// extracts any of the outputs from later. A session is the ndjson of the run, gzipped.
+// The gzip stream is flushed to the file after every line, so a session that is cut short
+// keeps every sample up to where it ends.
+func NewSessionExtractor(opts SessionExtractorOptions) (*Ndjson, error) {
+	f, err := os.Create(opts.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session file: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+
+	return newNdjson(opts.Filename, "session", gz, gz, []io.Closer{gz, f}, opts.Run)
+}
+
+// Session is a recorded run
+type Session struct {
+	SchemaVersion int
+	Run           RunMetadata
+	// Records are the samples and markers in the order they were recorded
+	Records    []SessionRecord
+	ExitStatus *ExitStatusData
+}
+
+// SessionRecord is either a sample or a marker
+type SessionRecord struct {
+	Sample *ProcessStatsData
+	Marker *MarkerData
+}
+
+// ReadSession reads a session file, or the ndjson of a run, which is the same uncompressed
+func ReadSession(filename string) (*Session, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var session Session
+	hasRun := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record struct {
+			Type          string              `json:"type"`
+			SchemaVersion int                 `json:"schemaVersion"`
+			Run           jsonRun             `json:"run"`
+			ExitStatus    *exitStatusJsonData `json:"exitStatus"`
+			jsonSample
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// The last line of a session that was cut short may be written partly
+			if !scanner.Scan() && (scanner.Err() == nil || scanner.Err() == io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read session line %d: %w", line, err)
+		}
+
+		switch record.Type {
+		case "run":
+			if record.SchemaVersion > JsonSchemaVersion {
+				return nil, fmt.Errorf("session schema version %d is newer than the supported %d", record.SchemaVersion, JsonSchemaVersion)
+			}
+			session.SchemaVersion = record.SchemaVersion
+			session.Run = record.Run.toRunMetadata()
+			hasRun = true
+		case "sample":
+			sample := record.jsonSample.toProcessStatsData()
+			session.Records = append(session.Records, SessionRecord{Sample: &sample})
+		case "marker":
+			marker := MarkerData{Name: record.Name, Timestamp: record.Timestamp}
+			session.Records = append(session.Records, SessionRecord{Marker: &marker})
+		case "end":
+			if record.ExitStatus != nil {
+				status := record.ExitStatus.toExitStatusData()
+				session.ExitStatus = &status
+			}
+		}
+	}
+	// A session that was cut short, e.g. because the profiler was killed, is read up to where it ends
+	if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	if !hasRun {
+		return nil, fmt.Errorf("%s is not a session, it has no run metadata", filename)
+	}
+
+	return &session, nil
+}
+
+// WriteSession writes a session to a session file
+func WriteSession(filename string, session *Session) error {
+	e, err := NewSessionExtractor(NewSessionExtractorOptions(filename, session.Run))
+	if err != nil {
+		return err
+	}
+	for _, r := range session.Records {
+		if r.Sample != nil {
+			if err := e.Add(*r.Sample); err != nil {
+				return err
+			}
+		}
+		if r.Marker != nil {
+			e.AddMarker(*r.Marker)
+		}
+	}
+	if session.ExitStatus != nil {
+		e.SetExitStatus(*session.ExitStatus)
+	}
+
+	return e.StopAndExtract()
+}
@@ -0,0 +1,39 @@
+package extractors
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadSessionCutShort(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "run.peekprof")
+	e, err := NewSessionExtractor(NewSessionExtractorOptions(filename, RunMetadata{Pid: 10, Name: "app"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The profiler is killed before it stops, so the gzip stream is never closed
+	t.Cleanup(func() { e.closers[len(e.closers)-1].Close() })
+
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		if err := e.Add(ProcessStatsData{Timestamp: start.Add(time.Duration(i) * time.Second), MemoryUsage: MemoryUsageData{Rss: int64(i + 1)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.AddMarker(MarkerData{Name: "load", Timestamp: start.Add(2 * time.Second)})
+
+	session, err := ReadSession(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Run.Name != "app" || len(session.Records) != 4 {
+		t.Fatalf("session of %q with %d records, want app with 3 samples and a marker", session.Run.Name, len(session.Records))
+	}
+	if last := session.Records[2].Sample; last == nil || last.MemoryUsage.Rss != 3 {
+		t.Errorf("the last sample is %+v, want the rss of 3", last)
+	}
+	if marker := session.Records[3].Marker; marker == nil || marker.Name != "load" {
+		t.Errorf("the last record is %+v, want the marker", session.Records[3])
+	}
+}
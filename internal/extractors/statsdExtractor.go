@@ -0,0 +1,198 @@
+package extractors
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type StatsdExtractorOptions struct {
+	// Address is the host:port of the StatsD agent
+	Address string
+	// Prefix is prepended to every metric name
+	Prefix string
+	// Tags are added to every metric in the DogStatsD format.
+	// Plain StatsD has no tags, so the processes of the tree are told apart by their metric names instead.
+	Tags [][2]string
+	// SampleRate is the fraction of the metrics that are sent, between 0 and 1
+	SampleRate float64
+	// Window averages the samples over a time window before they are sent, if set
+	Window time.Duration
+	Run    RunMetadata
+}
+
+func NewStatsdExtractorOptions(address, prefix string, tags [][2]string, sampleRate float64, window time.Duration, run RunMetadata) StatsdExtractorOptions {
+	return StatsdExtractorOptions{
+		Address:    address,
+		Prefix:     prefix,
+		Tags:       tags,
+		SampleRate: sampleRate,
+		Window:     window,
+		Run:        run,
+	}
+}
+
+// Statsd sends the memory and cpu of every sample, and of each process of the tree,
+// as gauges to a StatsD or DogStatsD agent over udp
+type Statsd struct {
+	Address    string
+	prefix     string
+	tags       []string
+	dogstatsd  bool
+	sampleRate float64
+	window     time.Duration
+	sender     *batchSender
+
+	windowStart time.Time
+	aggregates  map[string]*statsdAggregate
+}
+
+// statsdAggregate is the sum of the values of a gauge within the aggregation window
+type statsdAggregate struct {
+	Name  string
+	Tags  []string
+	Sum   float64
+	Count int
+}
+
+// statsdPayloadSize keeps each udp datagram within the usual MTU
+const statsdPayloadSize = 1400
+
+func NewStatsdExtractor(opts StatsdExtractorOptions) (*Statsd, error) {
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd: %w", err)
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	prefix := opts.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+
+	e := &Statsd{
+		Address:    opts.Address,
+		prefix:     prefix,
+		dogstatsd:  len(opts.Tags) > 0,
+		sampleRate: opts.SampleRate,
+		window:     opts.Window,
+		aggregates: map[string]*statsdAggregate{},
+	}
+	for _, t := range opts.Tags {
+		e.tags = append(e.tags, t[0]+":"+t[1])
+	}
+
+	senderOpts := defaultBatchSenderOptions("statsd")
+	// The agent either gets the datagram or it doesn't, there is nothing to retry
+	senderOpts.MaxRetries = 0
+	senderOpts.FlushInterval = 100 * time.Millisecond
+	e.sender = newBatchSender(senderOpts, func(batch []interface{}) error {
+		return writeDatagrams(conn, batch, statsdPayloadSize)
+	}, conn.Close)
+
+	return e, nil
+}
+
+func (e *Statsd) Add(data ProcessStatsData) error {
+	if e.windowStart.IsZero() {
+		e.windowStart = data.Timestamp
+	}
+
+	mu := data.MemoryUsage
+	e.gauge("rss_kb", nil, float64(mu.Rss))
+	e.gauge("pss_kb", nil, float64(mu.Pss))
+	e.gauge("uss_kb", nil, float64(mu.Uss))
+	e.gauge("virtual_kb", nil, float64(mu.Virtual))
+	e.gauge("swap_kb", nil, float64(mu.RssSwap-mu.Rss))
+	e.gauge("cpu_percent", nil, float64(data.CpuUsage.Percentage))
+	if len(data.Processes) > 0 {
+		var threads int64
+		for _, p := range data.Processes {
+			threads += p.Threads
+		}
+		e.gauge("threads", nil, float64(threads))
+		e.gauge("processes", nil, float64(len(data.Processes)))
+	}
+
+	for _, p := range data.Processes {
+		name := fmt.Sprintf("process.%s_%d.", graphiteNode(p.Name), p.Pid)
+		var tags []string
+		if e.dogstatsd {
+			name = "process."
+			tags = []string{"pid:" + strconv.Itoa(int(p.Pid)), "name:" + statsdTagEscaper.Replace(p.Name)}
+		}
+		e.gauge(name+"rss_kb", tags, float64(p.MemoryUsage.Rss))
+		e.gauge(name+"pss_kb", tags, float64(p.MemoryUsage.Pss))
+		e.gauge(name+"virtual_kb", tags, float64(p.MemoryUsage.Virtual))
+		e.gauge(name+"cpu_percent", tags, float64(p.CpuUsage.Percentage))
+		e.gauge(name+"threads", tags, float64(p.Threads))
+	}
+
+	if data.Timestamp.Sub(e.windowStart) >= e.window {
+		e.flush()
+		e.windowStart = data.Timestamp
+	}
+
+	return nil
+}
+
+func (e *Statsd) gauge(name string, tags []string, value float64) {
+	key := name + "|" + strings.Join(tags, ",")
+	a, ok := e.aggregates[key]
+	if !ok {
+		a = &statsdAggregate{Name: name, Tags: tags}
+		e.aggregates[key] = a
+	}
+	a.Sum += value
+	a.Count++
+}
+
+// flush sends the average of every gauge over the window,
+// each with the probability of the sample rate
+func (e *Statsd) flush() {
+	keys := make([]string, 0, len(e.aggregates))
+	for k := range e.aggregates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		a := e.aggregates[k]
+		if e.sampleRate < 1 && rand.Float64() >= e.sampleRate {
+			continue
+		}
+		e.sender.Enqueue([]byte(e.line(a.Name, a.Tags, a.Sum/float64(a.Count))))
+	}
+	e.aggregates = map[string]*statsdAggregate{}
+}
+
+func (e *Statsd) line(name string, tags []string, value float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s:%s|g", e.prefix, name, strconv.FormatFloat(value, 'f', -1, 64))
+	if e.sampleRate < 1 {
+		fmt.Fprintf(&b, "|@%s", strconv.FormatFloat(e.sampleRate, 'f', -1, 64))
+	}
+	if e.dogstatsd {
+		allTags := append(append([]string{}, e.tags...), tags...)
+		b.WriteString("|#" + strings.Join(allTags, ","))
+	}
+	return b.String()
+}
+
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_")
+
+func (e *Statsd) StopAndExtract() error {
+	e.flush()
+	sent, dropped, err := e.sender.Close()
+	fmt.Printf("statsd: sent %d metrics to %s, dropped %d\n", sent, e.Address, dropped)
+	if err != nil {
+		fmt.Printf("statsd: %s\n", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package extractors
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testStreamSample(at time.Time) ProcessStatsData {
+	return ProcessStatsData{
+		Timestamp:   at,
+		MemoryUsage: MemoryUsageData{Rss: 2048, RssSwap: 2048, Virtual: 8192, Pss: 1024, Uss: 512},
+		CpuUsage:    CpuUsageData{Percentage: 12.5},
+		Processes: []ProcessData{
+			{Pid: 10, Name: "app server", Threads: 2, MemoryUsage: MemoryUsageData{Rss: 2048}, CpuUsage: CpuUsageData{Percentage: 12.5}},
+		},
+	}
+}
+
+func TestGraphiteStreamsToListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var got []string
+		// The extractor closes the connection when it stops, which ends the scan
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		lines <- got
+	}()
+
+	at := time.Unix(1700000000, 0)
+	e := NewGraphiteExtractor(NewGraphiteExtractorOptions(ln.Addr().String(), "", RunMetadata{Name: "my.app"}))
+	e.Add(testStreamSample(at))
+	e.Add(testStreamSample(at.Add(time.Second)))
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	select {
+	case got = <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed")
+	}
+	// 6 metrics of the tree and 5 of its process, for each of the 2 samples
+	if len(got) != 22 {
+		t.Fatalf("got %d lines, want 22:\n%s", len(got), strings.Join(got, "\n"))
+	}
+	for _, want := range []string{
+		"peekprof.my_app.rss_kb 2048 1700000000",
+		"peekprof.my_app.cpu_percent 12.5 1700000000",
+		"peekprof.my_app.processes.app_server_10.threads 2 1700000001",
+	} {
+		if !containsString(got, want) {
+			t.Errorf("no line %q in:\n%s", want, strings.Join(got, "\n"))
+		}
+	}
+}
+
+// shortWriteConn is a connection that takes the first limit bytes of a write, then fails
+type shortWriteConn struct {
+	net.Conn
+	limit   int
+	written bytes.Buffer
+}
+
+func (c *shortWriteConn) Write(b []byte) (int, error) {
+	n := len(b)
+	if n > c.limit {
+		n = c.limit
+	}
+	c.written.Write(b[:n])
+	return n, errors.New("connection reset")
+}
+
+func (c *shortWriteConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *shortWriteConn) Close() error { return nil }
+
+func TestGraphiteRetriesUnwrittenLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		// The second line is cut off, so it is sent again with the third
+		{name: "a line cut off", limit: len("a 1 1\nb 2"), want: []string{"b 2 1", "c 3 1"}},
+		{name: "whole lines", limit: len("a 1 1\nb 2 1\n"), want: []string{"c 3 1"}},
+		{name: "nothing", limit: 0, want: []string{"a 1 1", "b 2 1", "c 3 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			lines := make(chan []string, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				var got []string
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					got = append(got, scanner.Text())
+				}
+				lines <- got
+			}()
+
+			e := &Graphite{Address: ln.Addr().String()}
+			short := &shortWriteConn{limit: tt.limit}
+			e.conn = short
+			batch := []interface{}{[]byte("a 1 1"), []byte("b 2 1"), []byte("c 3 1")}
+			if err := e.send(batch); err == nil {
+				t.Fatal("send() on a failing connection want an error")
+			}
+			if got := short.written.Len(); got != tt.limit {
+				t.Fatalf("wrote %d bytes, want %d", got, tt.limit)
+			}
+
+			// The retry dials again and writes only what did not get through
+			if err := e.send(batch); err != nil {
+				t.Fatal(err)
+			}
+			e.close()
+			select {
+			case got := <-lines:
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("the retry sent %q, want %q", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the connection was not closed")
+			}
+			if e.unsent != nil {
+				t.Errorf("%d lines are left to send after the retry", len(e.unsent))
+			}
+		})
+	}
+}
+
+func TestGraphiteSendsNewBatchWhole(t *testing.T) {
+	e := &Graphite{Address: "127.0.0.1:0"}
+	e.conn = &shortWriteConn{limit: len("a 1 1\n")}
+	e.send([]interface{}{[]byte("a 1 1"), []byte("b 2 1")})
+
+	// The sender gave up on the previous batch, the tail of which is not sent with the next one
+	short := &shortWriteConn{limit: 1 << 20}
+	e.conn = short
+	e.send([]interface{}{[]byte("c 3 1")})
+	if got := short.written.String(); got != "c 3 1\n" {
+		t.Errorf("wrote %q, want only the new batch", got)
+	}
+}
+
+func TestInfluxStreamsToHttpEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		// The first request fails, and is retried
+		if requests == 1 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	run := RunMetadata{Pid: 10, Name: "app server", Hostname: "ci"}
+	e, err := NewInfluxExtractor(NewInfluxExtractorOptions(server.URL+"/api/v2/write?bucket=b", "secret", run))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Add(testStreamSample(time.Unix(0, 1700000000000000000)))
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || len(bodies) != 1 {
+		t.Fatalf("%d requests with %d accepted, want 2 with 1", requests, len(bodies))
+	}
+	want := `peekprof,host=ci,name=app\ server,pid=10 rss_kb=2048i,rss_swap_kb=2048i,swap_kb=0i,virtual_kb=8192i,pss_kb=1024i,uss_kb=512i,cpu_percent=12.5,user_cpu_percent=0,system_cpu_percent=0,threads=2i,processes=1i 1700000000000000000
+peekprof_process,host=ci,name=app\ server,pid=10,run_pid=10 rss_kb=2048i,virtual_kb=0i,pss_kb=0i,uss_kb=0i,cpu_percent=12.5,threads=2i 1700000000000000000
+`
+	if bodies[0] != want {
+		t.Errorf("body =\n%s\nwant\n%s", bodies[0], want)
+	}
+}
+
+func TestInfluxStreamsToUdpEndpoint(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	e, err := NewInfluxExtractor(NewInfluxExtractorOptions("udp://"+pc.LocalAddr().String(), "", RunMetadata{Name: "app"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		e.Add(testStreamSample(time.Unix(int64(i), 0)))
+	}
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	buf := make([]byte, 64*1024)
+	for lines < 40 {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d lines, want 40: %s", lines, err)
+		}
+		if n > influxUdpPayloadSize {
+			t.Errorf("datagram of %d bytes, want at most %d", n, influxUdpPayloadSize)
+		}
+		lines += strings.Count(string(buf[:n]), "\n")
+	}
+}
+
+func TestInfluxWritesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "run.lp")
+	e, err := NewInfluxExtractor(NewInfluxExtractorOptions(filename, "", RunMetadata{Name: "app"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Add(testStreamSample(time.Unix(1, 0)))
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 2 {
+		t.Errorf("file has %d lines, want 2:\n%s", got, b)
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package extractors
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Distribution describes the values a metric took over a run
+type Distribution struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+}
+
+// summaryQuantileCapacity is how many values each level of a quantile sketch holds,
+// the quantiles are exact until the first level fills up
+const summaryQuantileCapacity = 1024
+
+// streamingDistribution accumulates the values of a metric without keeping all of them,
+// the min, max and mean exactly and the quantiles with a sketch
+type streamingDistribution struct {
+	count  int
+	min    float64
+	max    float64
+	sum    float64
+	sketch quantileSketch
+}
+
+func (d *streamingDistribution) Add(v float64) {
+	if d.count == 0 || v < d.min {
+		d.min = v
+	}
+	if d.count == 0 || v > d.max {
+		d.max = v
+	}
+	d.count++
+	d.sum += v
+	d.sketch.Add(v)
+}
+
+func (d *streamingDistribution) Distribution() Distribution {
+	if d.count == 0 {
+		return Distribution{}
+	}
+
+	return Distribution{
+		Min:    d.min,
+		Max:    d.max,
+		Mean:   d.sum / float64(d.count),
+		Median: d.sketch.Quantile(50),
+		P90:    d.sketch.Quantile(90),
+		P95:    d.sketch.Quantile(95),
+		P99:    d.sketch.Quantile(99),
+	}
+}
+
+// quantileSketch estimates the quantiles of a stream of values, in memory that grows
+// with the logarithm of how many there are. Every level holds up to summaryQuantileCapacity values,
+// each standing for 2^level of the values added. A full level is sorted and every other value of it
+// is promoted to the next level, alternating between the even and the odd ones so that the ranks stay unbiased.
+type quantileSketch struct {
+	levels [][]float64
+	odd    bool
+}
+
+func (s *quantileSketch) Add(v float64) {
+	if len(s.levels) == 0 {
+		s.levels = make([][]float64, 1)
+	}
+	s.levels[0] = append(s.levels[0], v)
+	for l := 0; l < len(s.levels) && len(s.levels[l]) >= summaryQuantileCapacity; l++ {
+		s.compact(l)
+	}
+}
+
+// compact promotes half of the values of a level to the next one
+func (s *quantileSketch) compact(l int) {
+	level := s.levels[l]
+	sort.Float64s(level)
+	if l+1 == len(s.levels) {
+		s.levels = append(s.levels, nil)
+	}
+	start := 0
+	if s.odd {
+		start = 1
+	}
+	s.odd = !s.odd
+	for i := start; i < len(level); i += 2 {
+		s.levels[l+1] = append(s.levels[l+1], level[i])
+	}
+	s.levels[l] = level[:0]
+}
+
+// Quantile returns the p-th percentile of the values,
+// interpolated like percentile as long as every value is still in the sketch
+func (s *quantileSketch) Quantile(p float64) float64 {
+	if len(s.levels) == 0 || (len(s.levels) == 1 && len(s.levels[0]) == 0) {
+		return 0
+	}
+	if len(s.levels) == 1 {
+		sorted := make([]float64, len(s.levels[0]))
+		copy(sorted, s.levels[0])
+		sort.Float64s(sorted)
+		return percentile(sorted, p)
+	}
+
+	type weightedValue struct {
+		value  float64
+		weight float64
+	}
+	var values []weightedValue
+	var total float64
+	for l, level := range s.levels {
+		weight := math.Ldexp(1, l)
+		for _, v := range level {
+			values = append(values, weightedValue{v, weight})
+		}
+		total += weight * float64(len(level))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].value < values[j].value })
+
+	rank := p / 100 * total
+	var cumulative float64
+	for _, v := range values {
+		cumulative += v.weight
+		if cumulative >= rank {
+			return v.value
+		}
+	}
+	return values[len(values)-1].value
+}
+
+// percentile linearly interpolates the p-th percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// Summary are the statistics of a whole run
+type Summary struct {
+	Samples int `json:"samples"`
+	// DroppedSamples are the samples that were expected by the refresh interval but were not taken,
+	// because sampling took longer than the interval
+	DroppedSamples int           `json:"droppedSamples"`
+	Start          time.Time     `json:"start"`
+	Duration       time.Duration `json:"-"`
+	// RssKb, PssKb and VirtualKb are in kilobytes
+	RssKb      Distribution `json:"rssKb"`
+	PssKb      Distribution `json:"pssKb"`
+	VirtualKb  Distribution `json:"virtualKb"`
+	CpuPercent Distribution `json:"cpuPercent"`
+	// TimeToPeak is the time from the first sample until rss peaked
+	TimeToPeak time.Duration `json:"-"`
+	// CpuSeconds is the cpu time, the cpu utilisation integrated over the run
+	CpuSeconds float64 `json:"cpuSeconds"`
+	// MemoryTimeArea is the rss integrated over the run in megabyte-seconds
+	MemoryTimeArea float64 `json:"memoryTimeArea"`
+	// Leak is the trend of the memory over the run, if it was analysed
+	Leak *LeakAnalysis `json:"leak,omitempty"`
+}
+
+// MarshalJSON marshals the durations in seconds
+func (s Summary) MarshalJSON() ([]byte, error) {
+	type summary Summary
+	return json.Marshal(struct {
+		summary
+		DurationSeconds   float64 `json:"durationSeconds"`
+		TimeToPeakSeconds float64 `json:"timeToPeakSeconds"`
+	}{summary(s), s.Duration.Seconds(), s.TimeToPeak.Seconds()})
+}
+
+// Rows returns the distributions as table rows, memory in megabytes
+func (s Summary) Rows() (header []string, rows [][]string) {
+	header = []string{"", "min", "max", "mean", "median", "p90", "p95", "p99"}
+	row := func(name string, d Distribution, scale float64) []string {
+		r := []string{name}
+		for _, v := range []float64{d.Min, d.Max, d.Mean, d.Median, d.P90, d.P95, d.P99} {
+			r = append(r, fmt.Sprintf("%.1f", v/scale))
+		}
+		return r
+	}
+	rows = [][]string{
+		row("rss mb", s.RssKb, 1024),
+		row("pss mb", s.PssKb, 1024),
+		row("virtual mb", s.VirtualKb, 1024),
+		row("cpu %", s.CpuPercent, 1),
+	}
+	return header, rows
+}
+
+// Fields returns the scalar statistics as human readable name-value pairs
+func (s Summary) Fields() [][2]string {
+	return [][2]string{
+		{"samples", fmt.Sprintf("%d", s.Samples)},
+		{"dropped samples", fmt.Sprintf("%d", s.DroppedSamples)},
+		{"duration", s.Duration.Round(time.Millisecond).String()},
+		{"time to peak rss", s.TimeToPeak.Round(time.Millisecond).String()},
+		{"cpu seconds", fmt.Sprintf("%.2f", s.CpuSeconds)},
+		{"memory-time", fmt.Sprintf("%.1f MB·s", s.MemoryTimeArea)},
+	}
+}
+
+// SummaryExtractor is an Extractor that also extracts the summary of the run.
+// SetSummary is called before StopAndExtract.
+type SummaryExtractor interface {
+	SetSummary(summary Summary)
+}
+
+// SummaryCollector accumulates the samples of a run to summarise it, in bounded memory whatever the length of the run
+type SummaryCollector struct {
+	refreshInterval time.Duration
+	leakAnalysis    *LeakAnalysisOptions
+	// series is the memory of the run that the leak analysis fits a trend over
+	series *TimeSeries
+
+	samples    int
+	rss        streamingDistribution
+	pss        streamingDistribution
+	virtual    streamingDistribution
+	cpu        streamingDistribution
+	first      time.Time
+	last       time.Time
+	lastRss    float64
+	peakRss    int64
+	peakRssAt  time.Time
+	dropped    int
+	cpuSeconds float64
+	memoryTime float64
+}
+
+func NewSummaryCollector(refreshInterval time.Duration) *SummaryCollector {
+	return &SummaryCollector{refreshInterval: refreshInterval}
+}
+
+// SetRefreshInterval changes the interval at which the samples are expected from now on
+func (c *SummaryCollector) SetRefreshInterval(interval time.Duration) {
+	c.refreshInterval = interval
+}
+
+// AnalyzeLeaks makes the summary include the trend of the memory over the run.
+// It is called before the first sample is added.
+func (c *SummaryCollector) AnalyzeLeaks(opts LeakAnalysisOptions) {
+	c.leakAnalysis = &opts
+	c.series = NewTimeSeries(DefaultTimeSeriesOptions())
+}
+
+func (c *SummaryCollector) Add(data ProcessStatsData) error {
+	rss := float64(data.MemoryUsage.Rss)
+	// The interval cpu usage is the utilisation since the previous sample
+	dt := c.refreshInterval
+	if c.samples == 0 {
+		c.first = data.Timestamp
+	} else {
+		dt = data.Timestamp.Sub(c.last)
+		c.memoryTime += (c.lastRss + rss) / 2 / 1024 * dt.Seconds()
+		if c.refreshInterval > 0 {
+			if missed := int(math.Round(float64(dt)/float64(c.refreshInterval))) - 1; missed > 0 {
+				c.dropped += missed
+			}
+		}
+	}
+	c.cpuSeconds += float64(data.CpuUsage.Percentage) / 100 * dt.Seconds()
+	c.last = data.Timestamp
+	c.lastRss = rss
+
+	if data.MemoryUsage.Rss > c.peakRss || c.samples == 0 {
+		c.peakRss = data.MemoryUsage.Rss
+		c.peakRssAt = data.Timestamp
+	}
+
+	c.samples++
+	c.rss.Add(rss)
+	c.pss.Add(float64(data.MemoryUsage.Pss))
+	c.virtual.Add(float64(data.MemoryUsage.Virtual))
+	c.cpu.Add(float64(data.CpuUsage.Percentage))
+	if c.series != nil {
+		// Only the memory of the whole tree is analysed
+		c.series.Add(ProcessStatsData{Timestamp: data.Timestamp, MemoryUsage: data.MemoryUsage})
+	}
+
+	return nil
+}
+
+func (c *SummaryCollector) Summary() Summary {
+	var leak *LeakAnalysis
+	if c.leakAnalysis != nil {
+		metrics := []string{"rss"}
+		if runtime.GOOS != "darwin" {
+			metrics = append(metrics, "pss")
+		}
+		analysis := analyzeLeak(*c.leakAnalysis, c.series.Points(), metrics)
+		leak = &analysis
+	}
+
+	return Summary{
+		Leak:           leak,
+		Samples:        c.samples,
+		DroppedSamples: c.dropped,
+		Start:          c.first,
+		Duration:       c.last.Sub(c.first),
+		RssKb:          c.rss.Distribution(),
+		PssKb:          c.pss.Distribution(),
+		VirtualKb:      c.virtual.Distribution(),
+		CpuPercent:     c.cpu.Distribution(),
+		TimeToPeak:     c.peakRssAt.Sub(c.first),
+		CpuSeconds:     c.cpuSeconds,
+		MemoryTimeArea: c.memoryTime,
+	}
+}
@@ -0,0 +1,104 @@
+package extractors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type SummaryJsonExtractorOptions struct {
+	Filename string
+}
+
+func NewSummaryJsonExtractorOptions(filename string) SummaryJsonExtractorOptions {
+	return SummaryJsonExtractorOptions{Filename: filename}
+}
+
+// SummaryJson extracts the summary of the run, and the exit status of the command if any, into a json file
+type SummaryJson struct {
+	Filename   string
+	summary    Summary
+	exitStatus *ExitStatusData
+}
+
+type summaryJsonDocument struct {
+	Summary    Summary             `json:"summary"`
+	ExitStatus *exitStatusJsonData `json:"exitStatus,omitempty"`
+}
+
+type exitStatusJsonData struct {
+	ExitCode                   int     `json:"exitCode"`
+	Signal                     string  `json:"signal,omitempty"`
+	MaxRssKb                   int64   `json:"maxRssKb"`
+	UserSeconds                float64 `json:"userSeconds"`
+	SystemSeconds              float64 `json:"systemSeconds"`
+	MinorPageFaults            int64   `json:"minorPageFaults"`
+	MajorPageFaults            int64   `json:"majorPageFaults"`
+	VoluntaryContextSwitches   int64   `json:"voluntaryContextSwitches"`
+	InvoluntaryContextSwitches int64   `json:"involuntaryContextSwitches"`
+}
+
+func newExitStatusJsonData(s *ExitStatusData) *exitStatusJsonData {
+	if s == nil {
+		return nil
+	}
+	return &exitStatusJsonData{
+		ExitCode:                   s.ExitCode,
+		Signal:                     s.Signal,
+		MaxRssKb:                   s.MaxRss,
+		UserSeconds:                s.UserTime.Seconds(),
+		SystemSeconds:              s.SystemTime.Seconds(),
+		MinorPageFaults:            s.MinorPageFaults,
+		MajorPageFaults:            s.MajorPageFaults,
+		VoluntaryContextSwitches:   s.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: s.InvoluntaryContextSwitches,
+	}
+}
+
+func (s exitStatusJsonData) toExitStatusData() ExitStatusData {
+	return ExitStatusData{
+		ExitCode:                   s.ExitCode,
+		Signal:                     s.Signal,
+		MaxRss:                     s.MaxRssKb,
+		UserTime:                   time.Duration(s.UserSeconds * float64(time.Second)),
+		SystemTime:                 time.Duration(s.SystemSeconds * float64(time.Second)),
+		MinorPageFaults:            s.MinorPageFaults,
+		MajorPageFaults:            s.MajorPageFaults,
+		VoluntaryContextSwitches:   s.VoluntaryContextSwitches,
+		InvoluntaryContextSwitches: s.InvoluntaryContextSwitches,
+	}
+}
+
+func NewSummaryJsonExtractor(filename string) *SummaryJson {
+	return &SummaryJson{Filename: filename}
+}
+
+// Add does nothing, the summary is calculated from all the samples and set with SetSummary
+func (e *SummaryJson) Add(data ProcessStatsData) error {
+	return nil
+}
+
+func (e *SummaryJson) SetSummary(summary Summary) {
+	e.summary = summary
+}
+
+func (e *SummaryJson) SetExitStatus(status ExitStatusData) {
+	e.exitStatus = &status
+}
+
+func (e *SummaryJson) StopAndExtract() error {
+	b, err := json.MarshalIndent(summaryJsonDocument{
+		Summary:    e.summary,
+		ExitStatus: newExitStatusJsonData(e.exitStatus),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %w", err)
+	}
+	if err := os.WriteFile(e.Filename, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	fmt.Printf("summary json has been written at %s\n", e.Filename)
+
+	return nil
+}
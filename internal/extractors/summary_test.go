@@ -0,0 +1,160 @@
+package extractors
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestQuantileSketchExact(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{name: "empty", values: nil, p: 50, want: 0},
+		{name: "single", values: []float64{7}, p: 99, want: 7},
+		{name: "median of odd", values: []float64{3, 1, 2}, p: 50, want: 2},
+		{name: "median of even", values: []float64{4, 1, 3, 2}, p: 50, want: 2.5},
+		{name: "p90 interpolated", values: []float64{10, 0, 5}, p: 90, want: 9},
+		{name: "p0", values: []float64{5, 1, 9}, p: 0, want: 1},
+		{name: "p100", values: []float64{5, 1, 9}, p: 100, want: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s quantileSketch
+			for _, v := range tt.values {
+				s.Add(v)
+			}
+			if got := s.Quantile(tt.p); got != tt.want {
+				t.Errorf("Quantile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuantileSketchBounded(t *testing.T) {
+	const n = 1000000
+	r := rand.New(rand.NewSource(1))
+	var s quantileSketch
+	for _, v := range r.Perm(n) {
+		s.Add(float64(v))
+	}
+
+	kept := 0
+	for _, level := range s.levels {
+		kept += len(level)
+	}
+	// A full level is compacted into the next one, so every level holds less than its capacity
+	if max := len(s.levels) * summaryQuantileCapacity; kept >= max || len(s.levels) > 12 {
+		t.Errorf("the sketch keeps %d values in %d levels", kept, len(s.levels))
+	}
+
+	// The values are a permutation of 0..n-1, so a quantile is also its rank
+	for _, p := range []float64{1, 50, 90, 95, 99} {
+		got := s.Quantile(p)
+		if rankError := math.Abs(got-p/100*n) / n; rankError > 0.01 {
+			t.Errorf("Quantile(%v) = %v, %.2f%% off in rank", p, got, rankError*100)
+		}
+	}
+}
+
+func TestSummaryCollector(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewSummaryCollector(time.Second)
+	// The fourth sample is late, which drops one in between
+	for i, s := range []struct {
+		at  time.Duration
+		rss int64
+		cpu float32
+	}{
+		{0, 1024, 50},
+		{time.Second, 3072, 100},
+		{2 * time.Second, 2048, 0},
+		{4 * time.Second, 1024, 25},
+	} {
+		c.Add(ProcessStatsData{
+			Timestamp:   start.Add(s.at),
+			MemoryUsage: MemoryUsageData{Rss: s.rss, Pss: s.rss / 2, Virtual: 8192 + int64(i)},
+			CpuUsage:    CpuUsageData{Percentage: s.cpu},
+		})
+	}
+
+	got := c.Summary()
+	if got.Samples != 4 || got.DroppedSamples != 1 || got.Duration != 4*time.Second || got.TimeToPeak != time.Second {
+		t.Errorf("Summary() = %d samples, %d dropped, %s long, peaked after %s, want 4, 1, 4s, 1s",
+			got.Samples, got.DroppedSamples, got.Duration, got.TimeToPeak)
+	}
+	wantRss := Distribution{Min: 1024, Max: 3072, Mean: 1792, Median: 1536, P90: 2764.8, P95: 2918.4, P99: 3041.28}
+	if !distributionsEqual(got.RssKb, wantRss) {
+		t.Errorf("RssKb = %+v, want %+v", got.RssKb, wantRss)
+	}
+	if got.PssKb.Max != 1536 || got.VirtualKb.Min != 8192 || got.CpuPercent.Mean != 43.75 {
+		t.Errorf("PssKb.Max, VirtualKb.Min, CpuPercent.Mean = %v, %v, %v, want 1536, 8192, 43.75",
+			got.PssKb.Max, got.VirtualKb.Min, got.CpuPercent.Mean)
+	}
+	// 1s at 50% for the first sample, then the utilisation of each sample over the time since the previous one
+	if math.Abs(got.CpuSeconds-(0.5+1+0+0.5)) > 1e-9 {
+		t.Errorf("CpuSeconds = %v, want 2", got.CpuSeconds)
+	}
+	// The trapezoids of 2, 2.5 and 1.5 MB over 1, 1 and 2 seconds
+	if math.Abs(got.MemoryTimeArea-7.5) > 1e-9 {
+		t.Errorf("MemoryTimeArea = %v, want 7.5", got.MemoryTimeArea)
+	}
+}
+
+func TestSummaryCollectorLongRun(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewSummaryCollector(time.Second)
+	c.AnalyzeLeaks(NewLeakAnalysisOptions(time.Minute, 100))
+
+	// A day of samples, the rss growing 1 MB a minute with a sawtooth of garbage collections on top
+	const n = 24 * 60 * 60
+	for i := 0; i < n; i++ {
+		rss := 100*1024 + int64(i)*1024/60 + int64(i%30)*200
+		c.Add(ProcessStatsData{
+			Timestamp:   start.Add(time.Duration(i) * time.Second),
+			MemoryUsage: MemoryUsageData{Rss: rss, Pss: rss},
+		})
+	}
+
+	// The leak analysis runs over the buckets of the series, not over every sample
+	if points := len(c.series.Points()); points > DefaultTimeSeriesOptions().MaxBuckets+int(DefaultTimeSeriesOptions().RawRetention/time.Second)+1 {
+		t.Errorf("the leak analysis keeps %d points", points)
+	}
+
+	got := c.Summary()
+	if got.Samples != n || got.Leak == nil || len(got.Leak.Trends) == 0 {
+		t.Fatalf("Summary() = %d samples, leak %+v", got.Samples, got.Leak)
+	}
+	trend := got.Leak.Trends[0]
+	if math.Abs(trend.SlopeKbPerMinute-1024) > 10 || !trend.LikelyLeak || !got.Leak.LikelyLeak {
+		t.Errorf("rss trend = %+v, want a leak of 1024 KB/min", trend)
+	}
+	// The warm-up ends within the first bucket that the trend is fit on
+	bucketSamples := int(c.series.bucketWidth / time.Second)
+	if want := n - 60; trend.Samples < want-bucketSamples || trend.Samples > want {
+		t.Errorf("rss trend over %d samples, want at most %d and at least %d", trend.Samples, want, want-bucketSamples)
+	}
+	if got.RssKb.Min != 100*1024 || got.RssKb.Max != 100*1024+(n-1)*1024/60+29*200 {
+		t.Errorf("RssKb = %+v", got.RssKb)
+	}
+	// The median is half way through the run
+	if want := 100*1024 + float64(n/2)*1024/60; math.Abs(got.RssKb.Median-want)/want > 0.01 {
+		t.Errorf("RssKb.Median = %v, want about %v", got.RssKb.Median, want)
+	}
+}
+
+func distributionsEqual(a, b Distribution) bool {
+	x := []float64{a.Min, a.Max, a.Mean, a.Median, a.P90, a.P95, a.P99}
+	y := []float64{b.Min, b.Max, b.Mean, b.Median, b.P90, b.P95, b.P99}
+	for i := range x {
+		if math.Abs(x[i]-y[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
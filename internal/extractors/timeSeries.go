@@ -0,0 +1,372 @@
+package extractors
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TimeSeriesOptions are the retention of a TimeSeries
+type TimeSeriesOptions struct {
+	// RawRetention is for how long the latest samples are kept as they are
+	RawRetention time.Duration
+	// BucketWidth is the initial width of the buckets that older samples are merged into
+	BucketWidth time.Duration
+	// MaxBuckets is how many buckets are kept, when there are more
+	// the width of the buckets doubles and every two of them are merged
+	MaxBuckets int
+	// MaxPoints is how many points are drawn or served at most, downsampled from the series
+	MaxPoints int
+}
+
+// timeSeriesMaxEvents is how many events a bucket keeps at most,
+// so that a run that spawns processes all the time does not grow the buckets without bound
+const timeSeriesMaxEvents = 64
+
+func DefaultTimeSeriesOptions() TimeSeriesOptions {
+	return TimeSeriesOptions{
+		RawRetention: 10 * time.Minute,
+		BucketWidth:  10 * time.Second,
+		MaxBuckets:   1000,
+		MaxPoints:    2000,
+	}
+}
+
+// TimeSeries keeps the samples of a run in bounded memory, whatever its length.
+// The samples of the last RawRetention are kept as they are, older samples are merged
+// into buckets with the min, max and average of each metric, and the buckets become
+// wider the longer the run is, so that there are never more than MaxBuckets of them.
+type TimeSeries struct {
+	mu   sync.Mutex
+	opts TimeSeriesOptions
+
+	// origin is the timestamp of the first sample, which the buckets are aligned to
+	origin      time.Time
+	bucketWidth time.Duration
+	buckets     []TimeSeriesPoint
+	raw         []TimeSeriesPoint
+	// hasProcessTree is whether any sample had the processes of the tree
+	hasProcessTree bool
+}
+
+// TimeSeriesPoint is a single sample, or a bucket of consecutive samples
+type TimeSeriesPoint struct {
+	// From and To are the timestamps of the first and last sample of the point
+	From  time.Time
+	To    time.Time
+	Count int
+	Stats [timeSeriesMetrics]TimeSeriesStat
+	// Processes is the rss of each process of the tree, by pid
+	Processes map[int32]*TimeSeriesProcess
+	// Events are the processes that were spawned or exited within the point,
+	// the first timeSeriesMaxEvents of them for a bucket
+	Events []ProcessEventData
+	// DroppedEvents are how many more events there were in the bucket
+	DroppedEvents int
+}
+
+// TimeSeriesStat is the min, max and sum of a metric over the samples of a point
+type TimeSeriesStat struct {
+	Min float64
+	Max float64
+	Sum float64
+}
+
+// TimeSeriesProcess is the rss of a process over the samples of a point that it was part of
+type TimeSeriesProcess struct {
+	Name  string
+	Count int
+	Rss   TimeSeriesStat
+}
+
+// TimeSeriesMetric is one of the metrics of every point
+type TimeSeriesMetric int
+
+const (
+	TimeSeriesRss TimeSeriesMetric = iota
+	TimeSeriesRssSwap
+	TimeSeriesVirtual
+	TimeSeriesPss
+	TimeSeriesUss
+	TimeSeriesCpu
+	timeSeriesMetrics
+)
+
+func NewTimeSeries(opts TimeSeriesOptions) *TimeSeries {
+	return &TimeSeries{opts: opts, bucketWidth: opts.BucketWidth}
+}
+
+// Avg returns the average of a metric over the samples of the point
+func (p TimeSeriesPoint) Avg(m TimeSeriesMetric) float64 {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Stats[m].Sum / float64(p.Count)
+}
+
+func (s *TimeSeries) Add(data ProcessStatsData) {
+	values := [timeSeriesMetrics]float64{
+		TimeSeriesRss:     float64(data.MemoryUsage.Rss),
+		TimeSeriesRssSwap: float64(data.MemoryUsage.RssSwap),
+		TimeSeriesVirtual: float64(data.MemoryUsage.Virtual),
+		TimeSeriesPss:     float64(data.MemoryUsage.Pss),
+		TimeSeriesUss:     float64(data.MemoryUsage.Uss),
+		TimeSeriesCpu:     float64(data.CpuUsage.Percentage),
+	}
+	p := TimeSeriesPoint{From: data.Timestamp, To: data.Timestamp, Count: 1, Events: data.Events}
+	for m, v := range values {
+		p.Stats[m] = TimeSeriesStat{Min: v, Max: v, Sum: v}
+	}
+	if len(data.Processes) > 0 {
+		p.Processes = make(map[int32]*TimeSeriesProcess, len(data.Processes))
+		for _, proc := range data.Processes {
+			rss := float64(proc.MemoryUsage.Rss)
+			p.Processes[proc.Pid] = &TimeSeriesProcess{
+				Name:  proc.Name,
+				Count: 1,
+				Rss:   TimeSeriesStat{Min: rss, Max: rss, Sum: rss},
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.origin.IsZero() {
+		s.origin = data.Timestamp
+	}
+	if len(data.Processes) > 0 {
+		s.hasProcessTree = true
+	}
+	s.raw = append(s.raw, p)
+
+	// The samples that are older than the raw retention are merged into the buckets
+	expired := 0
+	for expired < len(s.raw) && data.Timestamp.Sub(s.raw[expired].To) > s.opts.RawRetention {
+		s.addToBuckets(s.raw[expired])
+		expired++
+	}
+	// The expired samples are released once append moves the rest to a new array
+	s.raw = s.raw[expired:]
+}
+
+// addToBuckets merges a point into the bucket of its time, widening the buckets if there are too many
+func (s *TimeSeries) addToBuckets(p TimeSeriesPoint) {
+	if n := len(s.buckets); n > 0 && s.slot(s.buckets[n-1].From) == s.slot(p.From) {
+		s.buckets[n-1] = s.buckets[n-1].merge(p)
+	} else {
+		s.buckets = append(s.buckets, p)
+	}
+
+	for len(s.buckets) > s.opts.MaxBuckets {
+		s.bucketWidth *= 2
+		merged := s.buckets[:1]
+		for _, b := range s.buckets[1:] {
+			last := &merged[len(merged)-1]
+			if s.slot(last.From) == s.slot(b.From) {
+				*last = last.merge(b)
+			} else {
+				merged = append(merged, b)
+			}
+		}
+		s.buckets = merged
+	}
+}
+
+func (s *TimeSeries) slot(t time.Time) int64 {
+	return int64(t.Sub(s.origin) / s.bucketWidth)
+}
+
+// merge returns a point that covers the samples of both points, o being the later one
+func (p TimeSeriesPoint) merge(o TimeSeriesPoint) TimeSeriesPoint {
+	merged := TimeSeriesPoint{
+		From:          p.From,
+		To:            o.To,
+		Count:         p.Count + o.Count,
+		DroppedEvents: p.DroppedEvents + o.DroppedEvents,
+	}
+	for _, events := range [][]ProcessEventData{p.Events, o.Events} {
+		kept := len(events)
+		if room := timeSeriesMaxEvents - len(merged.Events); kept > room {
+			kept = room
+		}
+		merged.Events = append(merged.Events, events[:kept]...)
+		merged.DroppedEvents += len(events) - kept
+	}
+	for m := range merged.Stats {
+		merged.Stats[m] = p.Stats[m].merge(o.Stats[m])
+	}
+	if len(p.Processes) > 0 || len(o.Processes) > 0 {
+		merged.Processes = make(map[int32]*TimeSeriesProcess, len(p.Processes)+len(o.Processes))
+		for _, procs := range []map[int32]*TimeSeriesProcess{p.Processes, o.Processes} {
+			for pid, proc := range procs {
+				if prev, ok := merged.Processes[pid]; ok {
+					merged.Processes[pid] = &TimeSeriesProcess{
+						Name:  proc.Name,
+						Count: prev.Count + proc.Count,
+						Rss:   prev.Rss.merge(proc.Rss),
+					}
+					continue
+				}
+				copied := *proc
+				merged.Processes[pid] = &copied
+			}
+		}
+	}
+	return merged
+}
+
+func (s TimeSeriesStat) merge(o TimeSeriesStat) TimeSeriesStat {
+	return TimeSeriesStat{
+		Min: math.Min(s.Min, o.Min),
+		Max: math.Max(s.Max, o.Max),
+		Sum: s.Sum + o.Sum,
+	}
+}
+
+// Points returns the buckets followed by the raw samples, oldest first
+func (s *TimeSeries) Points() []TimeSeriesPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	points := make([]TimeSeriesPoint, 0, len(s.buckets)+len(s.raw))
+	points = append(points, s.buckets...)
+	return append(points, s.raw...)
+}
+
+// Downsample returns the points that draw the shape of the metric best,
+// at most MaxPoints of them, with the Largest-Triangle-Three-Buckets algorithm
+func (s *TimeSeries) Downsample(m TimeSeriesMetric) []TimeSeriesPoint {
+	points := s.Points()
+	indices := lttb(points, m, s.opts.MaxPoints)
+	if len(indices) == len(points) {
+		return points
+	}
+
+	sampled := make([]TimeSeriesPoint, len(indices))
+	for i, idx := range indices {
+		sampled[i] = points[idx]
+	}
+	return sampled
+}
+
+func (s *TimeSeries) HasProcessTree() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.hasProcessTree
+}
+
+func (s *TimeSeries) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.origin = time.Time{}
+	s.bucketWidth = s.opts.BucketWidth
+	s.buckets = nil
+	s.raw = nil
+	s.hasProcessTree = false
+}
+
+// historyPoint is a point as the dashboard expects it, the same as the live updates
+type historyPoint struct {
+	Timestamp   time.Time `json:"timestamp"`
+	MemoryUsage struct {
+		Rss     int64 `json:"rss"`
+		RssSwap int64 `json:"rssSwap"`
+		Virtual int64 `json:"virtual"`
+		Pss     int64 `json:"pss"`
+		Uss     int64 `json:"uss"`
+	} `json:"memoryUsage"`
+	CpuUsage struct {
+		Percentage float64 `json:"percentage"`
+	} `json:"cpuUsage"`
+}
+
+// WriteHistory writes the downsampled series as a json array of the average of each point,
+// timestamped by the last sample of the point
+func (s *TimeSeries) WriteHistory(w io.Writer) error {
+	points := s.Downsample(TimeSeriesRss)
+	history := make([]historyPoint, len(points))
+	for i, p := range points {
+		h := &history[i]
+		h.Timestamp = p.To
+		h.MemoryUsage.Rss = int64(p.Avg(TimeSeriesRss))
+		h.MemoryUsage.RssSwap = int64(p.Avg(TimeSeriesRssSwap))
+		h.MemoryUsage.Virtual = int64(p.Avg(TimeSeriesVirtual))
+		h.MemoryUsage.Pss = int64(p.Avg(TimeSeriesPss))
+		h.MemoryUsage.Uss = int64(p.Avg(TimeSeriesUss))
+		h.CpuUsage.Percentage = p.Avg(TimeSeriesCpu)
+	}
+
+	return json.NewEncoder(w).Encode(history)
+}
+
+// lttb returns the indices of at most threshold points that keep the shape of the metric,
+// see Sveinn Steinarsson, Downsampling Time Series for Visual Representation.
+// The first and last points are always kept.
+func lttb(points []TimeSeriesPoint, m TimeSeriesMetric, threshold int) []int {
+	n := len(points)
+	if threshold <= 2 || n <= threshold {
+		indices := make([]int, n)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices
+	}
+
+	x := func(i int) float64 { return float64(points[i].To.UnixNano()) / 1e9 }
+	y := func(i int) float64 { return points[i].Avg(m) }
+
+	indices := make([]int, 0, threshold)
+	indices = append(indices, 0)
+	// The points between the first and the last are split in threshold-2 buckets,
+	// and the point of each bucket that makes the largest triangle with the point
+	// kept from the previous bucket and the average of the next bucket is kept
+	every := float64(n-2) / float64(threshold-2)
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		nextStart := int(float64(i+1)*every) + 1
+		nextEnd := int(float64(i+2)*every) + 1
+		if nextEnd > n {
+			nextEnd = n
+		}
+		var avgX, avgY float64
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += x(j)
+			avgY += y(j)
+		}
+		if count := float64(nextEnd - nextStart); count > 0 {
+			avgX /= count
+			avgY /= count
+		}
+
+		start := int(float64(i)*every) + 1
+		end := nextStart
+		maxArea := -1.0
+		next := start
+		for j := start; j < end; j++ {
+			area := math.Abs((x(a)-avgX)*(y(j)-y(a)) - (x(a)-x(j))*(avgY-y(a)))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+		indices = append(indices, next)
+		a = next
+	}
+
+	return append(indices, n-1)
+}
+
+// pointIndexAt returns the index of the point that covers t, or the first point after it
+func pointIndexAt(points []TimeSeriesPoint, t time.Time) int {
+	i := sort.Search(len(points), func(i int) bool { return !points[i].To.Before(t) })
+	if i == len(points) && i > 0 {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,165 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testPoints(start time.Time, rss ...float64) []TimeSeriesPoint {
+	points := make([]TimeSeriesPoint, len(rss))
+	for i, v := range rss {
+		at := start.Add(time.Duration(i) * time.Second)
+		points[i] = TimeSeriesPoint{From: at, To: at, Count: 1}
+		points[i].Stats[TimeSeriesRss] = TimeSeriesStat{Min: v, Max: v, Sum: v}
+	}
+	return points
+}
+
+func TestLttb(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		rss       []float64
+		threshold int
+		want      []int
+	}{
+		{name: "empty", rss: nil, threshold: 10, want: []int{}},
+		{name: "fewer points than the threshold", rss: []float64{1, 2, 3}, threshold: 10, want: []int{0, 1, 2}},
+		{name: "as many points as the threshold", rss: []float64{1, 2, 3}, threshold: 3, want: []int{0, 1, 2}},
+		{name: "no room for more than the ends", rss: []float64{1, 2, 3, 4}, threshold: 2, want: []int{0, 1, 2, 3}},
+		// Each of the 3 buckets between the ends keeps its spike
+		{name: "spikes", rss: []float64{0, 0, 9, 0, 0, -9, 0, 0, 5, 0, 0}, threshold: 5, want: []int{0, 2, 5, 8, 10}},
+		// On a line every point makes a triangle of no area, the first of each bucket is kept
+		{name: "line", rss: []float64{0, 1, 2, 3, 4, 5, 6, 7}, threshold: 4, want: []int{0, 1, 4, 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lttb(testPoints(start, tt.rss...), TimeSeriesRss, tt.threshold)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lttb() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeSeriesDownsample(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewTimeSeries(TimeSeriesOptions{RawRetention: time.Hour, BucketWidth: time.Minute, MaxBuckets: 10, MaxPoints: 50})
+	for i := 0; i < 1000; i++ {
+		s.Add(ProcessStatsData{Timestamp: start.Add(time.Duration(i) * time.Second), MemoryUsage: MemoryUsageData{Rss: int64(i % 100)}})
+	}
+
+	got := s.Downsample(TimeSeriesRss)
+	if len(got) != 50 {
+		t.Fatalf("Downsample() = %d points, want 50", len(got))
+	}
+	if !got[0].From.Equal(start) || !got[len(got)-1].To.Equal(start.Add(999*time.Second)) {
+		t.Errorf("Downsample() is from %s to %s, want the first and last samples", got[0].From, got[len(got)-1].To)
+	}
+	for i := 1; i < len(got); i++ {
+		if !got[i].From.After(got[i-1].From) {
+			t.Fatalf("Downsample() is not in order at %d", i)
+		}
+	}
+}
+
+func TestTimeSeriesRollup(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := TimeSeriesOptions{RawRetention: 10 * time.Second, BucketWidth: time.Second, MaxBuckets: 4, MaxPoints: 100}
+
+	tests := []struct {
+		name    string
+		samples int
+		// wantBuckets are the samples in each bucket, oldest first
+		wantBuckets []int
+		wantWidth   time.Duration
+		wantRaw     int
+	}{
+		{name: "only raw samples", samples: 11, wantBuckets: nil, wantWidth: time.Second, wantRaw: 11},
+		{name: "a bucket per sample", samples: 14, wantBuckets: []int{1, 1, 1}, wantWidth: time.Second, wantRaw: 11},
+		// 5 buckets of a second are too many, every two of them are merged into buckets of 2s
+		{name: "buckets widen once", samples: 16, wantBuckets: []int{2, 2, 1}, wantWidth: 2 * time.Second, wantRaw: 11},
+		// 19 samples are merged, which are too many buckets of 1s, 2s and 4s
+		{name: "buckets widen until they fit", samples: 30, wantBuckets: []int{8, 8, 3}, wantWidth: 8 * time.Second, wantRaw: 11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTimeSeries(opts)
+			for i := 0; i < tt.samples; i++ {
+				s.Add(ProcessStatsData{
+					Timestamp:   start.Add(time.Duration(i) * time.Second),
+					MemoryUsage: MemoryUsageData{Rss: int64(i)},
+					CpuUsage:    CpuUsageData{Percentage: 10},
+				})
+			}
+
+			var gotBuckets []int
+			for _, b := range s.buckets {
+				gotBuckets = append(gotBuckets, b.Count)
+			}
+			if !reflect.DeepEqual(gotBuckets, tt.wantBuckets) || s.bucketWidth != tt.wantWidth || len(s.raw) != tt.wantRaw {
+				t.Fatalf("buckets %v of %s and %d raw samples, want %v of %s and %d",
+					gotBuckets, s.bucketWidth, len(s.raw), tt.wantBuckets, tt.wantWidth, tt.wantRaw)
+			}
+
+			// Merging keeps the min, max and sum of every sample
+			points := s.Points()
+			count := 0
+			var sum, min, max float64
+			for i, p := range points {
+				count += p.Count
+				sum += p.Stats[TimeSeriesRss].Sum
+				if i == 0 || p.Stats[TimeSeriesRss].Min < min {
+					min = p.Stats[TimeSeriesRss].Min
+				}
+				if p.Stats[TimeSeriesRss].Max > max {
+					max = p.Stats[TimeSeriesRss].Max
+				}
+				if p.Avg(TimeSeriesCpu) != 10 {
+					t.Errorf("point %d averages %v%% cpu, want 10%%", i, p.Avg(TimeSeriesCpu))
+				}
+			}
+			wantSum := float64(tt.samples*(tt.samples-1)) / 2
+			if count != tt.samples || sum != wantSum || min != 0 || max != float64(tt.samples-1) {
+				t.Errorf("points have %d samples, sum %v, min %v, max %v, want %d, %v, 0, %d",
+					count, sum, min, max, tt.samples, wantSum, tt.samples-1)
+			}
+		})
+	}
+}
+
+func TestTimeSeriesMergeCapsEvents(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewTimeSeries(TimeSeriesOptions{RawRetention: 0, BucketWidth: time.Hour, MaxBuckets: 10, MaxPoints: 100})
+	const samples = 50
+	for i := 0; i < samples; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		s.Add(ProcessStatsData{
+			Timestamp: at,
+			Events: []ProcessEventData{
+				{Type: "spawn", Timestamp: at, Pid: int32(i)},
+				{Type: "exit", Timestamp: at, Pid: int32(i)},
+			},
+		})
+	}
+
+	points := s.Points()
+	if len(s.buckets) != 1 {
+		t.Fatalf("%d buckets, want the samples in a single bucket", len(s.buckets))
+	}
+	kept := 0
+	dropped := 0
+	for _, p := range points {
+		kept += len(p.Events)
+		dropped += p.DroppedEvents
+	}
+	if b := s.buckets[0]; len(b.Events) != timeSeriesMaxEvents || b.Events[0].Pid != 0 {
+		t.Errorf("the bucket has %d events from pid %d, want the first %d", len(b.Events), b.Events[0].Pid, timeSeriesMaxEvents)
+	}
+	if kept+dropped != 2*samples {
+		t.Errorf("%d events kept and %d dropped, want %d in all", kept, dropped, 2*samples)
+	}
+}
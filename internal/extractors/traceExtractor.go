@@ -0,0 +1,189 @@
+package extractors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+type TraceExtractorOptions struct {
+	Filename string
+	Run      RunMetadata
+}
+
+func NewTraceExtractorOptions(filename string, run RunMetadata) TraceExtractorOptions {
+	return TraceExtractorOptions{Filename: filename, Run: run}
+}
+
+// Trace extracts the run in the Trace Event Format, that chrome://tracing and Perfetto open.
+// Every process of the tree is a process track with counters for its rss, pss, cpu and threads.
+// Processes that spawn or exit, and the markers of the run, are instant events.
+// Timestamps are microseconds since the unix epoch, so that the trace lines up with other traces
+// that use the wall clock.
+//
+// Events are written as they come, so the memory used doesn't grow with the length of the run.
+type Trace struct {
+	Filename string
+	run      RunMetadata
+	file     *os.File
+	w        *bufio.Writer
+	events   int
+	// names are the names of the process tracks, by pid
+	names map[int32]string
+	err   error
+}
+
+// traceEvent is an event of the Trace Event Format, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name string `json:"name"`
+	// Ph is the phase of the event, "C" for counters, "i" for instants and "M" for metadata
+	Ph  string `json:"ph"`
+	Ts  int64  `json:"ts"`
+	Pid int32  `json:"pid"`
+	Tid int32  `json:"tid"`
+	// S is the scope of an instant event, "g" for global and "p" for process
+	S    string                 `json:"s,omitempty"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+func NewTraceExtractor(opts TraceExtractorOptions) (*Trace, error) {
+	f, err := os.Create(opts.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace file: %w", err)
+	}
+
+	e := &Trace{
+		Filename: opts.Filename,
+		run:      opts.Run,
+		file:     f,
+		w:        bufio.NewWriter(f),
+		names:    map[int32]string{},
+	}
+	e.w.WriteString(`{"traceEvents":[` + "\n")
+
+	return e, nil
+}
+
+func (e *Trace) Add(data ProcessStatsData) error {
+	ts := data.Timestamp.UnixNano() / 1000
+
+	processes := data.Processes
+	if len(processes) == 0 {
+		// The process tree is not known, the whole tree is reported as the process itself
+		processes = []ProcessData{{
+			Pid:         e.run.Pid,
+			Name:        e.run.Name,
+			MemoryUsage: data.MemoryUsage,
+			CpuUsage:    data.CpuUsage,
+		}}
+	}
+
+	for _, ev := range data.Events {
+		e.write(traceEvent{
+			Name: ev.Type + " " + ev.Name,
+			Ph:   "i",
+			Ts:   ev.Timestamp.UnixNano() / 1000,
+			Pid:  ev.Pid,
+			Tid:  ev.Pid,
+			S:    "p",
+			Args: map[string]interface{}{"ppid": ev.PPid, "cmdline": ev.Cmdline},
+		})
+	}
+
+	for _, p := range processes {
+		e.nameTrack(p.Pid, p.Name)
+		counter := func(name, unit string, value interface{}) {
+			e.write(traceEvent{
+				Name: name,
+				Ph:   "C",
+				Ts:   ts,
+				Pid:  p.Pid,
+				Tid:  p.Pid,
+				Args: map[string]interface{}{unit: value},
+			})
+		}
+		counter("RSS", "kb", p.MemoryUsage.Rss)
+		if p.MemoryUsage.Pss > 0 {
+			counter("PSS", "kb", p.MemoryUsage.Pss)
+		}
+		counter("CPU", "%", p.CpuUsage.Percentage)
+		if p.Threads > 0 {
+			counter("Threads", "count", p.Threads)
+		}
+	}
+
+	if e.err != nil {
+		return fmt.Errorf("failed to write trace: %w", e.err)
+	}
+	return nil
+}
+
+// nameTrack names the process track of the pid, when it is first seen or the process execs
+func (e *Trace) nameTrack(pid int32, name string) {
+	trackName := name + " (" + strconv.Itoa(int(pid)) + ")"
+	if e.names[pid] == trackName {
+		return
+	}
+	e.names[pid] = trackName
+	e.write(traceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  pid,
+		Tid:  pid,
+		Args: map[string]interface{}{"name": trackName},
+	})
+}
+
+func (e *Trace) AddMarker(marker MarkerData) {
+	e.write(traceEvent{
+		Name: marker.Name,
+		Ph:   "i",
+		Ts:   marker.Timestamp.UnixNano() / 1000,
+		Pid:  e.run.Pid,
+		Tid:  e.run.Pid,
+		S:    "g",
+	})
+}
+
+func (e *Trace) write(ev traceEvent) {
+	if e.err != nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if e.events > 0 {
+		e.w.WriteString(",\n")
+	}
+	e.events++
+	_, e.err = e.w.Write(b)
+}
+
+func (e *Trace) StopAndExtract() error {
+	otherData, err := json.Marshal(map[string]interface{}{
+		"command":  e.run.Cmdline,
+		"pid":      e.run.Pid,
+		"hostname": e.run.Hostname,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace metadata: %w", err)
+	}
+	fmt.Fprintf(e.w, "\n],\"displayTimeUnit\":\"ms\",\"otherData\":%s}\n", otherData)
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if err := e.file.Close(); err != nil && e.err == nil {
+		e.err = err
+	}
+	if e.err != nil {
+		return fmt.Errorf("failed to write trace: %w", e.err)
+	}
+	fmt.Printf("trace has been written at %s\n", e.Filename)
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package extractors
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTraceEventFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "run.json")
+	run := RunMetadata{Pid: 10, Name: "app", Cmdline: "app -serve", Hostname: "build"}
+	e, err := NewTraceExtractor(NewTraceExtractorOptions(filename, run))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Unix(1700000000, 500000)
+	e.Add(ProcessStatsData{
+		Timestamp: at,
+		Processes: []ProcessData{
+			{Pid: 10, Name: "app", Threads: 4, MemoryUsage: MemoryUsageData{Rss: 2048, Pss: 1024}, CpuUsage: CpuUsageData{Percentage: 50}},
+			{Pid: 11, PPid: 10, Name: "worker", MemoryUsage: MemoryUsageData{Rss: 512}, CpuUsage: CpuUsageData{Percentage: 25}},
+		},
+		Events: []ProcessEventData{{Type: "spawn", Pid: 11, PPid: 10, Name: "worker", Cmdline: "worker -n 1", Timestamp: at}},
+	})
+	e.AddMarker(MarkerData{Name: "warm", Timestamp: at.Add(time.Second)})
+	// The worker execs another program, which renames its track
+	e.Add(ProcessStatsData{
+		Timestamp: at.Add(2 * time.Second),
+		Processes: []ProcessData{
+			{Pid: 10, Name: "app", MemoryUsage: MemoryUsageData{Rss: 4096}},
+			{Pid: 11, PPid: 10, Name: "gzip", MemoryUsage: MemoryUsageData{Rss: 256}},
+		},
+	})
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []struct {
+			Name string                 `json:"name"`
+			Ph   string                 `json:"ph"`
+			Ts   int64                  `json:"ts"`
+			Pid  int32                  `json:"pid"`
+			Tid  int32                  `json:"tid"`
+			S    string                 `json:"s"`
+			Args map[string]interface{} `json:"args"`
+		} `json:"traceEvents"`
+		DisplayTimeUnit string                 `json:"displayTimeUnit"`
+		OtherData       map[string]interface{} `json:"otherData"`
+	}
+	if err := json.Unmarshal(b, &trace); err != nil {
+		t.Fatalf("the trace is not json: %s\n%s", err, b)
+	}
+	if trace.DisplayTimeUnit != "ms" || trace.OtherData["command"] != "app -serve" || trace.OtherData["hostname"] != "build" {
+		t.Errorf("displayTimeUnit %q and otherData %v", trace.DisplayTimeUnit, trace.OtherData)
+	}
+
+	var names []string
+	counters := map[int32]map[string][]interface{}{}
+	var instants []string
+	for _, ev := range trace.TraceEvents {
+		if ev.Pid != ev.Tid {
+			t.Errorf("event %q has pid %d and tid %d, want the same", ev.Name, ev.Pid, ev.Tid)
+		}
+		switch ev.Ph {
+		case "M":
+			if ev.Name != "process_name" {
+				t.Errorf("metadata event %q, want process_name", ev.Name)
+			}
+			names = append(names, ev.Args["name"].(string))
+		case "C":
+			if counters[ev.Pid] == nil {
+				counters[ev.Pid] = map[string][]interface{}{}
+			}
+			for unit, v := range ev.Args {
+				counters[ev.Pid][ev.Name+" "+unit] = append(counters[ev.Pid][ev.Name+" "+unit], v)
+			}
+			if ev.Ts != 1700000000000500 && ev.Ts != 1700000002000500 {
+				t.Errorf("counter %q at %d, want the microseconds of a sample", ev.Name, ev.Ts)
+			}
+		case "i":
+			instants = append(instants, ev.Name+" "+ev.S)
+			if ev.Name == "spawn worker" && (ev.Pid != 11 || ev.Args["ppid"] != float64(10) || ev.Args["cmdline"] != "worker -n 1") {
+				t.Errorf("spawn event on pid %d with args %v", ev.Pid, ev.Args)
+			}
+			if ev.Name == "warm" && (ev.Pid != 10 || ev.Ts != 1700000001000500) {
+				t.Errorf("marker on pid %d at %d, want pid 10 at 1700000001000500", ev.Pid, ev.Ts)
+			}
+		default:
+			t.Errorf("event %q has phase %q", ev.Name, ev.Ph)
+		}
+	}
+
+	if want := []string{"app (10)", "worker (11)", "gzip (11)"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("process names %q, want %q", names, want)
+	}
+	if want := []string{"spawn worker p", "warm g"}; !reflect.DeepEqual(instants, want) {
+		t.Errorf("instant events %q, want %q", instants, want)
+	}
+	for _, tt := range []struct {
+		pid     int32
+		counter string
+		want    []interface{}
+	}{
+		{10, "RSS kb", []interface{}{float64(2048), float64(4096)}},
+		// Zero pss and threads are not known, rather than zero, so they are left out
+		{10, "PSS kb", []interface{}{float64(1024)}},
+		{10, "Threads count", []interface{}{float64(4)}},
+		{10, "CPU %", []interface{}{float64(50), float64(0)}},
+		{11, "RSS kb", []interface{}{float64(512), float64(256)}},
+		{11, "PSS kb", nil},
+		{11, "CPU %", []interface{}{float64(25), float64(0)}},
+	} {
+		if got := counters[tt.pid][tt.counter]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pid %d counter %q = %v, want %v", tt.pid, tt.counter, got, tt.want)
+		}
+	}
+}
+
+func TestTraceWithoutProcessTree(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "run.json")
+	e, err := NewTraceExtractor(NewTraceExtractorOptions(filename, RunMetadata{Pid: 42, Name: "app"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Add(ProcessStatsData{Timestamp: time.Unix(1700000000, 0), MemoryUsage: MemoryUsageData{Rss: 100}})
+	if err := e.StopAndExtract(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(b, &trace); err != nil {
+		t.Fatalf("the trace is not json: %s\n%s", err, b)
+	}
+	// The whole tree is a single track, named after the process
+	if len(trace.TraceEvents) != 3 || trace.TraceEvents[0].Args["name"] != "app (42)" || trace.TraceEvents[1].Pid != 42 {
+		t.Errorf("events = %+v, want a track for pid 42 with its rss and cpu", trace.TraceEvents)
+	}
+}
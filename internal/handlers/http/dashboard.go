@@ -0,0 +1,39 @@
+package httphandler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// DashboardRenderer renders the html page of the live dashboard
+type DashboardRenderer interface {
+	RenderDashboard(w io.Writer) error
+}
+
+// DashboardHandler serves the live dashboard at the root of the server
+type DashboardHandler struct {
+	dashboard DashboardRenderer
+}
+
+func NewDashboardHandler(dashboard DashboardRenderer) *DashboardHandler {
+	return &DashboardHandler{dashboard: dashboard}
+}
+
+func (h *DashboardHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// The handler is registered at / which matches every path that no other handler does
+	if req.URL.Path != "/" {
+		http.NotFound(rw, req)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.dashboard.RenderDashboard(&buf); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-cache")
+	buf.WriteTo(rw)
+}
@@ -1,36 +1,109 @@
 package httphandler
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// SlowClientPolicy is what the broker does with a client whose queue is full
+type SlowClientPolicy string
+
+const (
+	// SlowClientDropOldest drops the oldest event of the client's queue to make room for the new one
+	SlowClientDropOldest SlowClientPolicy = "drop-oldest"
+	// SlowClientDisconnect disconnects the client, which is expected to reconnect and reload the history
+	SlowClientDisconnect SlowClientPolicy = "disconnect"
+)
+
+func ParseSlowClientPolicy(s string) (SlowClientPolicy, error) {
+	switch p := SlowClientPolicy(s); p {
+	case SlowClientDropOldest, SlowClientDisconnect:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown slow client policy %q", s)
+	}
+}
+
+// DefaultClientQueueSize is how many events are queued for each client by default
+const DefaultClientQueueSize = 64
+
+// DefaultClientWriteTimeout is for how long writing an event to a client may take by default,
+// before the client is disconnected
+const DefaultClientWriteTimeout = 10 * time.Second
+
+type connContextKey struct{}
+
+// ConnContext keeps the connection of every request in its context,
+// so that the live updates can set a deadline on each write to the client.
+// It is meant for http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
 // A Broker holds open client connections,
 // listens for incoming events on its Notifier channel
-// and broadcast event data to all registered connections
+// and broadcast event data to all registered connections.
+//
+// Every client has a bounded queue of its own, so a client that does not keep up
+// never blocks the broker, and with it the sampling that notifies it.
 type EventSourceServer struct {
 
 	// Events are pushed to this channel by the main events-gathering routine
 	Notifier chan []byte
 
 	// New client connections
-	newClients chan chan []byte
+	newClients chan *eventSourceClient
 
 	// Closed client connections
-	closingClients chan chan []byte
+	closingClients chan *eventSourceClient
 
 	// Client connections registry
-	clients map[chan []byte]bool
+	clients map[*eventSourceClient]bool
+
+	queueSize    int
+	policy       SlowClientPolicy
+	writeTimeout time.Duration
+
+	// done is closed when the broker is closed, which ends every client connection
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// connected, sent, dropped and disconnected are counters for the metrics
+	connected    int64
+	sent         uint64
+	dropped      uint64
+	disconnected uint64
 }
 
-func NewEventSourceServer() (server *EventSourceServer) {
+// eventSourceClient is a connection and its queue of events
+type eventSourceClient struct {
+	events chan []byte
+	// kicked is closed by the broker when it disconnects the client
+	kicked chan struct{}
+}
+
+func NewEventSourceServer(queueSize int, policy SlowClientPolicy, writeTimeout time.Duration) (server *EventSourceServer) {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
 	// Instantiate a server
 	server = &EventSourceServer{
 		Notifier:       make(chan []byte, 1),
-		newClients:     make(chan chan []byte),
-		closingClients: make(chan chan []byte),
-		clients:        make(map[chan []byte]bool),
+		newClients:     make(chan *eventSourceClient),
+		closingClients: make(chan *eventSourceClient),
+		clients:        make(map[*eventSourceClient]bool),
+		queueSize:      queueSize,
+		policy:         policy,
+		writeTimeout:   writeTimeout,
+		done:           make(chan struct{}),
 	}
 
 	// Set it running - listening and broadcasting events
@@ -50,43 +123,87 @@ func (server *EventSourceServer) ServeHTTP(rw http.ResponseWriter, req *http.Req
 		return
 	}
 
-	rw.Header().Set("Content-Type", "text/event-stream")
-	rw.Header().Set("Cache-Control", "no-cache")
-	rw.Header().Set("Connection", "keep-alive")
-	rw.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Each connection registers its own message channel with the Broker's connections registry
-	messageChan := make(chan []byte)
+	client := &eventSourceClient{
+		events: make(chan []byte, server.queueSize),
+		kicked: make(chan struct{}),
+	}
 
 	// Signal the server that we have a new connection
-	server.newClients <- messageChan
+	select {
+	case server.newClients <- client:
+	case <-server.done:
+		http.Error(rw, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
 
 	// Remove this client from the map of connected clients
-	// when this handler exits.
+	// when this handler exits, which is the only place that does so.
 	defer func() {
-		server.closingClients <- messageChan
+		select {
+		case server.closingClients <- client:
+		case <-server.done:
+		}
 	}()
 
-	// Listen to connection close and un-register messageChan
-	// notify := rw.(http.CloseNotifier).CloseNotify()
-	notify := req.Context().Done()
+	// A client that stops reading would block a write until the connection breaks, which may be never,
+	// so every write has a deadline when the server keeps the connections in the request context
+	conn, _ := req.Context().Value(connContextKey{}).(net.Conn)
+	if conn != nil && server.writeTimeout > 0 {
+		// The connection may be reused once the stream ends
+		defer conn.SetWriteDeadline(time.Time{})
+	} else {
+		conn = nil
+	}
 
-	go func() {
-		<-notify
-		server.closingClients <- messageChan
-	}()
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
 	for {
+		select {
+		case event := <-client.events:
+			if conn != nil {
+				conn.SetWriteDeadline(time.Now().Add(server.writeTimeout))
+			}
+			// Write to the ResponseWriter
+			// Server Sent Events compatible
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", event); err != nil {
+				return
+			}
 
-		// Write to the ResponseWriter
-		// Server Sent Events compatible
-		fmt.Fprintf(rw, "data: %s\n\n", <-messageChan)
+			// Flush the data immediatly instead of buffering it for later.
+			// A write that fails, or misses its deadline, cancels the request context.
+			flusher.Flush()
+		case <-client.kicked:
+			return
+		case <-req.Context().Done():
+			return
+		case <-server.done:
+			return
+		}
+	}
+}
 
-		// Flush the data immediatly instead of buffering it for later.
-		flusher.Flush()
+// Publish broadcasts an event to every client. It only waits for the broker,
+// which never waits for the clients, and returns right away once the broker is closed.
+func (server *EventSourceServer) Publish(event []byte) {
+	select {
+	case server.Notifier <- event:
+	case <-server.done:
 	}
 }
 
+// Close disconnects every client and stops the broker.
+// It should be called before the http server is shut down, which waits for the connections to end.
+func (server *EventSourceServer) Close() {
+	server.closeOnce.Do(func() {
+		close(server.done)
+	})
+}
+
 func (server *EventSourceServer) listen() {
 	for {
 		select {
@@ -95,21 +212,84 @@ func (server *EventSourceServer) listen() {
 			// A new client has connected.
 			// Register their message channel
 			server.clients[s] = true
+			atomic.StoreInt64(&server.connected, int64(len(server.clients)))
 			log.Printf("Client added. %d registered clients", len(server.clients))
 		case s := <-server.closingClients:
 
 			// A client has dettached and we want to
 			// stop sending them messages.
+			if !server.clients[s] {
+				// The client was disconnected by the broker already
+				continue
+			}
 			delete(server.clients, s)
+			atomic.StoreInt64(&server.connected, int64(len(server.clients)))
 			log.Printf("Removed client. %d registered clients", len(server.clients))
 		case event := <-server.Notifier:
 
 			// We got a new event from the outside!
-			// Send event to all connected clients
-			for clientMessageChan, _ := range server.clients {
-				clientMessageChan <- event
+			// Queue the event for all connected clients, without ever waiting for one
+			for client := range server.clients {
+				server.enqueue(client, event)
 			}
+		case <-server.done:
+			return
 		}
 	}
+}
+
+// enqueue queues the event for the client, applying the slow client policy if its queue is full.
+// The broker is the only sender to the queue, so it cannot fill up again meanwhile.
+func (server *EventSourceServer) enqueue(client *eventSourceClient, event []byte) {
+	select {
+	case client.events <- event:
+		atomic.AddUint64(&server.sent, 1)
+		return
+	default:
+	}
+
+	switch server.policy {
+	case SlowClientDisconnect:
+		delete(server.clients, client)
+		close(client.kicked)
+		atomic.StoreInt64(&server.connected, int64(len(server.clients)))
+		atomic.AddUint64(&server.disconnected, 1)
+		atomic.AddUint64(&server.dropped, 1)
+		log.Printf("Disconnected slow client. %d registered clients", len(server.clients))
+	default:
+		select {
+		case <-client.events:
+			atomic.AddUint64(&server.dropped, 1)
+		default:
+		}
+		select {
+		case client.events <- event:
+			atomic.AddUint64(&server.sent, 1)
+		default:
+			atomic.AddUint64(&server.dropped, 1)
+		}
+	}
+}
 
+// WriteMetrics writes the counters of the broker in the Prometheus text exposition format
+func (server *EventSourceServer) WriteMetrics(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `# HELP peekprof_live_clients Clients connected to the live updates.
+# TYPE peekprof_live_clients gauge
+peekprof_live_clients %d
+# HELP peekprof_live_events_sent_total Events queued for the clients of the live updates.
+# TYPE peekprof_live_events_sent_total counter
+peekprof_live_events_sent_total %d
+# HELP peekprof_live_events_dropped_total Events dropped because a client did not keep up with the live updates.
+# TYPE peekprof_live_events_dropped_total counter
+peekprof_live_events_dropped_total %d
+# HELP peekprof_live_clients_disconnected_total Clients disconnected because they did not keep up with the live updates.
+# TYPE peekprof_live_clients_disconnected_total counter
+peekprof_live_clients_disconnected_total %d
+`,
+		atomic.LoadInt64(&server.connected),
+		atomic.LoadUint64(&server.sent),
+		atomic.LoadUint64(&server.dropped),
+		atomic.LoadUint64(&server.disconnected),
+	)
+	return err
 }
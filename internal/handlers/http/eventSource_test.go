@@ -0,0 +1,241 @@
+package httphandler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The broker logs every client that comes and goes
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newTestEventSource(t *testing.T, queueSize int, policy SlowClientPolicy) (*EventSourceServer, *httptest.Server) {
+	t.Helper()
+	es := NewEventSourceServer(queueSize, policy, 100*time.Millisecond)
+	ts := httptest.NewUnstartedServer(es)
+	ts.Config.ConnContext = ConnContext
+	ts.Start()
+	t.Cleanup(func() {
+		// The streams never end on their own, the broker ends them before the server waits for them
+		es.Close()
+		ts.Close()
+	})
+	return es, ts
+}
+
+// dialStalled connects a client that reads the response headers, then stops reading
+func dialStalled(t *testing.T, ts *httptest.Server) (net.Conn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: peekprof\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	return conn, resp
+}
+
+// publishAll publishes the events, failing the test if Publish blocks
+func publishAll(t *testing.T, es *EventSourceServer, event []byte, n int) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			es.Publish(event)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked")
+	}
+}
+
+// waitFor polls the condition until it holds, failing the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (server *EventSourceServer) counters() (connected int64, sent, dropped, disconnected uint64) {
+	return atomic.LoadInt64(&server.connected),
+		atomic.LoadUint64(&server.sent),
+		atomic.LoadUint64(&server.dropped),
+		atomic.LoadUint64(&server.disconnected)
+}
+
+func TestEventSourceStalledClientDropOldest(t *testing.T) {
+	es, ts := newTestEventSource(t, 4, SlowClientDropOldest)
+	dialStalled(t, ts)
+	waitFor(t, "the client to connect", func() bool { c, _, _, _ := es.counters(); return c == 1 })
+
+	// The handler ends up stuck writing once the socket buffers are full, as the client never reads,
+	// until the write misses its deadline, which ends the stream and removes the client
+	event := bytes.Repeat([]byte("x"), 1024*1024)
+	waitFor(t, "the client to be removed", func() bool {
+		publishAll(t, es, event, 10)
+		c, _, _, _ := es.counters()
+		return c == 0
+	})
+
+	// The older events made room for the newer ones meanwhile
+	_, sent, dropped, disconnected := es.counters()
+	if dropped == 0 || dropped > sent {
+		t.Errorf("dropped = %d, want between 1 and the %d sent", dropped, sent)
+	}
+	if disconnected != 0 {
+		t.Errorf("disconnected = %d, want 0", disconnected)
+	}
+}
+
+func TestEventSourceStalledClientDisconnect(t *testing.T) {
+	es, ts := newTestEventSource(t, 1, SlowClientDisconnect)
+	conn, resp := dialStalled(t, ts)
+	waitFor(t, "the client to connect", func() bool { c, _, _, _ := es.counters(); return c == 1 })
+
+	event := bytes.Repeat([]byte("x"), 1024*1024)
+	waitFor(t, "the client to be disconnected", func() bool {
+		publishAll(t, es, event, 10)
+		_, _, _, d := es.counters()
+		return d == 1
+	})
+
+	connected, _, dropped, disconnected := es.counters()
+	if connected != 0 || dropped != 1 || disconnected != 1 {
+		t.Errorf("connected, dropped, disconnected = %d, %d, %d, want 0, 1, 1", connected, dropped, disconnected)
+	}
+
+	// The stream ends, after a write that was stuck misses its deadline if there was one
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(resp.Body); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("the stream did not end: %s", err)
+	}
+}
+
+func TestEventSourceAbruptDisconnect(t *testing.T) {
+	es, ts := newTestEventSource(t, 4, SlowClientDropOldest)
+	conn, _ := dialStalled(t, ts)
+	waitFor(t, "the client to connect", func() bool { c, _, _, _ := es.counters(); return c == 1 })
+
+	conn.Close()
+	waitFor(t, "the client to be removed", func() bool { c, _, _, _ := es.counters(); return c == 0 })
+
+	// Nothing is queued for a client that has gone
+	publishAll(t, es, []byte("event"), 10)
+	// The broker handles an event only once it took the previous one
+	publishAll(t, es, []byte("event"), 1)
+	if _, sent, dropped, _ := es.counters(); sent != 0 || dropped != 0 {
+		t.Errorf("sent, dropped = %d, %d, want 0, 0", sent, dropped)
+	}
+}
+
+func TestEventSourceConcurrentClients(t *testing.T) {
+	const clients = 10
+	const events = 20
+	es, ts := newTestEventSource(t, events, SlowClientDropOldest)
+
+	var wg sync.WaitGroup
+	received := make([][]string, clients)
+	bodies := make(chan *http.Response, clients)
+	for i := 0; i < clients; i++ {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies <- resp
+		wg.Add(1)
+		go func(i int, resp *http.Response) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() && len(received[i]) < events {
+				if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+					received[i] = append(received[i], strings.TrimPrefix(line, "data: "))
+				}
+			}
+		}(i, resp)
+	}
+	close(bodies)
+	waitFor(t, "every client to connect", func() bool { c, _, _, _ := es.counters(); return c == clients })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < events; i++ {
+			es.Publish([]byte(fmt.Sprint(i)))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked")
+	}
+	wg.Wait()
+
+	for i, got := range received {
+		if len(got) != events {
+			t.Fatalf("client %d received %d events, want %d", i, len(got), events)
+		}
+		for j, e := range got {
+			if e != fmt.Sprint(j) {
+				t.Fatalf("client %d received %v, want the events in order", i, got)
+			}
+		}
+	}
+	if _, sent, dropped, _ := es.counters(); sent != clients*events || dropped != 0 {
+		t.Errorf("sent, dropped = %d, %d, want %d, 0", sent, dropped, clients*events)
+	}
+
+	for resp := range bodies {
+		resp.Body.Close()
+	}
+	waitFor(t, "every client to be removed", func() bool { c, _, _, _ := es.counters(); return c == 0 })
+}
+
+func TestEventSourceClose(t *testing.T) {
+	es, ts := newTestEventSource(t, 4, SlowClientDropOldest)
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitFor(t, "the client to connect", func() bool { c, _, _, _ := es.counters(); return c == 1 })
+
+	es.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Errorf("the stream did not end: %s", err)
+	}
+	// Publishing after the broker is closed returns right away
+	publishAll(t, es, []byte("event"), 10)
+}
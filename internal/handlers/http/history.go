@@ -0,0 +1,34 @@
+package httphandler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// HistoryWriter writes the samples so far as a json array
+type HistoryWriter interface {
+	WriteHistory(w io.Writer) error
+}
+
+// HistoryHandler serves the samples that were sent to the live clients so far,
+// so that a page that is opened late can show the samples from before it connected.
+type HistoryHandler struct {
+	history HistoryWriter
+}
+
+func NewHistoryHandler(history HistoryWriter) *HistoryHandler {
+	return &HistoryHandler{history: history}
+}
+
+func (h *HistoryHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	if err := h.history.WriteHistory(&buf); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-cache")
+	buf.WriteTo(rw)
+}
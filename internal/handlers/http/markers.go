@@ -0,0 +1,38 @@
+package httphandler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// MarkersHandler adds a marker to the run on every POST request.
+// The name of the marker is either the name query parameter or the body of the request.
+type MarkersHandler struct {
+	addMarker func(name string)
+}
+
+func NewMarkersHandler(addMarker func(name string)) *MarkersHandler {
+	return &MarkersHandler{addMarker: addMarker}
+}
+
+func (h *MarkersHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, 1024))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name = strings.TrimSpace(string(body))
+	}
+	h.addMarker(name)
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,36 @@
+package httphandler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// MetricsWriter writes metrics in the Prometheus text exposition format
+type MetricsWriter interface {
+	WriteMetrics(w io.Writer) error
+}
+
+// MetricsHandler serves the metrics of the profiled process to Prometheus scrapes,
+// followed by the metrics of the profiler itself
+type MetricsHandler struct {
+	metrics []MetricsWriter
+}
+
+func NewMetricsHandler(metrics ...MetricsWriter) *MetricsHandler {
+	return &MetricsHandler{metrics: metrics}
+}
+
+func (h *MetricsHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// The metrics are written to a buffer first so that a failure can still be reported with a status
+	var buf bytes.Buffer
+	for _, m := range h.metrics {
+		if err := m.WriteMetrics(&buf); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf.WriteTo(rw)
+}
@@ -27,6 +27,15 @@ func (p *DarwinProcess) GetName() (string, error) {
 	return outputStr, nil
 }
 
+func (p *DarwinProcess) GetCmdline() (string, error) {
+	output, err := exec.Command("ps", "-p", fmt.Sprintf("%d", p.Pid), "-o", "command=").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 func (p *DarwinProcess) WatchStats(ctx context.Context, interval time.Duration) <-chan ProcessStats {
 	ch := make(chan ProcessStats)
 
@@ -100,9 +109,10 @@ func (p *DarwinProcess) GetMemoryUsage() (MemoryUsage, error) {
 		return emptymu, fmt.Errorf("failed getting process rss: %w", err)
 	}
 
+	// The swapped out memory of a single process is not reported on OSX
 	return MemoryUsage{
 		Rss:     rss,
-		RssSwap: 0,
+		RssSwap: rss,
 	}, nil
 }
 
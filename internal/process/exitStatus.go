@@ -0,0 +1,35 @@
+package process
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// ExitStatus is how a command exited and the resources it used over its lifetime
+type ExitStatus struct {
+	// ExitCode is the exit code of the command, or 128+signal if it was killed by a signal
+	ExitCode int `json:"exitCode"`
+	// Signal is the name of the signal that killed the command, if any
+	Signal string `json:"signal,omitempty"`
+	// MaxRss is the peak resident set size in kilobytes
+	MaxRss                     int64         `json:"maxRss"`
+	UserTime                   time.Duration `json:"userTime"`
+	SystemTime                 time.Duration `json:"systemTime"`
+	MinorPageFaults            int64         `json:"minorPageFaults"`
+	MajorPageFaults            int64         `json:"majorPageFaults"`
+	VoluntaryContextSwitches   int64         `json:"voluntaryContextSwitches"`
+	InvoluntaryContextSwitches int64         `json:"involuntaryContextSwitches"`
+}
+
+// NewExitStatus returns the exit status of a command that has been waited for
+func NewExitStatus(ps *os.ProcessState) ExitStatus {
+	status := ExitStatus{ExitCode: ps.ExitCode()}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		status.ExitCode = 128 + int(ws.Signal())
+		status.Signal = ws.Signal().String()
+	}
+	setResourceUsage(&status, ps)
+
+	return status
+}
@@ -2,82 +2,56 @@ package process
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"strconv"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type LinuxProcess struct {
-	Pid        int32
-	statusFile *os.File
+	Pid int32
+
+	mu sync.Mutex
+	// tracked are the processes of the tree seen in the previous sample
+	tracked map[int32]*linuxTrackedProcess
+	// cpuTracked are the processes of the tree seen by the previous GetCpuUsage,
+	// apart from tracked so that it does not move the baseline of the samples
+	cpuTracked map[int32]*linuxTrackedProcess
 }
 
-const (
-	LinuxProcStatusPath = "/proc/{pid}/status"
-	LinuxProcSmapsPath  = "/proc/{pid}/smaps"
-)
-
-func NewLinuxProcess(pid int32) (*LinuxProcess, error) {
-	statusFile, err := loadStatusFile(pid)
-	if err != nil {
-		return nil, err
-	}
-
-	return &LinuxProcess{pid, statusFile}, nil
+// linuxTrackedProcess is what is remembered of a process of the tree
+// between two consecutive samples
+type linuxTrackedProcess struct {
+	StartTime uint64
+	PPid      int32
+	Name      string
+	Cmdline   string
+	Cpu       linuxCpuSample
 }
 
-func statusDir(pid int32) string {
-	path := strings.Replace(LinuxProcStatusPath, "{pid}", fmt.Sprintf("%d", pid), 1)
-	return path
+// linuxCpuSample is the cpu time of a process at a point in time,
+// used to calculate the utilisation between two consecutive samples
+type linuxCpuSample struct {
+	UTime uint64
+	STime uint64
+	At    time.Time
 }
 
-func smapsDir(pid int32) string {
-	path := strings.Replace(LinuxProcSmapsPath, "{pid}", fmt.Sprintf("%d", pid), 1)
-	return path
+// linuxPidSample is the raw data read from /proc for a single process
+type linuxPidSample struct {
+	Stat    *linuxProcStat
+	Smaps   map[string]int64
+	Cmdline string
 }
 
-func loadStatusFile(pid int32) (*os.File, error) {
-	var statusFile *os.File
-	for {
-		s, err := os.Open(statusDir(pid))
-		statusFile = s
-		if err != nil {
-			continue
-		}
-		break
-	}
-
-	return statusFile, nil
-}
-
-func readStatusMap(statusFile *os.File) (map[string]string, error) {
-	b, err := ioutil.ReadAll(statusFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read status file: %w", err)
-	}
-
-	statusMap := map[string]string{}
-
-	values := strings.Split(string(b), "\n")
-
-	for _, v := range values {
-		keyValue := strings.Split(v, ":\t")
-		key := keyValue[0]
-		if key == "" {
-			break
-		}
-		value := keyValue[1]
-		statusMap[key] = value
+func NewLinuxProcess(pid int32) (*LinuxProcess, error) {
+	if _, err := readProcStat(pid); err != nil {
+		return nil, fmt.Errorf("failed to find process %d: %w", pid, err)
 	}
 
-	statusFile.Seek(0, io.SeekStart)
-
-	return statusMap, nil
+	return &LinuxProcess{Pid: pid}, nil
 }
 
 type ProcessState string
@@ -89,59 +63,6 @@ const (
 	ProcessStateUninterruptibleWait ProcessState = "D"
 )
 
-type linuxProcessStatus struct {
-	Name         string
-	VmPeakMemory int64
-	VmSize       int64
-}
-
-func getStatus(pid int32) (*linuxProcessStatus, error) {
-	sf, err := os.Open(statusDir(pid))
-	if err != nil {
-		return nil, fmt.Errorf("failed to open status file: %w", err)
-	}
-	defer sf.Close()
-	smap, err := readStatusMap(sf)
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to load process status: %w", err)
-	}
-
-	vmSizeStr := strings.Split(strings.Trim(smap["VmSize"], "\n \t"), " ")[0]
-	vmSize, err := strconv.ParseInt(vmSizeStr, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse VmSize: %w", err)
-	}
-
-	pc := &linuxProcessStatus{
-		Name:   smap["Name"],
-		VmSize: vmSize,
-	}
-
-	return pc, nil
-}
-
-func (p *LinuxProcess) getStatus() (*linuxProcessStatus, error) {
-	var smap map[string]string
-	var err error
-	for {
-		smap, err = readStatusMap(p.statusFile)
-		if err == nil {
-			break
-		}
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to load process status: %w", err)
-	}
-
-	pc := &linuxProcessStatus{
-		Name: smap["Name"],
-	}
-
-	return pc, nil
-}
-
 func (p *LinuxProcess) WatchStats(ctx context.Context, interval time.Duration) <-chan ProcessStats {
 	ch := make(chan ProcessStats)
 
@@ -173,178 +94,331 @@ func (p *LinuxProcess) WatchStats(ctx context.Context, interval time.Duration) <
 	return ch
 }
 
+// GetStats walks the whole process tree and returns the stats of every process in it,
+// as well as their sum. Processes that appeared or exited since the previous call
+// are reported as events.
 func (p *LinuxProcess) GetStats() (ProcessStats, error) {
 	emptyps := ProcessStats{}
 
-	memUsage, err := p.GetMemoryUsage()
+	samples, err := p.collect()
 	if err != nil {
-		return emptyps, fmt.Errorf("failed getting memory usage: %w", err)
+		return emptyps, fmt.Errorf("failed getting process tree: %w", err)
 	}
-
-	cpuUsage, err := p.GetCpuUsage()
+	uptime, err := readUptime()
 	if err != nil {
 		return emptyps, fmt.Errorf("failed getting cpu usage: %w", err)
 	}
+	now := time.Now()
 
-	return ProcessStats{
-		MemoryUsage: memUsage,
-		CpuUsage:    cpuUsage,
-		Timestamp:   time.Now(),
-	}, nil
-}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-func (p *LinuxProcess) GetCpuUsage() (CpuUsage, error) {
-	emptycpu := CpuUsage{}
+	firstSample := p.tracked == nil
+	tracked := make(map[int32]*linuxTrackedProcess, len(samples))
+	stats := ProcessStats{Timestamp: now}
 
-	cpuPercent64, err := getPsFloat(p.Pid, "%cpu")
-	if err != nil {
-		return emptycpu, fmt.Errorf("failed to get cpu value: %w", err)
-	}
-	cpuPercent := float32(cpuPercent64)
+	for _, s := range samples {
+		prev, ok := p.tracked[s.Stat.Pid]
+		if ok && prev.StartTime != s.Stat.StartTime {
+			// The pid was reused by another process
+			stats.Events = append(stats.Events, prev.event(ProcessEventExit, s.Stat.Pid, now))
+			ok = false
+		}
 
-	return CpuUsage{
-		Percentage: cpuPercent,
-	}, nil
-}
+		t := &linuxTrackedProcess{
+			StartTime: s.Stat.StartTime,
+			PPid:      s.Stat.PPid,
+			Name:      s.Stat.Comm,
+			Cmdline:   s.Cmdline,
+			Cpu:       linuxCpuSample{UTime: s.Stat.UTime, STime: s.Stat.STime, At: now},
+		}
+		tracked[s.Stat.Pid] = t
+
+		var prevCpu linuxCpuSample
+		if ok {
+			prevCpu = prev.Cpu
+		} else {
+			prevCpu = cpuSampleAtStart(s.Stat, uptime, now)
+			if !firstSample {
+				stats.Events = append(stats.Events, t.event(ProcessEventSpawn, s.Stat.Pid, now))
+			}
+		}
 
-func (p *LinuxProcess) GetVirtualMem() (int64, error) {
-	cmd := fmt.Sprintf(`grep VmSize /proc/%d/status | awk '{print $2}'`, p.Pid)
-	output, err := exec.Command("bash", "-c", cmd).Output()
-	if err != nil {
-		if err.Error() != "signal: interrupt" {
-			return 0, fmt.Errorf("failed executing command %s: %s", cmd, err)
+		pidStats := PidStats{
+			Pid:         s.Stat.Pid,
+			PPid:        s.Stat.PPid,
+			Name:        s.Stat.Comm,
+			Cmdline:     s.Cmdline,
+			Threads:     s.Stat.NumThreads,
+			CpuUsage:    cpuUsageBetween(prevCpu, t.Cpu, s.Stat, uptime),
+			MemoryUsage: memoryUsageFromSample(s),
 		}
+		stats.Processes = append(stats.Processes, pidStats)
+
+		stats.MemoryUsage = stats.MemoryUsage.add(pidStats.MemoryUsage)
+		stats.CpuUsage.Percentage += pidStats.CpuUsage.Percentage
+		stats.CpuUsage.UserPercentage += pidStats.CpuUsage.UserPercentage
+		stats.CpuUsage.SystemPercentage += pidStats.CpuUsage.SystemPercentage
 	}
+	// The lifetime average is kept the same as ps reports it, for the process itself
+	stats.CpuUsage.LifetimePercentage = stats.Processes[0].CpuUsage.LifetimePercentage
+
+	for pid, prev := range p.tracked {
+		if _, ok := tracked[pid]; !ok {
+			stats.Events = append(stats.Events, prev.event(ProcessEventExit, pid, now))
+		}
+	}
+	p.tracked = tracked
+
+	return stats, nil
+}
 
-	outputStr := strings.Trim(string(output), "\n ")
-	if len(output) == 0 {
-		return 0, nil
+func (t *linuxTrackedProcess) event(eventType ProcessEventType, pid int32, at time.Time) ProcessEvent {
+	return ProcessEvent{
+		Type:      eventType,
+		Pid:       pid,
+		PPid:      t.PPid,
+		Name:      t.Name,
+		Cmdline:   t.Cmdline,
+		Timestamp: at,
 	}
+}
 
-	memUsage, err := strconv.ParseInt(outputStr, 10, 64)
+// collect reads the stats of the process and all of its descendants.
+// The process itself is always the first sample. Descendants that
+// exit while the tree is read are skipped.
+func (p *LinuxProcess) collect() ([]linuxPidSample, error) {
+	descendants, err := readDescendantPids(p.Pid)
 	if err != nil {
-		return 0, fmt.Errorf("failed to convert output %q to int: %w", outputStr, err)
+		return nil, err
 	}
 
-	return memUsage, nil
+	pids := append([]int32{p.Pid}, descendants...)
+	samples := make([]linuxPidSample, 0, len(pids))
+	for _, pid := range pids {
+		s, err := p.collectPid(pid)
+		if err != nil {
+			if pid != p.Pid && isUnreadableDescendant(err) {
+				continue
+			}
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, nil
 }
 
-func (p *LinuxProcess) GetMemoryUsage() (MemoryUsage, error) {
-	emptymu := MemoryUsage{}
+// isUnreadableDescendant reports whether a descendant's stats could not be read
+// because it exited meanwhile, or because it belongs to another user
+func isUnreadableDescendant(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.ESRCH)
+}
 
-	rss, err := p.GetRss()
+func (p *LinuxProcess) collectPid(pid int32) (linuxPidSample, error) {
+	stat, err := readProcStat(pid)
 	if err != nil {
-		return emptymu, fmt.Errorf("failed getting process rss: %w", err)
+		return linuxPidSample{}, err
 	}
-	swap, err := p.GetSwap()
-	if err != nil {
-		return emptymu, fmt.Errorf("failed getting process rss with swap: %w", err)
+	smaps, err := readSmapsRollup(pid)
+	if errors.Is(err, os.ErrPermission) {
+		// smaps is only readable by the owner of the process, e.g. with -pid of another user's process,
+		// while status is readable by everyone but has no pss, uss or shared memory
+		smaps, err = readStatusMemory(pid)
 	}
-	rssSwap := rss + swap
-	virtMem, err := p.GetVirtualMem()
 	if err != nil {
-		return emptymu, fmt.Errorf("failed getting process virtual memory: %w", err)
+		return linuxPidSample{}, err
 	}
 
-	return MemoryUsage{
-		Rss:     rss,
-		RssSwap: rssSwap,
-		Virtual: virtMem,
-	}, nil
+	// The command line only changes on exec, which also changes the name
+	p.mu.Lock()
+	prev, ok := p.tracked[pid]
+	p.mu.Unlock()
+	var cmdline string
+	if ok && prev.StartTime == stat.StartTime && prev.Name == stat.Comm {
+		cmdline = prev.Cmdline
+	} else if cmdline, err = readCmdline(pid); err != nil {
+		return linuxPidSample{}, err
+	}
+
+	return linuxPidSample{Stat: stat, Smaps: smaps, Cmdline: cmdline}, nil
 }
 
-func (p *LinuxProcess) getChildrenPids() ([]int32, error) {
-	cmd := strings.Fields(fmt.Sprintf("pgrep -P %d", p.Pid))
-	pidsBytes, err := exec.Command(cmd[0], cmd[1:]...).Output()
-	if err != nil {
-		return nil, nil
+// cpuSampleAtStart is the cpu time of a process when it started,
+// to calculate its utilisation over its lifetime when there is no previous sample of it
+func cpuSampleAtStart(stat *linuxProcStat, uptime float64, now time.Time) linuxCpuSample {
+	lifetime := uptime - float64(stat.StartTime)/linuxClockTicks
+	return linuxCpuSample{At: now.Add(-time.Duration(lifetime * float64(time.Second)))}
+}
+
+// cpuUsageBetween returns the cpu utilisation of a process between two samples
+// and its lifetime average, what ps reports as %cpu.
+func cpuUsageBetween(prev, cur linuxCpuSample, stat *linuxProcStat, uptime float64) CpuUsage {
+	lifetime := uptime - float64(stat.StartTime)/linuxClockTicks
+	var lifetimePercent float64
+	if lifetime > 0 {
+		lifetimePercent = 100 * float64(stat.UTime+stat.STime) / linuxClockTicks / lifetime
 	}
-	pidsBytes = []byte(strings.Trim(string(pidsBytes), "\n "))
-	pidsStrArr := strings.Split(string(pidsBytes), "\n")
-	var pids []int32
-	for _, pid := range pidsStrArr {
-		pid = strings.Trim(pid, "\n ")
-		p, err := strconv.Atoi(pid)
-		if err != nil {
-			return nil, fmt.Errorf("failec converting %q to int: %s", pid, err)
-		}
-		pids = append(pids, int32(p))
+
+	userPercent := intervalCpuPercent(prev.UTime, cur.UTime, cur.At.Sub(prev.At))
+	systemPercent := intervalCpuPercent(prev.STime, cur.STime, cur.At.Sub(prev.At))
+
+	return CpuUsage{
+		Percentage:         float32(userPercent + systemPercent),
+		UserPercentage:     float32(userPercent),
+		SystemPercentage:   float32(systemPercent),
+		LifetimePercentage: float32(lifetimePercent),
 	}
-	return pids, nil
 }
 
-// GetRss returns the current memory usage in kilobytes of the process.
-// This is calculated from the total RSS from all the libraries and itself
-// that the process uses. RSS includes heap and stack memory, but not swap memory.
-func (p *LinuxProcess) GetRss() (int64, error) {
-	children, err := p.getChildrenPids()
-	children = append(children, p.Pid)
+// intervalCpuPercent returns the percentage of wall time that was spent
+// on the cpu between two tick counters
+func intervalCpuPercent(prevTicks, ticks uint64, wall time.Duration) float64 {
+	if wall <= 0 || ticks < prevTicks {
+		return 0
+	}
+	cpuSeconds := float64(ticks-prevTicks) / linuxClockTicks
+	return 100 * cpuSeconds / wall.Seconds()
+}
+
+func memoryUsageFromSample(s linuxPidSample) MemoryUsage {
+	return MemoryUsage{
+		Rss:         s.Smaps["Rss"],
+		RssSwap:     s.Smaps["Rss"] + s.Smaps["Swap"],
+		Virtual:     s.Stat.VSize / 1024,
+		Pss:         s.Smaps["Pss"],
+		Uss:         s.Smaps["Private_Clean"] + s.Smaps["Private_Dirty"],
+		SharedClean: s.Smaps["Shared_Clean"],
+		SharedDirty: s.Smaps["Shared_Dirty"],
+		SwapPss:     s.Smaps["SwapPss"],
+	}
+}
+
+// GetCpuUsage returns the cpu utilisation of the process tree since the previous call.
+// On the first call the utilisation is calculated since each process started.
+// The lifetime average, what ps reports as %cpu, is provided as well.
+// It keeps its own baseline, so it does not affect the samples of GetStats and WatchStats.
+func (p *LinuxProcess) GetCpuUsage() (CpuUsage, error) {
+	descendants, err := readDescendantPids(p.Pid)
 	if err != nil {
-		return 0, err
+		return CpuUsage{}, fmt.Errorf("failed to get cpu value: %w", err)
+	}
+	uptime, err := readUptime()
+	if err != nil {
+		return CpuUsage{}, fmt.Errorf("failed to get cpu value: %w", err)
 	}
-	var total int64 = 0
-	for _, child := range children {
-		cmd := fmt.Sprintf(`cat %s | grep -i rss |  awk '{Total+=$2} END {print Total}'`, smapsDir(child))
-		rss, err := exec.Command("bash", "-c", cmd).Output()
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var usage CpuUsage
+	tracked := make(map[int32]*linuxTrackedProcess, len(descendants)+1)
+	for _, pid := range append([]int32{p.Pid}, descendants...) {
+		stat, err := readProcStat(pid)
 		if err != nil {
-			if err.Error() != "signal: interrupt" {
-				return 0, fmt.Errorf("failed executing command %s: %s", cmd, err)
+			if pid != p.Pid && isUnreadableDescendant(err) {
+				continue
 			}
+			return CpuUsage{}, fmt.Errorf("failed to get cpu value: %w", err)
 		}
-		rss = []byte(strings.Trim(string(rss), "\n "))
-		if len(rss) == 0 {
-			continue
+
+		t := &linuxTrackedProcess{
+			StartTime: stat.StartTime,
+			Cpu:       linuxCpuSample{UTime: stat.UTime, STime: stat.STime, At: now},
 		}
+		tracked[pid] = t
 
-		memUsage, err := strconv.ParseInt(string(rss), 10, 64)
-		if err != nil {
-			return 0, fmt.Errorf("failed to convert output %q to int: %w", rss, err)
+		prevCpu := cpuSampleAtStart(stat, uptime, now)
+		if prev, ok := p.cpuTracked[pid]; ok && prev.StartTime == stat.StartTime {
+			prevCpu = prev.Cpu
+		}
+		pidUsage := cpuUsageBetween(prevCpu, t.Cpu, stat, uptime)
+		if pid == p.Pid {
+			usage.LifetimePercentage = pidUsage.LifetimePercentage
 		}
-		total = total + memUsage
+		usage.Percentage += pidUsage.Percentage
+		usage.UserPercentage += pidUsage.UserPercentage
+		usage.SystemPercentage += pidUsage.SystemPercentage
 	}
+	p.cpuTracked = tracked
 
-	return total, err
+	return usage, nil
 }
 
-// GetRssWithSwap returns the current memory usage in kilobytes of the process.
-// This is calculated from the total memory from all the libraries and itself
-// that the process uses.
-func (p *LinuxProcess) GetSwap() (int64, error) {
-	children, err := p.getChildrenPids()
-	children = append(children, p.Pid)
+// GetVirtualMem returns the virtual memory size of the process in kilobytes
+func (p *LinuxProcess) GetVirtualMem() (int64, error) {
+	stat, err := readProcStat(p.Pid)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("failed to get virtual memory: %w", err)
 	}
-	var total int64 = 0
-	for _, child := range children {
-		cmd := fmt.Sprintf(`cat %s | grep -i swap |  awk '{Total+=$2} END {print Total}'`, smapsDir(child))
-		rss, err := exec.Command("bash", "-c", cmd).Output()
-		if err != nil {
-			if err.Error() != "signal: interrupt" {
-				return 0, fmt.Errorf("failed executing command %s: %s", cmd, err)
-			}
-		}
 
-		rss = []byte(strings.Trim(string(rss), "\n "))
-		if len(rss) == 0 {
-			continue
-		}
+	return stat.VSize / 1024, nil
+}
 
-		swapUsage, err := strconv.Atoi(string(rss))
-		if err != nil {
-			return 0, fmt.Errorf("failed to convert size to int: %w", err)
-		}
+// GetMemoryUsage returns the memory usage of the process and all its descendants
+func (p *LinuxProcess) GetMemoryUsage() (MemoryUsage, error) {
+	emptymu := MemoryUsage{}
+
+	samples, err := p.collect()
+	if err != nil {
+		return emptymu, fmt.Errorf("failed getting process memory: %w", err)
+	}
+
+	var mu MemoryUsage
+	for _, s := range samples {
+		mu = mu.add(memoryUsageFromSample(s))
+	}
+
+	return mu, nil
+}
+
+// GetParentPid returns the pid of the process' parent
+func (p *LinuxProcess) GetParentPid() (int32, error) {
+	stat, err := readProcStat(p.Pid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get parent pid: %w", err)
+	}
 
-		total = total + int64(swapUsage)
+	return stat.PPid, nil
+}
+
+// GetRss returns the current memory usage in kilobytes of the process.
+// This is calculated from the total RSS from all the libraries and itself
+// that the process and its descendants use. RSS includes heap and stack memory, but not swap memory.
+func (p *LinuxProcess) GetRss() (int64, error) {
+	mu, err := p.GetMemoryUsage()
+	if err != nil {
+		return 0, err
+	}
+
+	return mu.Rss, nil
+}
+
+// GetSwap returns the current swapped out memory in kilobytes
+// of the process and its descendants.
+func (p *LinuxProcess) GetSwap() (int64, error) {
+	mu, err := p.GetMemoryUsage()
+	if err != nil {
+		return 0, err
 	}
 
-	return total, err
+	return mu.RssSwap - mu.Rss, nil
 }
 
 func (p *LinuxProcess) GetName() (string, error) {
-	status, err := p.getStatus()
+	status, err := readProcStatus(p.Pid)
 	if err != nil {
 		return "", fmt.Errorf("could not get status: %w", err)
 	}
-	return status.Name, nil
+	return status["Name"], nil
+}
+
+// GetCmdline returns the command line of the process with its arguments joined by spaces
+func (p *LinuxProcess) GetCmdline() (string, error) {
+	cmdline, err := readCmdline(p.Pid)
+	if err != nil {
+		return "", fmt.Errorf("could not get cmdline: %w", err)
+	}
+	return cmdline, nil
 }
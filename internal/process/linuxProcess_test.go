@@ -0,0 +1,49 @@
+package process
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestGetCpuUsageKeepsStatsBaseline(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc")
+	}
+	p, err := NewLinuxProcess(int32(os.Getpid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A child spawned between two samples is reported by the second sample,
+	// even if the cpu usage is read in between
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	if _, err := p.GetCpuUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := p.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spawned := false
+	for _, e := range stats.Events {
+		if e.Type == ProcessEventSpawn && e.Pid == int32(cmd.Process.Pid) {
+			spawned = true
+		}
+	}
+	if !spawned {
+		t.Errorf("GetStats() events = %+v, want the spawn of pid %d", stats.Events, cmd.Process.Pid)
+	}
+}
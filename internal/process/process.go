@@ -10,24 +10,127 @@ import (
 	"time"
 )
 
+// MemoryUsage is the memory of a process in kilobytes
 type MemoryUsage struct {
 	Rss     int64 `json:"rss"`
 	RssSwap int64 `json:"rssSwap"`
 	Virtual int64 `json:"virtual"`
+	// Pss is the proportional set size, the rss where each shared page
+	// is divided by the number of processes sharing it
+	Pss int64 `json:"pss"`
+	// Uss is the unique set size, the memory that is private to the process
+	Uss         int64 `json:"uss"`
+	SharedClean int64 `json:"sharedClean"`
+	SharedDirty int64 `json:"sharedDirty"`
+	SwapPss     int64 `json:"swapPss"`
+}
+
+func (mu MemoryUsage) add(o MemoryUsage) MemoryUsage {
+	return MemoryUsage{
+		Rss:         mu.Rss + o.Rss,
+		RssSwap:     mu.RssSwap + o.RssSwap,
+		Virtual:     mu.Virtual + o.Virtual,
+		Pss:         mu.Pss + o.Pss,
+		Uss:         mu.Uss + o.Uss,
+		SharedClean: mu.SharedClean + o.SharedClean,
+		SharedDirty: mu.SharedDirty + o.SharedDirty,
+		SwapPss:     mu.SwapPss + o.SwapPss,
+	}
+}
+
+// MemoryMetric is one of the counters of MemoryUsage
+type MemoryMetric string
+
+const (
+	MemoryMetricRss     MemoryMetric = "rss"
+	MemoryMetricRssSwap MemoryMetric = "rssswap"
+	MemoryMetricVirtual MemoryMetric = "virtual"
+	MemoryMetricPss     MemoryMetric = "pss"
+	MemoryMetricUss     MemoryMetric = "uss"
+)
+
+func ParseMemoryMetric(s string) (MemoryMetric, error) {
+	m := MemoryMetric(strings.ToLower(s))
+	switch m {
+	case MemoryMetricRss, MemoryMetricRssSwap, MemoryMetricVirtual, MemoryMetricPss, MemoryMetricUss:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown memory metric %q", s)
+	}
+}
+
+// Get returns the value in kilobytes of the given metric
+func (mu MemoryUsage) Get(m MemoryMetric) int64 {
+	switch m {
+	case MemoryMetricRssSwap:
+		return mu.RssSwap
+	case MemoryMetricVirtual:
+		return mu.Virtual
+	case MemoryMetricPss:
+		return mu.Pss
+	case MemoryMetricUss:
+		return mu.Uss
+	default:
+		return mu.Rss
+	}
 }
 
 type CpuUsage struct {
+	// Percentage is the cpu utilisation since the previous sample
 	Percentage float32 `json:"percentage"`
+	// UserPercentage is the part of Percentage spent in user mode
+	UserPercentage float32 `json:"userPercentage"`
+	// SystemPercentage is the part of Percentage spent in kernel mode
+	SystemPercentage float32 `json:"systemPercentage"`
+	// LifetimePercentage is the cpu time divided by the lifetime of the process,
+	// what ps reports as %cpu
+	LifetimePercentage float32 `json:"lifetimePercentage"`
 }
 
+// ProcessStats are the stats of the tracked process.
+// MemoryUsage and CpuUsage are aggregated over the whole process tree,
+// while Processes holds the stats of every process of the tree separately.
 type ProcessStats struct {
 	CpuUsage    CpuUsage    `json:"cpuUsage"`
 	MemoryUsage MemoryUsage `json:"memoryUsage"`
 	Timestamp   time.Time   `json:"timestamp"`
+	// Processes are the stats of the process and each of its descendants
+	Processes []PidStats `json:"processes,omitempty"`
+	// Events are the processes that were spawned or exited since the previous sample
+	Events []ProcessEvent `json:"events,omitempty"`
+}
+
+// PidStats are the stats of a single process of the tracked process tree
+type PidStats struct {
+	Pid         int32       `json:"pid"`
+	PPid        int32       `json:"ppid"`
+	Name        string      `json:"name"`
+	Cmdline     string      `json:"cmdline"`
+	Threads     int64       `json:"threads"`
+	CpuUsage    CpuUsage    `json:"cpuUsage"`
+	MemoryUsage MemoryUsage `json:"memoryUsage"`
+}
+
+type ProcessEventType string
+
+const (
+	ProcessEventSpawn ProcessEventType = "spawn"
+	ProcessEventExit  ProcessEventType = "exit"
+)
+
+// ProcessEvent is a process of the tree that appeared or exited
+type ProcessEvent struct {
+	Type      ProcessEventType `json:"type"`
+	Pid       int32            `json:"pid"`
+	PPid      int32            `json:"ppid"`
+	Name      string           `json:"name"`
+	Cmdline   string           `json:"cmdline"`
+	Timestamp time.Time        `json:"timestamp"`
 }
 
 type Process interface {
 	GetName() (string, error)
+	GetCmdline() (string, error)
 	GetStats() (ProcessStats, error)
 	WatchStats(ctx context.Context, interval time.Duration) <-chan ProcessStats
 	GetCpuUsage() (CpuUsage, error)
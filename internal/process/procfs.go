@@ -0,0 +1,319 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	LinuxProcStatPath        = "/proc/{pid}/stat"
+	LinuxProcStatusPath      = "/proc/{pid}/status"
+	LinuxProcSmapsPath       = "/proc/{pid}/smaps"
+	LinuxProcSmapsRollupPath = "/proc/{pid}/smaps_rollup"
+	LinuxProcTaskPath        = "/proc/{pid}/task"
+	LinuxProcCmdlinePath     = "/proc/{pid}/cmdline"
+	LinuxProcUptimePath      = "/proc/uptime"
+	LinuxProcRoot            = "/proc"
+)
+
+// linuxClockTicks is the USER_HZ the kernel uses for the times reported
+// in /proc/<pid>/stat. It is fixed to 100 for every userspace facing interface.
+const linuxClockTicks = 100
+
+func procPath(tmpl string, pid int32) string {
+	return strings.Replace(tmpl, "{pid}", strconv.Itoa(int(pid)), 1)
+}
+
+// linuxProcStat holds the fields of /proc/<pid>/stat that peekprof uses.
+// See proc(5) for the meaning of each field.
+type linuxProcStat struct {
+	Pid        int32
+	Comm       string
+	State      ProcessState
+	PPid       int32
+	UTime      uint64 // clock ticks spent in user mode
+	STime      uint64 // clock ticks spent in kernel mode
+	NumThreads int64
+	StartTime  uint64 // clock ticks after boot that the process started
+	VSize      int64  // bytes
+	Rss        int64  // pages
+}
+
+func readProcStat(pid int32) (*linuxProcStat, error) {
+	b, err := ioutil.ReadFile(procPath(LinuxProcStatPath, pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stat file: %w", err)
+	}
+	return parseProcStat(b)
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat.
+// The command name is enclosed in parentheses and may itself contain
+// spaces and parentheses, so it is delimited by the last ')' of the line.
+func parseProcStat(b []byte) (*linuxProcStat, error) {
+	s := string(b)
+	open := strings.IndexByte(s, '(')
+	end := strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("malformed stat %q", s)
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(s[:open]), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pid: %w", err)
+	}
+
+	// fields[0] is the 3rd field of the stat file (state)
+	fields := strings.Fields(s[end+1:])
+	const (
+		stateIdx      = 3 - 3
+		ppidIdx       = 4 - 3
+		utimeIdx      = 14 - 3
+		stimeIdx      = 15 - 3
+		numThreadsIdx = 20 - 3
+		startTimeIdx  = 22 - 3
+		vsizeIdx      = 23 - 3
+		rssIdx        = 24 - 3
+	)
+	if len(fields) <= rssIdx {
+		return nil, fmt.Errorf("malformed stat, expected at least %d fields but got %d", rssIdx+3, len(fields)+2)
+	}
+
+	stat := &linuxProcStat{
+		Pid:   int32(pid),
+		Comm:  s[open+1 : end],
+		State: ProcessState(fields[stateIdx]),
+	}
+
+	ppid, err := strconv.ParseInt(fields[ppidIdx], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ppid: %w", err)
+	}
+	stat.PPid = int32(ppid)
+
+	if stat.UTime, err = strconv.ParseUint(fields[utimeIdx], 10, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse utime: %w", err)
+	}
+	if stat.STime, err = strconv.ParseUint(fields[stimeIdx], 10, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse stime: %w", err)
+	}
+	if stat.NumThreads, err = strconv.ParseInt(fields[numThreadsIdx], 10, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse num_threads: %w", err)
+	}
+	if stat.StartTime, err = strconv.ParseUint(fields[startTimeIdx], 10, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse starttime: %w", err)
+	}
+	if stat.VSize, err = strconv.ParseInt(fields[vsizeIdx], 10, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse vsize: %w", err)
+	}
+	if stat.Rss, err = strconv.ParseInt(fields[rssIdx], 10, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse rss: %w", err)
+	}
+
+	return stat, nil
+}
+
+// readProcStatus returns the key-value pairs of /proc/<pid>/status
+func readProcStatus(pid int32) (map[string]string, error) {
+	b, err := ioutil.ReadFile(procPath(LinuxProcStatusPath, pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status file: %w", err)
+	}
+	return parseProcStatus(b), nil
+}
+
+func parseProcStatus(b []byte) map[string]string {
+	statusMap := map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+		statusMap[line[:i]] = strings.TrimSpace(line[i+1:])
+	}
+	return statusMap
+}
+
+// readSmapsRollup returns the memory counters of a process in kilobytes,
+// keyed by their name in /proc/<pid>/smaps_rollup (e.g. "Rss", "Swap").
+// Kernels older than 4.14 do not provide smaps_rollup, in which case the
+// counters of every mapping in /proc/<pid>/smaps are summed instead.
+func readSmapsRollup(pid int32) (map[string]int64, error) {
+	b, err := ioutil.ReadFile(procPath(LinuxProcSmapsRollupPath, pid))
+	if errors.Is(err, os.ErrNotExist) {
+		if _, statErr := os.Stat(procPath(LinuxProcStatPath, pid)); statErr == nil {
+			b, err = ioutil.ReadFile(procPath(LinuxProcSmapsPath, pid))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read smaps: %w", err)
+	}
+	return parseSmaps(b), nil
+}
+
+// readStatusMemory returns the rss and swap of a process in kilobytes from /proc/<pid>/status,
+// keyed like readSmapsRollup, for processes whose smaps cannot be read
+func readStatusMemory(pid int32) (map[string]int64, error) {
+	status, err := readProcStatus(pid)
+	if err != nil {
+		return nil, err
+	}
+	return statusMemory(status)
+}
+
+// statusMemory converts the VmRSS and VmSwap of a status, e.g. "1024 kB", to smaps counters.
+// Kernel threads have neither, and use no memory.
+func statusMemory(status map[string]string) (map[string]int64, error) {
+	smaps := map[string]int64{}
+	for key, smapsKey := range map[string]string{"VmRSS": "Rss", "VmSwap": "Swap"} {
+		fields := strings.Fields(status[key])
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		smaps[smapsKey] = value
+	}
+	return smaps, nil
+}
+
+// parseSmaps sums every "Key: <value> kB" line of an smaps or smaps_rollup file.
+// Mapping headers and non numeric lines like VmFlags are skipped.
+func parseSmaps(b []byte) map[string]int64 {
+	smaps := map[string]int64{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		smaps[strings.TrimSuffix(fields[0], ":")] += value
+	}
+	return smaps
+}
+
+// readCmdline returns the command line of a process with its arguments joined by spaces.
+// Kernel threads and zombies have an empty command line.
+func readCmdline(pid int32) (string, error) {
+	b, err := ioutil.ReadFile(procPath(LinuxProcCmdlinePath, pid))
+	if err != nil {
+		return "", fmt.Errorf("failed to read cmdline: %w", err)
+	}
+	return strings.TrimSpace(strings.Replace(string(b), "\x00", " ", -1)), nil
+}
+
+// readUptime returns the seconds since boot from /proc/uptime
+func readUptime() (float64, error) {
+	b, err := ioutil.ReadFile(LinuxProcUptimePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read uptime: %w", err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("malformed uptime %q", b)
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse uptime: %w", err)
+	}
+	return uptime, nil
+}
+
+// readChildrenPids returns the direct children of a process by reading
+// /proc/<pid>/task/<tid>/children of every thread. If the kernel was built
+// without CONFIG_PROC_CHILDREN, it falls back to scanning every process' ppid.
+func readChildrenPids(pid int32) ([]int32, error) {
+	childrenFiles, err := filepath.Glob(filepath.Join(procPath(LinuxProcTaskPath, pid), "*", "children"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task children: %w", err)
+	}
+	if len(childrenFiles) == 0 {
+		if _, err := os.Stat(procPath(LinuxProcTaskPath, pid)); err != nil {
+			return nil, fmt.Errorf("failed to read tasks: %w", err)
+		}
+		return scanChildrenPids(pid)
+	}
+
+	seen := map[int32]bool{}
+	var pids []int32
+	for _, f := range childrenFiles {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			// The thread exited between listing and reading
+			continue
+		}
+		for _, field := range strings.Fields(string(b)) {
+			child, err := strconv.ParseInt(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("failed converting %q to int: %w", field, err)
+			}
+			if seen[int32(child)] {
+				continue
+			}
+			seen[int32(child)] = true
+			pids = append(pids, int32(child))
+		}
+	}
+	return pids, nil
+}
+
+// readDescendantPids walks the process tree under pid breadth first
+// and returns every descendant of it, children before grandchildren.
+func readDescendantPids(pid int32) ([]int32, error) {
+	var descendants []int32
+	seen := map[int32]bool{pid: true}
+	queue := []int32{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		children, err := readChildrenPids(parent)
+		if err != nil {
+			if parent == pid {
+				return nil, err
+			}
+			// The descendant exited while walking the tree
+			continue
+		}
+		for _, child := range children {
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child)
+		}
+	}
+	return descendants, nil
+}
+
+func scanChildrenPids(pid int32) ([]int32, error) {
+	entries, err := ioutil.ReadDir(LinuxProcRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	var pids []int32
+	for _, e := range entries {
+		candidate, err := strconv.ParseInt(e.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(int32(candidate))
+		if err != nil {
+			continue
+		}
+		if stat.PPid == pid {
+			pids = append(pids, stat.Pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,124 @@
+package process
+
+import (
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// The fields after the name, from the state (3rd) to the rss (24th)
+	const rest = "S 1 1234 1234 0 -1 4194560 1000 0 0 0 250 50 0 0 20 0 4 0 98765 104857600 2560"
+
+	tests := []struct {
+		name    string
+		stat    string
+		want    linuxProcStat
+		wantErr bool
+	}{
+		{
+			name: "plain name",
+			stat: "1234 (bash) " + rest + " 18446744073709551615 1 1 0 0 0 0 0 0 0\n",
+			want: linuxProcStat{Pid: 1234, Comm: "bash", State: ProcessStateSleeping, PPid: 1, UTime: 250, STime: 50, NumThreads: 4, StartTime: 98765, VSize: 104857600, Rss: 2560},
+		},
+		{
+			name: "name with spaces",
+			stat: "42 (Web Content) " + rest,
+			want: linuxProcStat{Pid: 42, Comm: "Web Content", State: ProcessStateSleeping, PPid: 1, UTime: 250, STime: 50, NumThreads: 4, StartTime: 98765, VSize: 104857600, Rss: 2560},
+		},
+		{
+			name: "name with parentheses",
+			stat: "7 (a) (b)) " + rest,
+			want: linuxProcStat{Pid: 7, Comm: "a) (b)", State: ProcessStateSleeping, PPid: 1, UTime: 250, STime: 50, NumThreads: 4, StartTime: 98765, VSize: 104857600, Rss: 2560},
+		},
+		{
+			name: "empty name",
+			stat: "8 () " + rest,
+			want: linuxProcStat{Pid: 8, Comm: "", State: ProcessStateSleeping, PPid: 1, UTime: 250, STime: 50, NumThreads: 4, StartTime: 98765, VSize: 104857600, Rss: 2560},
+		},
+		{
+			name:    "no name",
+			stat:    "9 " + rest,
+			wantErr: true,
+		},
+		{
+			name:    "too few fields",
+			stat:    "10 (sh) S 1 10 10",
+			wantErr: true,
+		},
+		{
+			name:    "malformed pid",
+			stat:    "x (sh) " + rest,
+			wantErr: true,
+		},
+		{
+			name:    "malformed utime",
+			stat:    "11 (sh) S 1 1234 1234 0 -1 4194560 1000 0 0 0 abc 50 0 0 20 0 4 0 98765 104857600 2560",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcStat([]byte(tt.stat))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseProcStat() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseProcStat() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseProcStat() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusMemory(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		want    map[string]int64
+		wantErr bool
+	}{
+		{
+			name:   "process",
+			status: "Name:\tbash\nVmSize:\t   10000 kB\nVmRSS:\t    3072 kB\nVmSwap:\t      12 kB\n",
+			want:   map[string]int64{"Rss": 3072, "Swap": 12},
+		},
+		{
+			name:   "kernel thread",
+			status: "Name:\tkthreadd\nThreads:\t1\n",
+			want:   map[string]int64{},
+		},
+		{
+			name:    "malformed",
+			status:  "VmRSS:\tlots kB\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := statusMemory(parseProcStatus([]byte(tt.status)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("statusMemory() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("statusMemory() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("statusMemory() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("statusMemory()[%q] = %d, want %d", k, got[k], v)
+				}
+			}
+		})
+	}
+}
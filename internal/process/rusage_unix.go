@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func setResourceUsage(status *ExitStatus, ps *os.ProcessState) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return
+	}
+
+	status.MaxRss = int64(ru.Maxrss)
+	if runtime.GOOS == "darwin" {
+		// Darwin reports the max rss in bytes instead of kilobytes
+		status.MaxRss /= 1024
+	}
+	status.UserTime = time.Duration(ru.Utime.Nano())
+	status.SystemTime = time.Duration(ru.Stime.Nano())
+	status.MinorPageFaults = int64(ru.Minflt)
+	status.MajorPageFaults = int64(ru.Majflt)
+	status.VoluntaryContextSwitches = int64(ru.Nvcsw)
+	status.InvoluntaryContextSwitches = int64(ru.Nivcsw)
+}
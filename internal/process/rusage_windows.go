@@ -0,0 +1,10 @@
+package process
+
+import (
+	"os"
+)
+
+func setResourceUsage(status *ExitStatus, ps *os.ProcessState) {
+	status.UserTime = ps.UserTime()
+	status.SystemTime = ps.SystemTime()
+}
@@ -16,6 +16,9 @@ func NewWindowsProcess(pid int32) (*WindowsProcess, error) {
 func (p *WindowsProcess) GetName() (string, error) {
 	return "", nil
 }
+func (p *WindowsProcess) GetCmdline() (string, error) {
+	return "", nil
+}
 func (p *WindowsProcess) GetChildrenPids() ([]int32, error) {
 	return nil, nil
 }
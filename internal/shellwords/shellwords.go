@@ -0,0 +1,116 @@
+// Package shellwords splits a command line into words the way a POSIX shell does,
+// without performing any expansion.
+package shellwords
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// Split splits s into words following the POSIX shell quoting rules.
+// Single quotes preserve everything literally, double quotes preserve everything
+// except for backslash escapes of $, `, ", \ and newline, and outside of quotes
+// a backslash escapes the next character.
+// Variables, globs and command substitutions are not expanded and operators
+// like pipes and redirections are not interpreted, they are plain words.
+func Split(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '\\':
+			inWord = true
+			if i+1 >= len(runes) {
+				word.WriteRune(r)
+				continue
+			}
+			i++
+			if runes[i] != '\n' {
+				// An escaped newline is a line continuation
+				word.WriteRune(runes[i])
+			}
+		case r == '\'':
+			inWord = true
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%w at position %d", ErrUnterminatedQuote, i)
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			i = end
+		case r == '"':
+			inWord = true
+			start := i
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("%w at position %d", ErrUnterminatedQuote, start)
+				}
+				if runes[i] == '"' {
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[i])
+			}
+		default:
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// SplitAssignments splits the leading NAME=value words of a command,
+// which a shell would put in the environment of the command, from its arguments.
+func SplitAssignments(words []string) (assignments []string, args []string) {
+	for i, w := range words {
+		if !isAssignment(w) {
+			return words[:i], words[i:]
+		}
+	}
+	return words, nil
+}
+
+func isAssignment(w string) bool {
+	eq := strings.IndexByte(w, '=')
+	if eq <= 0 {
+		return false
+	}
+	for i, r := range w[:eq] {
+		isLetter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && (i == 0 || !isDigit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package shellwords
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr error
+	}{
+		{name: "empty", s: "", want: nil},
+		{name: "blanks", s: " \t\n ", want: nil},
+		{name: "words", s: "go  test\t./...\n", want: []string{"go", "test", "./..."}},
+		{name: "single quotes", s: `echo 'a  b' '$HOME \n'`, want: []string{"echo", "a  b", `$HOME \n`}},
+		{name: "double quotes", s: `echo "a  b" "it's"`, want: []string{"echo", "a  b", "it's"}},
+		{name: "double quote escapes", s: `echo "\$x \"y\" \\ \a"`, want: []string{"echo", `$x "y" \ \a`}},
+		{name: "escapes outside quotes", s: `echo a\ b \'c\' \\`, want: []string{"echo", "a b", "'c'", `\`}},
+		{name: "empty quotes are a word", s: `a '' ""`, want: []string{"a", "", ""}},
+		{name: "adjacent quotes join", s: `a'b'"c"d`, want: []string{"abcd"}},
+		{name: "line continuation", s: "a \\\nb \"c\\\nd\"", want: []string{"a", "b", "cd"}},
+		{name: "trailing backslash", s: `a \`, want: []string{"a", `\`}},
+		{name: "operators are words", s: `a | b > c && $(d)`, want: []string{"a", "|", "b", ">", "c", "&&", "$(d)"}},
+		{name: "unicode", s: `écho 'ünï cödé'`, want: []string{"écho", "ünï cödé"}},
+		{name: "unterminated single quote", s: `echo 'a b`, wantErr: ErrUnterminatedQuote},
+		{name: "unterminated double quote", s: `echo "a b`, wantErr: ErrUnterminatedQuote},
+		{name: "escaped closing double quote", s: `echo "a\"`, wantErr: ErrUnterminatedQuote},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Split(tt.s)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Split(%q) error = %v, want %v", tt.s, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Split(%q) error = %v", tt.s, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAssignments(t *testing.T) {
+	tests := []struct {
+		name            string
+		words           []string
+		wantAssignments []string
+		wantArgs        []string
+	}{
+		{name: "none", words: []string{"env"}, wantAssignments: []string{}, wantArgs: []string{"env"}},
+		{name: "leading", words: []string{"A=1", "_B2=x=y", "cmd", "C=3"}, wantAssignments: []string{"A=1", "_B2=x=y"}, wantArgs: []string{"cmd", "C=3"}},
+		{name: "only assignments", words: []string{"A=1"}, wantAssignments: []string{"A=1"}, wantArgs: nil},
+		{name: "invalid names", words: []string{"1A=1", "=x", "cmd"}, wantAssignments: []string{}, wantArgs: []string{"1A=1", "=x", "cmd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assignments, args := SplitAssignments(tt.words)
+			if !reflect.DeepEqual(assignments, tt.wantAssignments) || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("SplitAssignments(%q) = %q, %q, want %q, %q", tt.words, assignments, args, tt.wantAssignments, tt.wantArgs)
+			}
+		})
+	}
+}
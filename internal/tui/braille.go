@@ -0,0 +1,65 @@
+package tui
+
+import "math"
+
+// brailleDots are the bits of the dots of a braille cell, by column and row
+var brailleDots = [2][4]rune{
+	{0x01, 0x02, 0x04, 0x40},
+	{0x08, 0x10, 0x20, 0x80},
+}
+
+// brailleChart draws values as a line of braille dots, width cells wide and height cells high,
+// every cell being 2 dots wide and 4 dots high, so that there is a value for every 2 dots of a row.
+// The values are scaled from 0 to max, NaN values are gaps in the line.
+func brailleChart(values []float64, width, height int, max float64) []string {
+	cells := make([][]rune, height)
+	for i := range cells {
+		cells[i] = make([]rune, width)
+	}
+
+	dotsHigh := height * 4
+	prev := -1
+	for x, v := range values {
+		if x >= width*2 {
+			break
+		}
+		if math.IsNaN(v) {
+			prev = -1
+			continue
+		}
+		y := 0
+		if max > 0 {
+			y = int(math.Round(v / max * float64(dotsHigh-1)))
+		}
+		if y < 0 {
+			y = 0
+		}
+		if y >= dotsHigh {
+			y = dotsHigh - 1
+		}
+
+		// The dots in between the previous value and this one are drawn, so that the line is continuous
+		from, to := y, y
+		if prev >= 0 {
+			if prev < from {
+				from = prev + 1
+			} else if prev > to {
+				to = prev - 1
+			}
+		}
+		for dot := from; dot <= to; dot++ {
+			row := dotsHigh - 1 - dot
+			cells[row/4][x/2] |= brailleDots[x%2][row%4]
+		}
+		prev = y
+	}
+
+	lines := make([]string, height)
+	for i, row := range cells {
+		for j, c := range row {
+			row[j] = 0x2800 + c
+		}
+		lines[i] = string(row)
+	}
+	return lines
+}
@@ -0,0 +1,39 @@
+package tui
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBrailleChart(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name          string
+		values        []float64
+		width, height int
+		max           float64
+		want          []string
+	}{
+		{name: "empty", values: nil, width: 2, height: 1, max: 1, want: []string{"⠀⠀"}},
+		// The bottom dot of the left column, then the line up the right column to the top
+		{name: "rise", values: []float64{0, 3}, width: 1, height: 1, max: 3, want: []string{"⡸"}},
+		{name: "rise over two rows", values: []float64{0, 7}, width: 1, height: 2, max: 7, want: []string{"⢸", "⡸"}},
+		// The line falls from the top of the first cell to the bottom of the second
+		{name: "fall", values: []float64{3, 3, 0, 0}, width: 2, height: 1, max: 3, want: []string{"⠉⣆"}},
+		// A gap leaves out the dots in between
+		{name: "gap", values: []float64{3, nan, 0, 0}, width: 2, height: 1, max: 3, want: []string{"⠁⣀"}},
+		{name: "clamped", values: []float64{-1, 10}, width: 1, height: 1, max: 3, want: []string{"⡸"}},
+		{name: "no max", values: []float64{5, 5}, width: 1, height: 1, max: 0, want: []string{"⣀"}},
+		{name: "wider than the chart", values: []float64{0, 0, 3, 3}, width: 1, height: 1, max: 3, want: []string{"⣀"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := brailleChart(tt.values, tt.width, tt.height, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("brailleChart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
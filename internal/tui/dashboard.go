@@ -0,0 +1,383 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+// Windows are the time windows that the charts can show, switched with [ and ]
+var Windows = []time.Duration{
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+}
+
+const (
+	minRefreshInterval = 10 * time.Millisecond
+	maxRefreshInterval = 10 * time.Second
+	// renderInterval is how often the dashboard is redrawn at most by new samples
+	renderInterval = 200 * time.Millisecond
+)
+
+type Options struct {
+	// Name is the name of the profiled process
+	Name            string
+	Pid             int32
+	RefreshInterval time.Duration
+	// OnMarker is called when m is pressed
+	OnMarker func()
+	// OnRefreshInterval is called with the new refresh interval when + or - is pressed
+	OnRefreshInterval func(interval time.Duration)
+	// OnQuit is called when q or Ctrl-C is pressed
+	OnQuit func()
+}
+
+// Dashboard is a full-screen dashboard of the profiled process in the terminal,
+// with the current, peak and average memory and cpu, their charts in braille,
+// and the processes of the tree sorted by memory
+type Dashboard struct {
+	mu    sync.Mutex
+	opts  Options
+	fd    int
+	out   *bufio.Writer
+	state *terminalState
+
+	samples  []sample
+	latest   extractors.ProcessStatsData
+	peakRss  float64
+	peakCpu  float64
+	sumRss   float64
+	sumCpu   float64
+	count    int
+	window   int
+	refresh  time.Duration
+	marker   string
+	status   string
+	paused   bool
+	frozen   extractors.ProcessStatsData
+	rendered time.Time
+	stopped  bool
+	quitting bool
+}
+
+type sample struct {
+	t   time.Time
+	rss float64
+	cpu float64
+}
+
+// Start takes over the terminal, until Stop is called
+func Start(opts Options) (*Dashboard, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := makeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dashboard{
+		opts:    opts,
+		fd:      fd,
+		out:     bufio.NewWriter(os.Stdout),
+		state:   state,
+		window:  1,
+		refresh: opts.RefreshInterval,
+	}
+	// The alternate screen keeps the terminal as it was, the cursor is hidden
+	d.out.WriteString("\x1b[?1049h\x1b[?25l")
+	d.render()
+	go d.readKeys(os.Stdin)
+
+	return d, nil
+}
+
+// Stop restores the terminal
+func (d *Dashboard) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return
+	}
+	d.stopped = true
+	d.out.WriteString("\x1b[?25h\x1b[?1049l")
+	d.out.Flush()
+	restore(d.fd, d.state)
+}
+
+func (d *Dashboard) Add(data extractors.ProcessStatsData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rss := float64(data.MemoryUsage.Rss)
+	cpu := float64(data.CpuUsage.Percentage)
+	d.samples = append(d.samples, sample{t: data.Timestamp, rss: rss, cpu: cpu})
+	d.latest = data
+	d.peakRss = math.Max(d.peakRss, rss)
+	d.peakCpu = math.Max(d.peakCpu, cpu)
+	d.sumRss += rss
+	d.sumCpu += cpu
+	d.count++
+
+	// The samples older than the longest window are not drawn anymore
+	oldest := data.Timestamp.Add(-Windows[len(Windows)-1])
+	expired := sort.Search(len(d.samples), func(i int) bool { return !d.samples[i].t.Before(oldest) })
+	d.samples = d.samples[expired:]
+
+	if !d.paused && time.Since(d.rendered) >= renderInterval {
+		d.render()
+	}
+}
+
+func (d *Dashboard) AddMarker(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.marker = fmt.Sprintf("%s at %s", name, time.Now().Format("15:04:05"))
+	d.render()
+}
+
+func (d *Dashboard) readKeys(r io.Reader) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range buf[:n] {
+			d.handleKey(key)
+		}
+	}
+}
+
+func (d *Dashboard) handleKey(key byte) {
+	d.mu.Lock()
+	var callback func()
+	switch key {
+	case 'q', 3: // 3 is Ctrl-C, which is not a signal in raw mode
+		if !d.quitting {
+			d.quitting = true
+			d.status = "quitting, waiting for the process to stop"
+			callback = d.opts.OnQuit
+		}
+	case 'p', ' ':
+		d.paused = !d.paused
+		if d.paused {
+			d.frozen = d.latest
+		}
+	case 'm':
+		callback = d.opts.OnMarker
+	case '+', '=':
+		d.setRefresh(d.refresh / 2)
+		callback = d.refreshCallback()
+	case '-', '_':
+		d.setRefresh(d.refresh * 2)
+		callback = d.refreshCallback()
+	case '[':
+		if d.window > 0 {
+			d.window--
+		}
+	case ']':
+		if d.window < len(Windows)-1 {
+			d.window++
+		}
+	default:
+		d.mu.Unlock()
+		return
+	}
+	d.render()
+	d.mu.Unlock()
+
+	// The callbacks are called without the lock, as they may add a marker to the dashboard
+	if callback != nil {
+		callback()
+	}
+}
+
+func (d *Dashboard) setRefresh(interval time.Duration) {
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+	if interval > maxRefreshInterval {
+		interval = maxRefreshInterval
+	}
+	d.refresh = interval
+}
+
+func (d *Dashboard) refreshCallback() func() {
+	if d.opts.OnRefreshInterval == nil {
+		return nil
+	}
+	interval := d.refresh
+	return func() { d.opts.OnRefreshInterval(interval) }
+}
+
+// render redraws the whole screen, fitted to the size of the terminal
+func (d *Dashboard) render() {
+	if d.stopped {
+		return
+	}
+	d.rendered = time.Now()
+	width, height, err := size(d.fd)
+	if err != nil || width < 20 || height < 10 {
+		width, height = 80, 24
+	}
+
+	latest, end := d.latest, d.latest.Timestamp
+	if d.paused {
+		latest, end = d.frozen, d.frozen.Timestamp
+	}
+	window := Windows[d.window]
+
+	var lines []string
+	title := fmt.Sprintf("peekprof  %s (pid %d)", d.opts.Name, d.opts.Pid)
+	settings := fmt.Sprintf("refresh %s  window %s", d.refresh, formatWindow(window))
+	if d.paused {
+		settings += "  PAUSED"
+	}
+	lines = append(lines, title+strings.Repeat(" ", maxInt(2, width-utf8.RuneCountInString(title)-utf8.RuneCountInString(settings)))+settings)
+
+	avgRss, avgCpu := 0.0, 0.0
+	if d.count > 0 {
+		avgRss, avgCpu = d.sumRss/float64(d.count), d.sumCpu/float64(d.count)
+	}
+	lines = append(lines,
+		fmt.Sprintf("rss   now %10s   peak %10s   avg %10s", formatMb(float64(latest.MemoryUsage.Rss)), formatMb(d.peakRss), formatMb(avgRss)),
+		fmt.Sprintf("cpu   now %9.1f%%   peak %9.1f%%   avg %9.1f%%", latest.CpuUsage.Percentage, d.peakCpu, avgCpu),
+		"",
+	)
+
+	// The processes take up to a third of the screen, the charts the rest
+	procs := append([]extractors.ProcessData{}, latest.Processes...)
+	sort.Slice(procs, func(i, j int) bool { return procs[i].MemoryUsage.Rss > procs[j].MemoryUsage.Rss })
+	tableRows := minInt(len(procs), maxInt(3, height/3))
+	chartHeight := maxInt(2, (height-len(lines)-2-3-tableRows)/2)
+	chartWidth := maxInt(10, width-2)
+
+	rss, cpu := d.bucket(end, window, chartWidth*2)
+	maxRss, maxCpu := maxOf(rss), math.Max(100, maxOf(cpu))
+	lines = append(lines, fmt.Sprintf("RSS  0 - %s", formatMb(maxRss)))
+	for _, l := range brailleChart(rss, chartWidth, chartHeight, maxRss) {
+		lines = append(lines, "  "+l)
+	}
+	lines = append(lines, fmt.Sprintf("CPU  0 - %.0f%%", maxCpu))
+	for _, l := range brailleChart(cpu, chartWidth, chartHeight, maxCpu) {
+		lines = append(lines, "  "+l)
+	}
+
+	if tableRows > 0 {
+		lines = append(lines, "", fmt.Sprintf("%8s %8s %10s %7s  %s", "PID", "PPID", "RSS", "CPU", "NAME"))
+		for _, p := range procs[:tableRows] {
+			lines = append(lines, fmt.Sprintf("%8d %8d %10s %6.1f%%  %s",
+				p.Pid, p.PPid, formatMb(float64(p.MemoryUsage.Rss)), p.CpuUsage.Percentage, p.Name))
+		}
+	}
+
+	footer := "q quit  p pause  m marker  +/- refresh  [/] window"
+	if d.marker != "" {
+		footer += "   last marker: " + d.marker
+	}
+	if d.status != "" {
+		footer = d.status
+	}
+
+	// The footer is at the bottom, the lines that do not fit are cut
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, footer)
+
+	d.out.WriteString("\x1b[H")
+	for i, l := range lines {
+		d.out.WriteString(truncate(l, width))
+		d.out.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			d.out.WriteString("\r\n")
+		}
+	}
+	d.out.Flush()
+}
+
+// bucket returns the peak rss and cpu of the samples in each of n slots of the window that ends at end,
+// NaN where there are no samples
+func (d *Dashboard) bucket(end time.Time, window time.Duration, n int) (rss, cpu []float64) {
+	rss = make([]float64, n)
+	cpu = make([]float64, n)
+	for i := range rss {
+		rss[i], cpu[i] = math.NaN(), math.NaN()
+	}
+	start := end.Add(-window)
+	for _, s := range d.samples {
+		if s.t.Before(start) || s.t.After(end) {
+			continue
+		}
+		i := int(float64(s.t.Sub(start)) / float64(window) * float64(n))
+		if i >= n {
+			i = n - 1
+		}
+		if math.IsNaN(rss[i]) || s.rss > rss[i] {
+			rss[i] = s.rss
+		}
+		if math.IsNaN(cpu[i]) || s.cpu > cpu[i] {
+			cpu[i] = s.cpu
+		}
+	}
+	return rss, cpu
+}
+
+// formatMb formats kilobytes as megabytes
+func formatMb(kb float64) string {
+	return fmt.Sprintf("%.1f mb", kb/1024)
+}
+
+func formatWindow(w time.Duration) string {
+	if w >= time.Minute {
+		return fmt.Sprintf("%dm", int(w.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(w.Seconds()))
+}
+
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+func maxOf(values []float64) float64 {
+	max := 0.0
+	for _, v := range values {
+		if !math.IsNaN(v) && v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
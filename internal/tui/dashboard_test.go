@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+// newTestDashboard is a dashboard that draws to nowhere, without taking over the terminal
+func newTestDashboard(opts Options) *Dashboard {
+	return &Dashboard{
+		opts:    opts,
+		fd:      -1,
+		out:     bufio.NewWriter(ioutil.Discard),
+		window:  1,
+		refresh: opts.RefreshInterval,
+	}
+}
+
+func TestDashboardBucket(t *testing.T) {
+	end := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := newTestDashboard(Options{})
+	for _, s := range []struct {
+		at       time.Duration
+		rss, cpu float32
+	}{
+		{-11 * time.Second, 100, 100}, // before the window
+		{-10 * time.Second, 1, 5},     // the start of the window is in the first slot
+		{-9 * time.Second, 2, 3},
+		{-5 * time.Second, 4, 1},
+		{0, 8, 2},               // the end of the window is in the last slot
+		{time.Second, 100, 100}, // after the window
+	} {
+		d.Add(extractors.ProcessStatsData{
+			Timestamp:   end.Add(s.at),
+			MemoryUsage: extractors.MemoryUsageData{Rss: int64(s.rss)},
+			CpuUsage:    extractors.CpuUsageData{Percentage: s.cpu},
+		})
+	}
+
+	rss, cpu := d.bucket(end, 10*time.Second, 5)
+	// Each slot has the peak of its samples, the slots without samples are gaps
+	nan := math.NaN()
+	for _, tt := range []struct {
+		name      string
+		got, want []float64
+	}{
+		{"rss", rss, []float64{2, nan, 4, nan, 8}},
+		{"cpu", cpu, []float64{5, nan, 1, nan, 2}},
+	} {
+		if !equalOrNaN(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	rss, _ = d.bucket(end.Add(time.Hour), 10*time.Second, 3)
+	if !equalOrNaN(rss, []float64{nan, nan, nan}) {
+		t.Errorf("rss of a window without samples = %v, want gaps", rss)
+	}
+}
+
+func TestDashboardHandleKey(t *testing.T) {
+	var intervals []time.Duration
+	quits := 0
+	markers := 0
+	d := newTestDashboard(Options{
+		RefreshInterval:   40 * time.Millisecond,
+		OnRefreshInterval: func(interval time.Duration) { intervals = append(intervals, interval) },
+		OnQuit:            func() { quits++ },
+		OnMarker:          func() { markers++ },
+	})
+
+	// + halves the interval down to the minimum
+	for _, key := range "+=+" {
+		d.handleKey(byte(key))
+	}
+	if want := []time.Duration{20 * time.Millisecond, minRefreshInterval, minRefreshInterval}; !reflect.DeepEqual(intervals, want) {
+		t.Errorf("refresh intervals %v, want %v", intervals, want)
+	}
+	// - doubles it up to the maximum
+	intervals = nil
+	d.refresh = 8 * time.Second
+	for _, key := range "-_" {
+		d.handleKey(byte(key))
+	}
+	if want := []time.Duration{maxRefreshInterval, maxRefreshInterval}; !reflect.DeepEqual(intervals, want) {
+		t.Errorf("refresh intervals %v, want %v", intervals, want)
+	}
+
+	// The window stays within the windows there are
+	for _, key := range "[[" {
+		d.handleKey(byte(key))
+	}
+	if d.window != 0 {
+		t.Errorf("window %d after [, want 0", d.window)
+	}
+	for i := 0; i < len(Windows)+2; i++ {
+		d.handleKey(']')
+	}
+	if d.window != len(Windows)-1 {
+		t.Errorf("window %d after ], want %d", d.window, len(Windows)-1)
+	}
+
+	d.handleKey('p')
+	if !d.paused {
+		t.Error("p did not pause the dashboard")
+	}
+	d.handleKey(' ')
+	if d.paused {
+		t.Error("space did not resume the dashboard")
+	}
+	d.handleKey('m')
+	// Keys without a binding do nothing
+	d.handleKey('x')
+	if markers != 1 {
+		t.Errorf("%d markers, want 1", markers)
+	}
+
+	// q and Ctrl-C quit once, however many times they are pressed
+	for _, key := range []byte{'q', 3, 'q', 3} {
+		d.handleKey(key)
+	}
+	if quits != 1 {
+		t.Errorf("OnQuit was called %d times, want once", quits)
+	}
+}
+
+func TestDashboardHandleKeyWithoutCallbacks(t *testing.T) {
+	d := newTestDashboard(Options{RefreshInterval: time.Second})
+	for _, key := range "q+-m" {
+		d.handleKey(byte(key))
+	}
+	if d.refresh != time.Second || !d.quitting {
+		t.Errorf("refresh %s and quitting %v, want 1s and true", d.refresh, d.quitting)
+	}
+}
+
+// equalOrNaN compares the values, NaN being equal to NaN
+func equalOrNaN(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && !(math.IsNaN(a[i]) && math.IsNaN(b[i])) {
+			return false
+		}
+	}
+	return true
+}
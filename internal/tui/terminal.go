@@ -0,0 +1,64 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package tui
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is the state of a terminal before it was made raw
+type terminalState struct {
+	termios syscall.Termios
+}
+
+// makeRaw puts the terminal in raw mode, so that keys are read as they are pressed,
+// without being echoed or turned into signals, and returns the state to restore
+func makeRaw(fd int) (*terminalState, error) {
+	var termios syscall.Termios
+	if err := ioctl(fd, ioctlReadTermios, unsafe.Pointer(&termios)); err != nil {
+		return nil, fmt.Errorf("not a terminal: %w", err)
+	}
+	state := &terminalState{termios: termios}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	// The output is still processed, so that what is printed meanwhile keeps its line breaks
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&termios)); err != nil {
+		return nil, fmt.Errorf("failed to make the terminal raw: %w", err)
+	}
+
+	return state, nil
+}
+
+func restore(fd int, state *terminalState) error {
+	return ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&state.termios))
+}
+
+// size returns the width and height of the terminal in cells
+func size(fd int) (width, height int, err error) {
+	var ws struct {
+		Row    uint16
+		Col    uint16
+		Xpixel uint16
+		Ypixel uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
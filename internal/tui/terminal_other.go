@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tui
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type terminalState struct{}
+
+func makeRaw(fd int) (*terminalState, error) {
+	return nil, fmt.Errorf("the terminal ui is not supported on %s", runtime.GOOS)
+}
+
+func restore(fd int, state *terminalState) error {
+	return nil
+}
+
+func size(fd int) (width, height int, err error) {
+	return 0, 0, fmt.Errorf("the terminal ui is not supported on %s", runtime.GOOS)
+}
@@ -6,15 +6,31 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/exapsy/peekprof/internal/budget"
+	"github.com/exapsy/peekprof/internal/extractors"
+	httphandler "github.com/exapsy/peekprof/internal/handlers/http"
+	"github.com/exapsy/peekprof/internal/process"
+	"github.com/exapsy/peekprof/internal/shellwords"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "compare":
+			os.Exit(runCompare(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = func() {
-		usage := fmt.Sprintf(`Usage: %s {-pid <pid>|-cmd <command>} [-html <filename>] [-csv <filename>] [-printoutput]
-		[-refresh <integer>{ns|ms|s|m}] [-prc-output] [-parent] [-live] [-livehost <host>] [nooutput]
+		usage := fmt.Sprintf(`Usage: %[1]s {-pid <pid>|-cmd <command>|-- <command> [args...]} [-html <filename>] [-csv <filename>] [-printoutput]
+		[-refresh <integer>{ns|ms|s|m}] [-prc-output] [-parent] [-live] [-livehost <host>] [-open-browser] [nooutput]
 
 Output
 
@@ -35,26 +51,188 @@ Output
 		peak memory: 2 mb                                                  # Print peak memory
 		20.852955893s                                                      # Print profiling time
 
+Subcommands
+
+		%[1]s report [flags] <session>
+						Extract the outputs of a session recorded with -record, as if the run had been profiled with them.
+						Takes the flags of the outputs that do not need the process, e.g. -html, -csv, -json, -pprof.
+
+		%[1]s import [-o <session>] [-name <name>] [-refresh <interval>] <csv>
+						Turn a csv extracted by -csv back into a session, to report it.
+						A csv has the total memory and cpu only, so the process tree is not part of the session.
+
+		%[1]s compare [-html <filename>] [-threshold <percent>] [-fail-on <statistics>] <baseline> <run> [<run>...]
+						Compare the summary of sessions or csvs with the first of them, with the percentage deltas,
+						and overlay them on the same charts, aligned by the time elapsed since the start of each run.
+						Exits with 3 if a statistic of -fail-on is worse than the baseline by more than -threshold.
+						[default -threshold is 5, -fail-on is peak-rss,mean-rss,cpu-seconds]
+
+
+Markers
+
+		A marker is added every time the profiler receives SIGUSR1, or a POST request to /markers
+		on the http server, named by its name query parameter or its body. Markers are printed with -pretty
+		and extracted by -trace, -pprof, -json and -ndjson.
 
 Flags
 
 		-pid Track a running process
 
-		-cmd Execute a command and track its memory usage
+		-cmd Execute a command and track its memory usage.
+						The command is split into arguments like a POSIX shell does, respecting quotes and escapes,
+						but without expanding variables or interpreting pipes and redirections.
+						Leading NAME=value words are added to the environment of the command.
+
+		-- Everything after -- is the command and its arguments, as is
+
+		-shell Run the command through sh -c, so that pipes, redirections and variables work.
+						After --, the first argument is the script and the others are its $1, $2 and on.
+						The shell and every process it spawns are tracked.
+
+		-env Add a NAME=value variable to the environment of the command. Can be repeated.
+
+		-cwd The working directory of the command
+
+		-stdin Pass the profiler's stdin to the command
 
 		-html Extract a chart into an HTML file
 
+		-html-assets Where the chart loads its scripts from, one of cdn, inline, dir.
+							inline writes the scripts into the page, so that a single file is self-contained,
+							dir loads them from -html-assets-dir, relative to the page.
+							[default is cdn]
+
+		-html-assets-dir A directory with echarts.min.js and themes/westeros.js, as on the go-echarts assets host,
+							e.g. populated by make assets. inline reads the scripts from it,
+							or uses the copies embedded in peekprof if it is not set.
+
+		-raw-retention For how long the latest samples are drawn as they are by -html and the live dashboard.
+							Older samples are merged into buckets with their min, max and average,
+							which become wider the longer the run is, so that a long run takes bounded memory.
+							[default is 10m]
+
+		-chart-points How many points each chart draws at most, downsampled to keep the shape of the series
+							[default is 2000]
+
 		-csv Extract timestamped memory data into a csv
 
+		-csv-exit-status Append the exit status and rusage of the command to -csv as # comment lines,
+						which import reads back. Not every csv reader skips them, so they are left out by default.
+
+		-leak Analyse the memory trend of the run after a warm-up, to detect slow leaks.
+						The growth rate of the rss and pss is reported with how confident it is that memory grows,
+						and drawn on the memory chart.
+
+		-leak-warmup The time at the start of the run that the leak analysis ignores
+							[default is 30s]
+
+		-leak-threshold The memory growth in KB/min above which memory is likely leaking
+							[default is 100]
+
+		-json Extract the run metadata and every sample into a single json document
+
+		-ndjson Extract every sample as a line of json as soon as it is taken, so the file can be tailed.
+							The first line is the run metadata and the last line the summary.
+
+		-prom-file Periodically write the metrics of the process to a file, in the Prometheus text format,
+							for node_exporter's textfile collector. The file is replaced atomically
+							and the summary of the run is added at the end.
+
+		-prom-file-format The format of -prom-file, one of prometheus, openmetrics
+							[default is prometheus]
+
+		-prom-file-interval How often -prom-file is rewritten
+							[default is 15s]
+
+		-label Add a name=value label to every series of -prom-file. Can be repeated.
+							The command and the pid of the process are always added.
+
+		-influx Stream every sample in the InfluxDB line protocol to a file, an http write endpoint
+							like http://localhost:8086/api/v2/write?org=org&bucket=bucket, or udp://host:port.
+							Samples are sent in batches and retried, and the oldest are dropped if the endpoint is down for long.
+
+		-influx-token The token of the InfluxDB http endpoint
+							[default is $INFLUX_TOKEN]
+
+		-graphite Stream every sample in the Graphite plaintext protocol to a carbon host:port over tcp
+
+		-graphite-prefix The prefix of every Graphite metric path
+							[default is peekprof.<process name>]
+
+		-statsd Send the memory and cpu of every sample, and of each process of the tree,
+							as gauges to a StatsD agent at host:port over udp
+
+		-statsd-prefix The prefix of every StatsD metric name
+							[default is peekprof]
+
+		-statsd-tag Add a name=value tag to every StatsD metric. Can be repeated.
+							Tags switch to the DogStatsD format, where the processes of the tree are tagged by pid and name.
+
+		-statsd-sample-rate The fraction of the StatsD metrics that are sent, between 0 and 1
+							[default is 1]
+
+		-statsd-window Average the samples over a time window before sending them to StatsD, e.g. 10s
+							[default is to send every sample]
+
+		-otlp Export the samples as OpenTelemetry metrics to an OTLP/HTTP endpoint, e.g. http://localhost:4318.
+							/v1/metrics is added to an endpoint without a path.
+
+		-otlp-encoding How the OTLP payloads are encoded, one of protobuf, json
+							[default is protobuf]
+
+		-otlp-header Add a name=value header to every OTLP request, e.g. for authentication. Can be repeated.
+
+		-trace Extract the run in the Trace Event Format, to open it in chrome://tracing or Perfetto.
+							Every process of the tree is a track with counters for its rss, pss, cpu and threads,
+							and the processes that spawn or exit, and the markers, are instant events.
+
+		-pprof Extract the run as a gzipped pprof profile, for go tool pprof.
+							The samples are attributed to the phase of the run, named after the marker that started it,
+							and to the process and its ancestors in the tree, with the cpu time and the average rss.
+
+		-summary-json Extract the summary statistics of the run into a json file
+
+		-repeat Benchmark the command by running it this many times, profiling each run separately.
+							The peak rss, cpu seconds, wall time and exit code of every run are printed in a table,
+							followed by the mean, standard deviation, 95%% confidence interval of the mean, min and max over the runs.
+							-html draws the runs overlaid, the other files are not extracted.
+							A run that fails ends the benchmark with its exit code.
+
+		-warmup Run the command this many times before the -repeat runs, leaving them out of the statistics
+							[default is 0]
+
+		-record Record every sample, marker and the exit status of the run into a gzipped session file,
+							to extract any output from it later with the report subcommand
+
+		-csv-processes Extract timestamped memory data of each process in the process tree into a csv,
+							including when each process spawned and exited
+
 		-refresh The interval at which it checks the memory usage of the process
 							[default is 100ms]
 		
-		-live Combined with -html provides an html file that listens live updates for the process' stats.
+		-live Combined with -html serves a live dashboard of the process' stats at the root of -livehost,
+							and opens it in the browser. The dashboard loads the stats so far when it is opened,
+							so it also works through a tunnel, e.g. ssh -L 8089:localhost:8089.
+							[default is true]
+
+		-live-queue How many live updates are queued for each client of the dashboard
+							[default is 64]
+
+		-live-slow-clients What happens to a client of the dashboard whose queue is full, one of
+							drop-oldest, which drops its oldest update, and disconnect, which makes it reconnect.
+							Either is counted in the metrics at /metrics.
+							[default is drop-oldest]
+
+		-open-browser Open the live dashboard in the browser. Disable it on headless machines.
 							[default is true]
 
 		-livehost Is the host at which the local running server is running. This is used with -live and -html.
 							[default is localhost:8089]
 
+		-serve Run the http server at -livehost even without -html, to serve the metrics of the process
+							at /metrics in the Prometheus text format, and the live dashboard at /.
+							With -html the metrics are always served.
+
 		-pssoutput Print the corresponding output of the process to stdout & stderr
 		
 		-parent Track the parent of the provided PID. If no parent exists, an error is returned
@@ -62,7 +240,31 @@ Flags
 
 		-pretty Print in a more human-friendly - non-csv format, and print the pid of the running process.
 
-		-nooutput Stop printing the profiler's output to console`,
+		-nooutput Stop printing the profiler's output to console
+
+		-tui Show a full-screen dashboard in the terminal instead of the console output, with the current,
+							peak and average rss and cpu, their charts over a time window, and the processes of the tree
+							sorted by rss. Keys: q or Ctrl-C quits like Ctrl-C does without -tui, p pauses the dashboard,
+							m adds a marker, + and - sample more or less often, [ and ] shorten or lengthen the time window.
+							Supported on linux and darwin only.
+
+		-budget A file with the limits the process should stay within, one "<limit> = <value>" per line,
+						where <limit> is one of the flags below without the dash, or "kill"
+
+		-max-rss, -max-pss, -max-uss, -max-virtual The maximum memory, e.g. 512MiB, 2G, 300MB.
+						A number without a unit is in kilobytes (KiB), like the rest of the output. Checked on every sample.
+
+		-max-cpu-avg The maximum average cpu percentage of the whole run. Checked at exit.
+
+		-max-duration The maximum duration of the run, e.g. 2m. Checked on every sample.
+
+		-kill-on-violation Kill the command as soon as a limit that is checked on every sample is exceeded
+
+						If a limit is exceeded, the violations are reported at exit and the profiler exits with 3,
+						unless the command failed on its own.
+
+		-peak-metric The memory metric that is used for the peak memory, one of rss, rssswap, pss, uss, virtual.
+							[default is rss]`,
 
 			os.Args[0],
 		)
@@ -74,28 +276,237 @@ Flags
 	pidPtr := flag.Int("pid", 0, "Track a process by its PID")
 	cmdPtr := flag.String("cmd", "", "Track a command by running it")
 	htmlPtr := flag.String("html", "", "Extract a chart into an HTML file")
+	htmlAssetsStr := flag.String("html-assets", string(extractors.HtmlAssetsCdn), "Where the chart loads its scripts from, one of cdn, inline, dir")
+	htmlAssetsDir := flag.String("html-assets-dir", "", "A directory with echarts.min.js and themes/westeros.js, for -html-assets inline or dir")
+	rawRetention := flag.Duration("raw-retention", extractors.DefaultTimeSeriesOptions().RawRetention, "For how long the latest samples are drawn as they are by -html and the live dashboard")
+	chartPoints := flag.Int("chart-points", extractors.DefaultTimeSeriesOptions().MaxPoints, "How many points each chart draws at most")
 	csvPtr := flag.String("csv", "", "Extract timestamped memory data into a csv")
+	csvExitStatus := flag.Bool("csv-exit-status", false, "Append the exit status of the command to -csv as # comment lines")
+	leak := flag.Bool("leak", false, "Analyse the memory trend of the run to detect leaks")
+	leakWarmup := flag.Duration("leak-warmup", 30*time.Second, "The time at the start of the run that the leak analysis ignores")
+	leakThreshold := flag.Float64("leak-threshold", 100, "The memory growth in KB/min above which memory is likely leaking")
+	jsonPtr := flag.String("json", "", "Extract the run metadata and every sample into a json file")
+	ndjsonPtr := flag.String("ndjson", "", "Extract every sample as a line of json as soon as it is taken")
+	promFilePtr := flag.String("prom-file", "", "Periodically write the metrics of the process to a file, for node_exporter's textfile collector")
+	promFileFormatStr := flag.String("prom-file-format", string(extractors.MetricsFormatPrometheus), "The format of -prom-file, one of prometheus, openmetrics")
+	promFileInterval := flag.Duration("prom-file-interval", 15*time.Second, "How often -prom-file is rewritten")
+	var labels stringsFlag
+	flag.Var(&labels, "label", "Add a name=value label to every series of -prom-file. Can be repeated")
+	influxPtr := flag.String("influx", "", "Stream every sample in the InfluxDB line protocol to a file, an http(s):// write endpoint or udp://host:port")
+	influxToken := flag.String("influx-token", os.Getenv("INFLUX_TOKEN"), "The token of the InfluxDB http endpoint")
+	graphitePtr := flag.String("graphite", "", "Stream every sample in the Graphite plaintext protocol to a carbon host:port")
+	graphitePrefix := flag.String("graphite-prefix", "", "The prefix of every Graphite metric path")
+	statsdPtr := flag.String("statsd", "", "Send every sample as gauges to a StatsD agent at host:port over udp")
+	statsdPrefix := flag.String("statsd-prefix", "peekprof", "The prefix of every StatsD metric name")
+	var statsdTags stringsFlag
+	flag.Var(&statsdTags, "statsd-tag", "Add a name=value tag to every StatsD metric, in the DogStatsD format. Can be repeated")
+	statsdSampleRate := flag.Float64("statsd-sample-rate", 1, "The fraction of the StatsD metrics that are sent, between 0 and 1")
+	statsdWindow := flag.Duration("statsd-window", 0, "Average the samples over a time window before sending them to StatsD")
+	otlpPtr := flag.String("otlp", "", "Export the samples as OpenTelemetry metrics to an OTLP/HTTP endpoint")
+	otlpEncodingStr := flag.String("otlp-encoding", string(extractors.OtlpEncodingProtobuf), "How the OTLP payloads are encoded, one of protobuf, json")
+	var otlpHeaders stringsFlag
+	flag.Var(&otlpHeaders, "otlp-header", "Add a name=value header to every OTLP request. Can be repeated")
+	tracePtr := flag.String("trace", "", "Extract the run in the Trace Event Format, for chrome://tracing or Perfetto")
+	pprofPtr := flag.String("pprof", "", "Extract the run as a gzipped pprof profile, for go tool pprof")
+	summaryJsonPtr := flag.String("summary-json", "", "Extract the summary statistics of the run into a json file")
+	recordPtr := flag.String("record", "", "Record the run into a session file, for the report subcommand")
+	repeat := flag.Int("repeat", 0, "Run the command this many times, profiling each run, and report the statistics over the runs")
+	warmup := flag.Int("warmup", 0, "Run the command this many times before the -repeat runs, leaving them out of the statistics")
+	csvProcessesPtr := flag.String("csv-processes", "", "Extract timestamped memory data of each process in the process tree into a csv")
 	refreshInterval := flag.Duration("refresh", defaultRefreshInterval, "The interval at which it checks the memory usage of the process [default is"+defaultRefreshInterval.String()+"]")
 	printPssOutput := flag.Bool("prc-output", false, "Print the command's stdout and stderr")
 	parent := flag.Bool("parent", false, "Profile the parent of the process and all its children, only when no cmd is specified")
 	noOutput := flag.Bool("nooutput", false, "Stop printing the profiler's output to console")
-	live := flag.Bool("live", true, "Combined with -html provides an html file that listens live updates for the process' stats")
+	live := flag.Bool("live", true, "Combined with -html serves a live dashboard of the process' stats at the root of -livehost")
+	liveQueue := flag.Int("live-queue", httphandler.DefaultClientQueueSize, "How many live updates are queued for each client of the dashboard")
+	liveSlowClientsStr := flag.String("live-slow-clients", string(httphandler.SlowClientDropOldest), "What happens to a client of the dashboard whose queue is full, one of drop-oldest, disconnect")
+	openBrowser := flag.Bool("open-browser", true, "Open the live dashboard in the browser")
 	livehost := flag.String("livehost", "localhost:8089", `Is the host at which the local running server is running.
-		This is used with -live and -html. The profiler automatically opens the file in your browser.
+		This is used with -live and -html. The profiler automatically opens the dashboard in your browser.
 	`)
+	serve := flag.Bool("serve", false, "Run the http server at -livehost even without -html, to serve the metrics at /metrics")
+	tuiPtr := flag.Bool("tui", false, "Show a full-screen dashboard in the terminal instead of the console output")
 	pretty := flag.Bool("pretty", false, "Print in a more human-friendly - non-csv format, and print the pid of the running process.")
 	showConsole := flag.Bool("console", true, "Show the console output of the process")
+	shell := flag.Bool("shell", false, "Run the command through sh -c")
+	var env stringsFlag
+	flag.Var(&env, "env", "Add a NAME=value variable to the environment of the command. Can be repeated")
+	cwd := flag.String("cwd", "", "The working directory of the command")
+	stdin := flag.Bool("stdin", false, "Pass the profiler's stdin to the command")
+	budgetFile := flag.String("budget", "", "A file with the limits the process should stay within")
+	budgetFlags := map[string]string{
+		"max-rss":      "The maximum rss, e.g. 512MiB, or in KiB without a unit",
+		"max-pss":      "The maximum pss, e.g. 512MiB, or in KiB without a unit",
+		"max-uss":      "The maximum uss, e.g. 512MiB, or in KiB without a unit",
+		"max-virtual":  "The maximum virtual memory, e.g. 4GiB, or in KiB without a unit",
+		"max-cpu-avg":  "The maximum average cpu percentage of the whole run",
+		"max-duration": "The maximum duration of the run, e.g. 2m",
+	}
+	for name, usage := range budgetFlags {
+		flag.String(name, "", usage)
+	}
+	flag.Bool("kill-on-violation", false, "Kill the command as soon as a limit that is checked on every sample is exceeded")
+	peakMetricStr := flag.String("peak-metric", string(process.MemoryMetricRss), "The memory metric that is used for the peak memory, one of rss, rssswap, pss, uss, virtual")
 
 	flag.Parse()
 
+	peakMetric, err := process.ParseMemoryMetric(*peakMetricStr)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	promFileFormat, err := extractors.ParseMetricsFormat(*promFileFormatStr)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	otlpEncoding, err := extractors.ParseOtlpEncoding(*otlpEncodingStr)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	htmlAssetsMode, err := extractors.ParseHtmlAssetsMode(*htmlAssetsStr)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	htmlAssets, err := extractors.NewHtmlAssets(htmlAssetsMode, *htmlAssetsDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	slowClientPolicy, err := httphandler.ParseSlowClientPolicy(*liveSlowClientsStr)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *liveQueue < 1 {
+		fmt.Println("-live-queue should be at least 1")
+		os.Exit(1)
+	}
+
+	if *chartPoints < 3 {
+		fmt.Println("-chart-points should be at least 3")
+		os.Exit(1)
+	}
+	timeSeries := extractors.DefaultTimeSeriesOptions()
+	timeSeries.RawRetention = *rawRetention
+	timeSeries.MaxPoints = *chartPoints
+
+	metricLabels, err := parseLabels(labels)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *statsdSampleRate <= 0 || *statsdSampleRate > 1 {
+		fmt.Println("-statsd-sample-rate should be greater than 0 and at most 1")
+		os.Exit(1)
+	}
+
+	if *tuiPtr && *stdin {
+		fmt.Println("-tui reads the keys from stdin, so it cannot be combined with -stdin")
+		os.Exit(1)
+	}
+
+	b, err := parseBudget(*budgetFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var leakAnalysis *extractors.LeakAnalysisOptions
+	if *leak {
+		opts := extractors.NewLeakAnalysisOptions(*leakWarmup, *leakThreshold)
+		leakAnalysis = &opts
+	}
+
+	appOpts := &AppOptions{
+		HtmlFilename:         *htmlPtr,
+		HtmlAssets:           htmlAssets,
+		TimeSeries:           timeSeries,
+		CsvFilename:          *csvPtr,
+		CsvExitStatus:        *csvExitStatus,
+		CsvProcessesFilename: *csvProcessesPtr,
+		RefreshInterval:      *refreshInterval,
+		Host:                 *livehost,
+		ChartLiveUpdates:     *live,
+		OpenBrowser:          *openBrowser,
+		LiveQueueSize:        *liveQueue,
+		SlowClientPolicy:     slowClientPolicy,
+		NoProfilerOutput:     *noOutput,
+		Tui:                  *tuiPtr,
+		Pretty:               *pretty,
+		ShowConsole:          *showConsole,
+		PeakMetric:           peakMetric,
+		Budget:               b,
+		SummaryJsonFilename:  *summaryJsonPtr,
+		SessionFilename:      *recordPtr,
+		LeakAnalysis:         leakAnalysis,
+		JsonFilename:         *jsonPtr,
+		NdjsonFilename:       *ndjsonPtr,
+		Serve:                *serve,
+		Influx:               *influxPtr,
+		InfluxToken:          *influxToken,
+		Graphite:             *graphitePtr,
+		GraphitePrefix:       *graphitePrefix,
+		Statsd:               *statsdPtr,
+		StatsdPrefix:         *statsdPrefix,
+		StatsdTags:           splitAssignments(statsdTags),
+		StatsdSampleRate:     *statsdSampleRate,
+		StatsdWindow:         *statsdWindow,
+		TraceFilename:        *tracePtr,
+		PprofFilename:        *pprofPtr,
+		Otlp:                 *otlpPtr,
+		OtlpEncoding:         otlpEncoding,
+		OtlpHeaders:          splitAssignments(otlpHeaders),
+		PrometheusFilename:   *promFilePtr,
+		PrometheusFormat:     promFileFormat,
+		PrometheusInterval:   *promFileInterval,
+		Labels:               metricLabels,
+	}
+
+	if *repeat > 0 {
+		os.Exit(runBenchmark(benchmarkOptions{
+			Repeat:       *repeat,
+			Warmup:       *warmup,
+			HtmlFilename: *htmlPtr,
+			Command: commandOptions{
+				Cmd:         *cmdPtr,
+				Args:        flag.Args(),
+				Shell:       *shell,
+				Env:         env,
+				Cwd:         *cwd,
+				Stdin:       *stdin,
+				PrintOutput: *printPssOutput,
+			},
+			App: *appOpts,
+		}))
+	}
+
 	var ecmd *exec.Cmd // The command executed if -pid is not given
 	usePid := false    // Inspect another running process if true
+	cmdArgs := flag.Args()
 
-	if *cmdPtr == "" && *pidPtr <= 0 {
+	if *cmdPtr == "" && len(cmdArgs) == 0 && *pidPtr <= 0 {
 		fmt.Println("A PID or a command should be specified")
 		flag.Usage()
 		return
 	}
+	if *cmdPtr != "" && len(cmdArgs) > 0 {
+		fmt.Println("Either -cmd or a command after -- should be specified, not both")
+		flag.Usage()
+		os.Exit(1)
+	}
 
 	if *pidPtr > 1 {
 		usePid = true
@@ -120,18 +531,25 @@ Flags
 			}
 		}
 	} else {
-		if *cmdPtr == "" {
+		if *cmdPtr == "" && len(cmdArgs) == 0 {
 			flag.Usage()
 			return
 		}
 
-		args := strings.Fields(*cmdPtr)
-		ecmd = exec.Command(args[0], args[1:]...)
-		if *printPssOutput {
-			ecmd.Stdout = NewCommandStdout()
-			ecmd.Stderr = NewCommandStderr()
+		ecmd, err = newCommand(commandOptions{
+			Cmd:         *cmdPtr,
+			Args:        cmdArgs,
+			Shell:       *shell,
+			Env:         env,
+			Cwd:         *cwd,
+			Stdin:       *stdin,
+			PrintOutput: *printPssOutput,
+		})
+		if err != nil {
+			fmt.Printf("invalid command: %s\n", err)
+			os.Exit(1)
 		}
-		err := ecmd.Start()
+		err = ecmd.Start()
 		if err != nil {
 			fmt.Printf("failed to start command: %s\n", err)
 			os.Exit(1)
@@ -143,35 +561,146 @@ Flags
 		}
 	}
 
-	a := NewApp(&AppOptions{
-		PID:              int32(*pidPtr),
-		RunsExecutable:   !usePid,
-		Cmd:              ecmd,
-		HtmlFilename:     *htmlPtr,
-		CsvFilename:      *csvPtr,
-		RefreshInterval:  *refreshInterval,
-		Host:             *livehost,
-		ChartLiveUpdates: *live,
-		NoProfilerOutput: *noOutput,
-		Pretty:           *pretty,
-		ShowConsole:      *showConsole,
-	})
+	appOpts.PID = int32(*pidPtr)
+	appOpts.RunsExecutable = !usePid
+	appOpts.Cmd = ecmd
+	a := NewApp(appOpts)
 	a.Start()
+	os.Exit(a.ExitCode())
+}
+
+// parseBudget loads the budget file, if any, and overrides its limits
+// with the limits given as flags
+func parseBudget(filename string) (budget.Budget, error) {
+	b := budget.Budget{}
+	if filename != "" {
+		var err error
+		b, err = budget.LoadFile(filename)
+		if err != nil {
+			return b, err
+		}
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch f.Name {
+		case "max-rss", "max-pss", "max-uss", "max-virtual", "max-cpu-avg", "max-duration":
+			err = b.Set(f.Name, f.Value.String())
+		case "kill-on-violation":
+			err = b.Set("kill", f.Value.String())
+		}
+	})
+
+	return b, err
+}
+
+// splitAssignments splits name=value pairs
+func splitAssignments(assignments []string) [][2]string {
+	var pairs [][2]string
+	for _, a := range assignments {
+		i := strings.IndexByte(a, '=')
+		pairs = append(pairs, [2]string{a[:i], a[i+1:]})
+	}
+	return pairs
+}
+
+// parseLabels splits name=value labels and validates their names
+func parseLabels(labels []string) ([][2]string, error) {
+	var parsed [][2]string
+	for _, l := range splitAssignments(labels) {
+		name, value := l[0], l[1]
+		if !extractors.IsValidMetricLabelName(name) {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		if name == "command" || name == "pid" {
+			return nil, fmt.Errorf("label %q is reserved", name)
+		}
+		parsed = append(parsed, [2]string{name, value})
+	}
+	return parsed, nil
+}
+
+// commandOptions describe how the profiled command is spawned
+type commandOptions struct {
+	// Cmd is the command line given with -cmd
+	Cmd string
+	// Args is the command and its arguments given after --
+	Args []string
+	// Shell runs the command through sh -c
+	Shell       bool
+	Env         []string
+	Cwd         string
+	Stdin       bool
+	PrintOutput bool
+}
+
+func newCommand(o commandOptions) (*exec.Cmd, error) {
+	var argv []string
+	var assignments []string
+	switch {
+	case o.Shell:
+		argv = []string{"sh", "-c", o.Cmd}
+		if len(o.Args) > 0 {
+			// The first argument is the script, the others are passed to it as $1, $2 and on, as they are
+			argv = append([]string{"sh", "-c", o.Args[0], "sh"}, o.Args[1:]...)
+		}
+	case len(o.Args) > 0:
+		argv = o.Args
+	default:
+		words, err := shellwords.Split(o.Cmd)
+		if err != nil {
+			return nil, err
+		}
+		assignments, argv = shellwords.SplitAssignments(words)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	ecmd := exec.Command(argv[0], argv[1:]...)
+	if len(o.Env) > 0 || len(assignments) > 0 {
+		ecmd.Env = append(append(os.Environ(), o.Env...), assignments...)
+	}
+	ecmd.Dir = o.Cwd
+	if o.Stdin {
+		ecmd.Stdin = os.Stdin
+	}
+	if o.PrintOutput {
+		ecmd.Stdout = NewCommandStdout()
+		ecmd.Stderr = NewCommandStderr()
+	}
+
+	return ecmd, nil
+}
+
+// stringsFlag is a flag that can be repeated, collecting every value
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("expected NAME=value but got %q", v)
+	}
+	*f = append(*f, v)
+	return nil
 }
 
 func getParentPid(pid int) (int, error) {
-	out, err := exec.Command(
-		"bash", "-c",
-		fmt.Sprintf(`cat /proc/%d/status | grep PPid | sed "s/^PPid:\ *\t*\([0-9]*\)/\1/"`, pid),
-	).Output()
+	p, err := process.NewLinuxProcess(int32(pid))
 	if err != nil {
 		return 0, fmt.Errorf("process has no parent: %w", err)
 	}
-	ppid, err := strconv.Atoi(strings.Trim(string(out), "\n "))
+	ppid, err := p.GetParentPid()
 	if err != nil {
-		return 0, fmt.Errorf("failed to convert pid to int: %s", err)
+		return 0, fmt.Errorf("process has no parent: %w", err)
 	}
-	return ppid, nil
+	return int(ppid), nil
 }
 
 type CommandStdout struct{}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewCommandShell(t *testing.T) {
+	tests := []struct {
+		name string
+		o    commandOptions
+		want string
+	}{
+		{
+			name: "cmd",
+			o:    commandOptions{Cmd: `printf '%s|' "a  b" c | tr '|' ,`, Shell: true},
+			want: "a  b,c,",
+		},
+		{
+			name: "script and its arguments",
+			o:    commandOptions{Args: []string{`printf '%s|' "$@"`, "a  b", "c;d", "$HOME", "*"}, Shell: true},
+			want: "a  b|c;d|$HOME|*|",
+		},
+		{
+			name: "script alone",
+			o:    commandOptions{Args: []string{`echo "$#"`}, Shell: true},
+			want: "0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := newCommand(tt.o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cmd.Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
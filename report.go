@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/exapsy/peekprof/internal/extractors"
+)
+
+// runReport extracts the outputs of a recorded session,
+// the same as if the run had been profiled with them
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s report [flags] <session>
+
+		Extracts the outputs of a session recorded with -record, or of the ndjson of a run.
+		The summary is printed, and recalculated for the outputs that include it.
+
+Flags
+
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	htmlPtr := fs.String("html", "", "Extract a chart into an HTML file")
+	htmlAssetsStr := fs.String("html-assets", string(extractors.HtmlAssetsCdn), "Where the chart loads its scripts from, one of cdn, inline, dir")
+	htmlAssetsDir := fs.String("html-assets-dir", "", "A directory with echarts.min.js and themes/westeros.js, for -html-assets inline or dir")
+	rawRetention := fs.Duration("raw-retention", extractors.DefaultTimeSeriesOptions().RawRetention, "For how long the latest samples are drawn as they are by -html")
+	chartPoints := fs.Int("chart-points", extractors.DefaultTimeSeriesOptions().MaxPoints, "How many points each chart draws at most")
+	csvPtr := fs.String("csv", "", "Extract timestamped memory data into a csv")
+	csvExitStatus := fs.Bool("csv-exit-status", false, "Append the exit status of the command to -csv as # comment lines")
+	csvProcessesPtr := fs.String("csv-processes", "", "Extract the stats of each process in the process tree into a csv")
+	jsonPtr := fs.String("json", "", "Extract the samples and the run metadata into a json file")
+	ndjsonPtr := fs.String("ndjson", "", "Extract the samples into a newline delimited json file")
+	summaryJsonPtr := fs.String("summary-json", "", "Extract the summary statistics of the run into a json file")
+	tracePtr := fs.String("trace", "", "Extract the run in the Trace Event Format")
+	pprofPtr := fs.String("pprof", "", "Extract the run as a gzipped pprof profile, for go tool pprof")
+	leak := fs.Bool("leak", false, "Analyse the memory trend of the run to detect leaks")
+	leakWarmup := fs.Duration("leak-warmup", 30*time.Second, "The time at the start of the run that the leak analysis ignores")
+	leakThreshold := fs.Float64("leak-threshold", 100, "The memory growth in KB/min above which memory is likely leaking")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *chartPoints < 3 {
+		fmt.Println("-chart-points should be at least 3")
+		return 2
+	}
+
+	session, err := extractors.ReadSession(fs.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	run := session.Run
+	if run.RefreshInterval <= 0 {
+		run.RefreshInterval = 100 * time.Millisecond
+	}
+
+	var exts []interface{}
+	if *csvPtr != "" {
+		csvOpts := extractors.NewCsvExtractorOptions(*csvPtr)
+		csvOpts.WithExitStatus(*csvExitStatus)
+		exts = append(exts, csvOpts)
+	}
+	if *summaryJsonPtr != "" {
+		exts = append(exts, extractors.NewSummaryJsonExtractorOptions(*summaryJsonPtr))
+	}
+	if *jsonPtr != "" {
+		exts = append(exts, extractors.NewJsonExtractorOptions(*jsonPtr, run))
+	}
+	if *ndjsonPtr != "" {
+		exts = append(exts, extractors.NewNdjsonExtractorOptions(*ndjsonPtr, run))
+	}
+	if *tracePtr != "" {
+		exts = append(exts, extractors.NewTraceExtractorOptions(*tracePtr, run))
+	}
+	if *pprofPtr != "" {
+		exts = append(exts, extractors.NewPprofExtractorOptions(*pprofPtr, run))
+	}
+	if *csvProcessesPtr != "" {
+		exts = append(exts, extractors.NewCsvProcessesExtractorOptions(*csvProcessesPtr))
+	}
+	if *htmlPtr != "" {
+		htmlAssetsMode, err := extractors.ParseHtmlAssetsMode(*htmlAssetsStr)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		htmlAssets, err := extractors.NewHtmlAssets(htmlAssetsMode, *htmlAssetsDir)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		timeSeries := extractors.DefaultTimeSeriesOptions()
+		timeSeries.RawRetention = *rawRetention
+		timeSeries.MaxPoints = *chartPoints
+
+		chartExtractorOpts := extractors.NewChartExtractorOptions(run.Name, *htmlPtr)
+		chartExtractorOpts.WithAssets(htmlAssets)
+		chartExtractorOpts.WithTimeSeries(timeSeries)
+		exts = append(exts, chartExtractorOpts)
+	}
+	extractor := extractors.NewExtractors(exts...)
+
+	summary := extractors.NewSummaryCollector(run.RefreshInterval)
+	if *leak {
+		summary.AnalyzeLeaks(extractors.NewLeakAnalysisOptions(*leakWarmup, *leakThreshold))
+	}
+
+	for _, r := range session.Records {
+		if r.Sample != nil {
+			summary.Add(*r.Sample)
+			if err := extractor.Add(*r.Sample); err != nil {
+				fmt.Printf("error while extracting: %s", err)
+			}
+		}
+		if r.Marker != nil {
+			extractor.AddMarker(*r.Marker)
+		}
+	}
+
+	s := summary.Summary()
+	extractor.SetSummary(s)
+	if session.ExitStatus != nil {
+		extractor.SetExitStatus(*session.ExitStatus)
+	}
+	if err := extractor.StopAndExtract(); err != nil {
+		fmt.Printf("failed writing files: %s\n", err)
+		return 1
+	}
+
+	// An imported session has no pid or host
+	if run.Pid > 0 {
+		fmt.Printf("\n%s (pid %d) on %s, started at %s\n", run.Cmdline, run.Pid, run.Hostname, run.StartTime.Format(time.RFC3339))
+	} else {
+		fmt.Printf("\n%s, started at %s\n", run.Cmdline, run.StartTime.Format(time.RFC3339))
+	}
+	printSummary(s)
+	if session.ExitStatus != nil {
+		printExitStatusFields(*session.ExitStatus)
+	}
+
+	return 0
+}
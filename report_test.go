@@ -0,0 +1,15 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReportRejectsFewChartPoints(t *testing.T) {
+	session := filepath.Join(t.TempDir(), "run.peekprof")
+	for _, points := range []string{"2", "0", "-1"} {
+		if code := runReport([]string{"-chart-points", points, "-html", "run.html", session}); code != 2 {
+			t.Errorf("report -chart-points %s exited with %d, want 2", points, code)
+		}
+	}
+}
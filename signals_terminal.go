@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+// +build linux darwin freebsd openbsd netbsd dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// signalFromTerminal reports whether sig is a Ctrl-C that the terminal sent to the command as well.
+// The terminal sends it to its whole foreground process group, which the command is part of
+// unless it moved to a process group of its own.
+func signalFromTerminal(sig os.Signal, pid int) bool {
+	if sig != os.Interrupt {
+		return false
+	}
+	pgrp := syscall.Getpgrp()
+	if cmdPgrp, err := syscall.Getpgid(pid); err != nil || cmdPgrp != pgrp {
+		return false
+	}
+
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+	var foreground int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&foreground)))
+	if errno != 0 {
+		return false
+	}
+	return int(foreground) == pgrp
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package main
+
+import (
+	"os"
+)
+
+// signalFromTerminal reports whether sig is a Ctrl-C that the terminal sent to the command as well,
+// which is not known on this platform, so every signal is forwarded
+func signalFromTerminal(sig os.Signal, pid int) bool {
+	return false
+}
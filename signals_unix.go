@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// markerSignals are the signals that add a marker to the run
+var markerSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"os"
+)
+
+// markerSignals are the signals that add a marker to the run,
+// there are no user signals on windows
+var markerSignals = []os.Signal{}